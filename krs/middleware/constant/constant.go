@@ -7,6 +7,22 @@ const (
 	ErrInvalidToken          = "invalid token"
 	ErrMessageUnauthorized   = "Unauthorized"
 
+	// business code for storage quota exceeded
+	ErrCodeQuotaExceeded    = 413
+	ErrMessageQuotaExceeded = "Storage quota exceeded"
+
+	// business codes for storage errors, same as the HTTP status
+	ErrCodeNotFound              = 404
+	ErrMessageNotFound           = "File not found"
+	ErrCodeAlreadyExists         = 409
+	ErrMessageAlreadyExists      = "File already exists"
+	ErrCodePermissionDenied      = 403
+	ErrMessagePermissionDenied   = "Permission denied"
+	ErrCodeInvalidArgument       = 400
+	ErrMessageInvalidArgument    = "Invalid argument"
+	ErrCodeBackendUnavailable    = 503
+	ErrMessageBackendUnavailable = "Storage backend unavailable"
+
 	// default context key for jwt
 	DefaultJwtContextKey = "user_id"
 	// default context key for token
@@ -42,6 +42,12 @@ func errorEncoder(w http.ResponseWriter, r *http.Request, err error) {
 		return
 	}
 
+	// upload 返回的存储错误
+	if uploadErr := errresp.NewUploadError(err); uploadErr != nil {
+		handleErrorResponse(w, uploadErr)
+		return
+	}
+
 	transporthttp.DefaultErrorEncoder(w, r, err)
 }
 
@@ -81,7 +87,8 @@ func handleRedirectResponse(w http.ResponseWriter, redirect *errresp.Redirect) {
 
 func handleErrorResponse(w http.ResponseWriter, authErr *errresp.Error) {
 	w.Header().Set("Content-Type", "application/json")
-	// Note: Consider setting appropriate HTTP status code based on error code
-	// w.WriteHeader(authErr.Code)
+	if authErr.Status != 0 {
+		w.WriteHeader(authErr.Status)
+	}
 	_, _ = w.Write([]byte(authErr.Error()))
 }
@@ -1,7 +1,11 @@
 package errresp
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+
 	"github.com/nuominmin/biz/krs/middleware/constant"
 )
 
@@ -42,10 +46,14 @@ func NewRedirect(url string, permanent bool) *Redirect {
 type Error struct {
 	Code    int
 	Message string
+	// HTTP 状态码，为 0 时返回 200，只通过 Code 区分错误
+	Status int
 }
 
 func (e *Error) Error() string {
-	return fmt.Sprintf(`{"code": %d, "message": "%s"}`, e.Code, e.Message)
+	// 错误信息中可能包含引号
+	message, _ := json.Marshal(e.Message)
+	return fmt.Sprintf(`{"code": %d, "message": %s}`, e.Code, message)
 }
 
 func NewAuthorizationError(format string, a ...any) *Error {
@@ -57,3 +65,100 @@ func NewAuthorizationError(format string, a ...any) *Error {
 		Message: fmt.Sprintf(format, a...),
 	}
 }
+
+func NewQuotaExceededError(format string, a ...any) *Error {
+	if format == "" {
+		format = constant.ErrMessageQuotaExceeded
+	}
+	return &Error{
+		Code:    constant.ErrCodeQuotaExceeded,
+		Message: fmt.Sprintf(format, a...),
+		Status:  http.StatusRequestEntityTooLarge,
+	}
+}
+
+func NewNotFoundError(format string, a ...any) *Error {
+	return newError(http.StatusNotFound, constant.ErrCodeNotFound, constant.ErrMessageNotFound, format, a...)
+}
+
+func NewAlreadyExistsError(format string, a ...any) *Error {
+	return newError(http.StatusConflict, constant.ErrCodeAlreadyExists, constant.ErrMessageAlreadyExists, format, a...)
+}
+
+func NewPermissionDeniedError(format string, a ...any) *Error {
+	return newError(http.StatusForbidden, constant.ErrCodePermissionDenied, constant.ErrMessagePermissionDenied, format, a...)
+}
+
+func NewInvalidArgumentError(format string, a ...any) *Error {
+	return newError(http.StatusBadRequest, constant.ErrCodeInvalidArgument, constant.ErrMessageInvalidArgument, format, a...)
+}
+
+func NewBackendUnavailableError(format string, a ...any) *Error {
+	return newError(http.StatusServiceUnavailable, constant.ErrCodeBackendUnavailable, constant.ErrMessageBackendUnavailable, format, a...)
+}
+
+func newError(status, code int, defaultMessage, format string, a ...any) *Error {
+	message := defaultMessage
+	if format != "" {
+		message = fmt.Sprintf(format, a...)
+	}
+	return &Error{
+		Code:    code,
+		Message: message,
+		Status:  status,
+	}
+}
+
+// HTTPStatusError 带有 HTTP 状态码的错误，例如 upload 的错误分类
+type HTTPStatusError interface {
+	error
+	HTTPStatus() int
+}
+
+// KindError 带有分类名称的错误，例如 upload 的错误分类
+type KindError interface {
+	HTTPStatusError
+	Kind() string
+}
+
+// upload 错误分类的名称
+const (
+	KindNotFound           = "not_found"
+	KindAlreadyExists      = "already_exists"
+	KindPermissionDenied   = "permission_denied"
+	KindQuotaExceeded      = "quota_exceeded"
+	KindInvalidArgument    = "invalid_argument"
+	KindBackendUnavailable = "backend_unavailable"
+)
+
+// NewUploadError 按错误链中 KindError 的分类返回对应的业务码和信息，未知的分类按 HTTPStatusError 的状态码返回，
+// 无法分类时返回 nil。存储不可用等服务端错误只返回默认信息，不暴露存储配置
+func NewUploadError(err error) *Error {
+	var kindErr KindError
+	if errors.As(err, &kindErr) {
+		switch kindErr.Kind() {
+		case KindQuotaExceeded:
+			return NewQuotaExceededError("%v", err)
+		case KindNotFound:
+			return NewNotFoundError("%v", err)
+		case KindAlreadyExists:
+			return NewAlreadyExistsError("%v", err)
+		case KindPermissionDenied:
+			return NewPermissionDeniedError("%v", err)
+		case KindInvalidArgument:
+			return NewInvalidArgumentError("%v", err)
+		case KindBackendUnavailable:
+			return NewBackendUnavailableError("")
+		}
+	}
+
+	var statusErr HTTPStatusError
+	if !errors.As(err, &statusErr) {
+		return nil
+	}
+	status := statusErr.HTTPStatus()
+	if status >= http.StatusInternalServerError {
+		return newError(status, status, http.StatusText(status), "")
+	}
+	return newError(status, status, http.StatusText(status), "%v", err)
+}
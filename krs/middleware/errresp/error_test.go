@@ -0,0 +1,76 @@
+package errresp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/nuominmin/biz/krs/middleware/constant"
+	"github.com/nuominmin/biz/upload"
+)
+
+// statusError 只有状态码、没有分类名称的错误
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("status %d", int(e))
+}
+
+func (e statusError) HTTPStatus() int {
+	return int(e)
+}
+
+// tooLargeError 状态码为 413 但不属于配额分类的错误
+type tooLargeError struct{}
+
+func (tooLargeError) Error() string {
+	return "request entity too large"
+}
+
+func (tooLargeError) HTTPStatus() int {
+	return http.StatusRequestEntityTooLarge
+}
+
+func (tooLargeError) Kind() string {
+	return "entity_too_large"
+}
+
+func TestNewUploadError(t *testing.T) {
+	cases := []struct {
+		name    string
+		err     error
+		status  int
+		code    int
+		message string
+	}{
+		{"not found", fmt.Errorf("stat: %w", upload.ErrNotFound), http.StatusNotFound, constant.ErrCodeNotFound, "stat: not found"},
+		{"already exists", upload.ErrAlreadyExists, http.StatusConflict, constant.ErrCodeAlreadyExists, "already exists"},
+		{"permission denied", upload.ErrPermissionDenied, http.StatusForbidden, constant.ErrCodePermissionDenied, "permission denied"},
+		{"quota exceeded", upload.ErrQuotaExceeded, http.StatusRequestEntityTooLarge, constant.ErrCodeQuotaExceeded, "storage quota exceeded"},
+		{"invalid argument", upload.ErrContentMismatch, http.StatusBadRequest, constant.ErrCodeInvalidArgument, upload.ErrContentMismatch.Error()},
+		{"backend unavailable", fmt.Errorf("dial oss.internal: %w", upload.ErrBackendUnavailable), http.StatusServiceUnavailable,
+			constant.ErrCodeBackendUnavailable, constant.ErrMessageBackendUnavailable},
+		{"storage error", &upload.StorageError{Op: "download", Key: "a.png", Kind: upload.ErrNotFound, Err: errors.New("NoSuchKey")},
+			http.StatusNotFound, constant.ErrCodeNotFound, "NoSuchKey"},
+		{"413 of other kind", tooLargeError{}, http.StatusRequestEntityTooLarge, http.StatusRequestEntityTooLarge, "request entity too large"},
+		{"status only", statusError(http.StatusTeapot), http.StatusTeapot, http.StatusTeapot, "status 418"},
+		{"server error hides message", statusError(http.StatusBadGateway), http.StatusBadGateway, http.StatusBadGateway,
+			http.StatusText(http.StatusBadGateway)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := NewUploadError(c.err)
+			if got == nil {
+				t.Fatal("expected error response")
+			}
+			if got.Status != c.status || got.Code != c.code || got.Message != c.message {
+				t.Errorf("got %+v, want status %d, code %d, message %q", got, c.status, c.code, c.message)
+			}
+		})
+	}
+
+	if got := NewUploadError(errors.New("plain")); got != nil {
+		t.Errorf("unclassified error should return nil, got %+v", got)
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"path"
+	"strings"
+
+	"github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/nuominmin/biz/krs/types"
+	"github.com/nuominmin/biz/pagination"
+	"github.com/nuominmin/biz/upload"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 存储驱动单次列出的最大文件数，OSS 和 S3 为 1000
+const listBatchSize = 1000
+
+// listFilesRequest 文件浏览请求
+type listFilesRequest struct {
+	pagination.PageReq
+	// root 下的子目录
+	Dir string `json:"dir"`
+	// 上一页返回的游标，设置时忽略 page
+	Cursor string `json:"cursor"`
+}
+
+// ListFiles 分页浏览 root 目录下的文件，按 key 排序。
+// 查询参数 dir 为子目录，page 和 page_size 为分页参数。对象存储按页码翻页需要从头列出，
+// 连续翻页时应使用上一页返回的 next_cursor 作为 cursor。
+// 配置 WithJwt 时需要登录，私有文件只对其上传者可见，页码和每页文件数只计算可见的文件
+func (s *service) ListFiles(uploadSvc upload.Service, root string) func(http.Context) error {
+	return func(ctx http.Context) error {
+		owner, err := s.owner(ctx)
+		if err != nil {
+			return err
+		}
+
+		var req listFilesRequest
+		if err = ctx.BindQuery(&req); err != nil {
+			return status.Errorf(codes.InvalidArgument, "Invalid request: %v", err)
+		}
+		req.Sanitize()
+
+		// 不允许通过 .. 访问 root 之外的文件
+		prefix := strings.TrimPrefix(path.Join(root, path.Clean("/"+req.Dir)), "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+		if req.Cursor != "" && !strings.HasPrefix(req.Cursor, prefix) {
+			return status.Errorf(codes.InvalidArgument, "Invalid cursor")
+		}
+
+		cursor := req.Cursor
+		if cursor == "" && req.Page > 1 {
+			// 跳过前面页的可见文件
+			if cursor, err = listVisible(uploadSvc, prefix, "", owner, int(req.Offset()), nil); err != nil {
+				return uploadError(err, codes.Internal, "Failed to list files: %v", err)
+			} else if cursor == "" {
+				return ctx.JSON(200, types.NewSuccessResponse(types.FileList{List: []types.File{}, Page: req.Page, PageSize: req.PageSize}))
+			}
+		}
+
+		data := types.FileList{
+			List:     make([]types.File, 0, req.PageSize),
+			PageSize: req.PageSize,
+		}
+		if req.Cursor == "" {
+			data.Page = req.Page
+		}
+		data.NextCursor, err = listVisible(uploadSvc, prefix, cursor, owner, int(req.PageSize), func(file upload.FileInfo) {
+			data.List = append(data.List, types.File{
+				Key:         file.Key,
+				Size:        file.Size,
+				ModTime:     file.ModTime,
+				ContentType: uploadSvc.GetContentType(file.Key),
+			})
+		})
+		if err != nil {
+			return uploadError(err, codes.Internal, "Failed to list files: %v", err)
+		}
+
+		return ctx.JSON(200, types.NewSuccessResponse(data))
+	}
+}
+
+// visibleFilter 可以批量判断文件是否可见的上传服务，upload 的实现每批只查询一次文件记录
+type visibleFilter interface {
+	FilterVisible(files []upload.FileInfo, owner string) ([]upload.FileInfo, error)
+}
+
+// listVisible 从 cursor 之后列出 n 个对 owner 可见的文件并依次调用 visit（可以为 nil），
+// 每批最多列出 listBatchSize 个文件，只从上一批的游标开始列出。返回继续列出的游标，没有更多文件时为空
+func listVisible(uploadSvc upload.Service, prefix, cursor, owner string, n int, visit func(upload.FileInfo)) (string, error) {
+	for n > 0 {
+		files, next, err := uploadSvc.List(prefix, cursor, min(n, listBatchSize))
+		if err != nil {
+			return "", err
+		}
+		if files, err = filterVisible(uploadSvc, files, owner); err != nil {
+			return "", err
+		}
+		for i, file := range files {
+			if visit != nil {
+				visit(file)
+			}
+			if n--; n == 0 && (next != "" || i < len(files)-1) {
+				// 游标为最后一个对象的 key，从这里继续列出
+				return file.Key, nil
+			}
+		}
+		if next == "" {
+			return "", nil
+		}
+		cursor = next
+	}
+	return "", nil
+}
+
+// filterVisible 返回对 owner 可见的文件，私有文件只对其上传者可见，无法确定权限时不可见
+func filterVisible(uploadSvc upload.Service, files []upload.FileInfo, owner string) ([]upload.FileInfo, error) {
+	if filter, ok := uploadSvc.(visibleFilter); ok {
+		return filter.FilterVisible(files, owner)
+	}
+	visible := make([]upload.FileInfo, 0, len(files))
+	for _, file := range files {
+		if fileVisible(uploadSvc, file.Key, owner) {
+			visible = append(visible, file)
+		}
+	}
+	return visible, nil
+}
+
+// fileVisible 文件是否对 owner 可见，私有文件只对其上传者可见，无法确定权限时不可见
+func fileVisible(uploadSvc upload.Service, key, owner string) bool {
+	acl, err := uploadSvc.FileACL(key)
+	if err != nil {
+		return false
+	}
+	if acl != upload.ACLPrivate {
+		return true
+	}
+	record, err := uploadSvc.GetRecord(key)
+	return err == nil && owner != "" && record.HasOwner(owner)
+}
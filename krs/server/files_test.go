@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	nhttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/nuominmin/biz/upload"
+)
+
+func TestListFilesHidesPrivate(t *testing.T) {
+	uploadSvc, err := upload.New("http://127.0.0.1:3000", "goods", upload.WithDriverParam(upload.ParamRoot, t.TempDir()),
+		upload.WithFileRepository(upload.NewMemoryFileRepository()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = uploadSvc.UploadFile(strings.NewReader("a"), "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = uploadSvc.UploadFile(strings.NewReader("b"), "b.txt", upload.WithOwner("1"), upload.WithACL(upload.ACLPrivate)); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &service{opts: newOptions()}
+	srv := khttp.NewServer()
+	srv.Route("/").GET("/files", svc.ListFiles(uploadSvc, "uploads/goods"))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := nhttp.Get(ts.URL + "/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Data struct {
+			List []struct {
+				Key string `json:"key"`
+			} `json:"list"`
+		} `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	// 私有文件只对其上传者可见
+	if len(result.Data.List) != 1 || result.Data.List[0].Key != "uploads/goods/a.txt" {
+		t.Errorf("list = %+v", result.Data.List)
+	}
+}
+
+func TestListFilesPagesCountVisible(t *testing.T) {
+	uploadSvc, err := upload.New("http://127.0.0.1:3000", "goods", upload.WithDriverParam(upload.ParamRoot, t.TempDir()),
+		upload.WithFileRepository(upload.NewMemoryFileRepository()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+		var opts []upload.UploadOption
+		if name == "b.txt" || name == "d.txt" {
+			opts = append(opts, upload.WithOwner("1"), upload.WithACL(upload.ACLPrivate))
+		}
+		if _, err = uploadSvc.UploadFile(strings.NewReader(name), name, opts...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	svc := &service{opts: newOptions()}
+	srv := khttp.NewServer()
+	srv.Route("/").GET("/files", svc.ListFiles(uploadSvc, "uploads/goods"))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	list := func(query string) (string, string) {
+		t.Helper()
+		resp, err := nhttp.Get(ts.URL + "/files?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var result struct {
+			Data struct {
+				List []struct {
+					Key string `json:"key"`
+				} `json:"list"`
+				NextCursor string `json:"next_cursor"`
+			} `json:"data"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, file := range result.Data.List {
+			keys = append(keys, strings.TrimPrefix(file.Key, "uploads/goods/"))
+		}
+		return strings.Join(keys, ","), result.Data.NextCursor
+	}
+
+	// 每页只计算可见的文件，私有文件不占用页码
+	if keys, next := list("page=1&page_size=2"); keys != "a.txt,c.txt" || next != "uploads/goods/c.txt" {
+		t.Errorf("page 1 = %s, next %q", keys, next)
+	}
+	if keys, next := list("page=2&page_size=2"); keys != "e.txt" || next != "" {
+		t.Errorf("page 2 = %s, next %q", keys, next)
+	}
+	if keys, _ := list("page=3&page_size=2"); keys != "" {
+		t.Errorf("page 3 = %s", keys)
+	}
+	if keys, next := list("cursor=uploads/goods/c.txt&page_size=2"); keys != "e.txt" || next != "" {
+		t.Errorf("cursor page = %s, next %q", keys, next)
+	}
+}
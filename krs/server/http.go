@@ -9,7 +9,12 @@ import (
 type Service interface {
 	Upload(uploadSvc upload.Service) func(http.Context) error
 	UploadModel3D(uploadSvc upload.Service) func(http.Context) error
+	TusUpload(uploadSvc upload.Service, basePath string) func(http.Context) error
+	TusUploadModel3D(uploadSvc upload.Service, basePath string) func(http.Context) error
 	StaticFileRead(uploadSvc upload.Service) func(http.Context) error
+	ListFiles(uploadSvc upload.Service, root string) func(http.Context) error
+	PresignUpload(ossSvc upload.OssService) func(http.Context) error
+	PresignCallback(ossSvc upload.OssService) func(http.Context) error
 	Captcha(captchaSvc captcha.Service) func(http.Context) error
 }
 
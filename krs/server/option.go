@@ -1,14 +1,33 @@
 package server
 
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nuominmin/biz/krs/middleware/jwt"
+)
+
 type options struct {
 	allowedTypes map[string]struct{}
+	// 断点续传状态存储
+	tusStore TusStore
+	// 断点续传数据文件目录
+	tusDir string
+	// 断点续传的有效期
+	tusExpiration time.Duration
+	// 获取当前用户作为上传者
+	jwt jwt.Service
 }
 
 type Option func(*options)
 
 func newOptions(optFns ...Option) options {
 	opts := options{
-		allowedTypes: make(map[string]struct{}),
+		allowedTypes:  make(map[string]struct{}),
+		tusStore:      NewMemoryTusStore(),
+		tusDir:        filepath.Join(os.TempDir(), "tus_uploads"),
+		tusExpiration: defaultTusExpiration,
 	}
 	for _, opt := range optFns {
 		opt(&opts)
@@ -24,3 +43,33 @@ func WithAllowedTypes(allowedTypes ...string) Option {
 		}
 	}
 }
+
+// 设置断点续传状态存储
+func WithTusStore(store TusStore) Option {
+	return func(o *options) {
+		o.tusStore = store
+	}
+}
+
+// 设置断点续传数据文件目录，多实例部署时需要使用共享目录并配合共享的 TusStore，
+// 同一个上传的写入通过目录中以 O_EXCL 创建的锁文件互斥，共享目录需要支持 O_EXCL（例如 NFSv3 及以上）
+func WithTusDir(dir string) Option {
+	return func(o *options) {
+		o.tusDir = dir
+	}
+}
+
+// 设置断点续传的有效期，默认 24 小时。超过有效期未继续上传时删除数据文件，
+// 上传完成后的结果同样保留该时间。为 0 时不过期
+func WithTusExpiration(expiration time.Duration) Option {
+	return func(o *options) {
+		o.tusExpiration = expiration
+	}
+}
+
+// 设置 JWT 服务，上传时以当前用户作为上传者，用于文件记录和存储配额
+func WithJwt(jwtSvc jwt.Service) Option {
+	return func(o *options) {
+		o.jwt = jwtSvc
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/nuominmin/biz/krs/types"
+	"github.com/nuominmin/biz/upload"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// presignRequest 直传签名请求
+type presignRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Method      string `json:"method"` // put 或 post，默认 post
+}
+
+// presignCallbackRequest 直传完成回调请求
+type presignCallbackRequest struct {
+	// 签发时返回的回调凭证
+	Token string `json:"token"`
+}
+
+// PresignUpload 签发浏览器直传 OSS 的凭证，限制 key、Content-Type 和最大文件大小，以当前用户作为上传者
+func (s *service) PresignUpload(ossSvc upload.OssService) func(khttp.Context) error {
+	return func(ctx khttp.Context) error {
+		var req presignRequest
+		if err := ctx.Bind(&req); err != nil {
+			return status.Errorf(codes.InvalidArgument, "Invalid request: %v", err)
+		}
+		if req.Filename == "" {
+			return status.Errorf(codes.InvalidArgument, "Filename is required")
+		}
+
+		// 检查是否为允许的文件类型
+		if err := s.checkAllowedType(req.Filename); err != nil {
+			return err
+		}
+
+		owner, err := s.owner(ctx)
+		if err != nil {
+			return err
+		}
+		// 文件大小未知，只检查是否已超出配额，回调时按实际大小计入
+		if err = checkQuota(ossSvc, owner, 0); err != nil {
+			return err
+		}
+
+		// 未指定时根据扩展名限制 Content-Type
+		contentType := req.ContentType
+		if contentType == "" {
+			contentType = ossSvc.GetContentType(req.Filename)
+		}
+
+		opts := upload.PresignOptions{
+			Filename:    req.Filename,
+			ContentType: contentType,
+			MaxSize:     defaultMaxFileSize,
+		}
+
+		uploadOpts := []upload.UploadOption{upload.WithOwner(owner), upload.WithOriginalName(req.Filename)}
+		var presigned *upload.PresignedUpload
+		switch strings.ToUpper(req.Method) {
+		case http.MethodPut:
+			presigned, err = ossSvc.PresignPut(opts, uploadOpts...)
+		case "", http.MethodPost:
+			presigned, err = ossSvc.PresignPost(opts, uploadOpts...)
+		default:
+			return status.Errorf(codes.InvalidArgument, "Unsupported method %s", req.Method)
+		}
+		if err != nil {
+			return uploadError(err, codes.Internal, "Failed to presign upload: %v", err)
+		}
+
+		return ctx.JSON(200, types.NewSuccessResponse(presigned))
+	}
+}
+
+// PresignCallback 直传完成后的回调，校验回调凭证、对象已上传且未超过大小限制，
+// 保存文件记录并计入配额，返回与 Upload 相同的数据
+func (s *service) PresignCallback(ossSvc upload.OssService) func(khttp.Context) error {
+	return func(ctx khttp.Context) error {
+		var req presignCallbackRequest
+		if err := ctx.Bind(&req); err != nil {
+			return status.Errorf(codes.InvalidArgument, "Invalid request: %v", err)
+		}
+		if req.Token == "" {
+			return status.Errorf(codes.InvalidArgument, "Token is required")
+		}
+
+		owner, err := s.owner(ctx)
+		if err != nil {
+			return err
+		}
+		fileURL, info, err := ossSvc.VerifyUpload(req.Token, owner)
+		if err != nil {
+			return uploadError(err, codes.FailedPrecondition, "Failed to verify upload: %v", err)
+		}
+
+		data := types.Upload{
+			Url:      fileURL,
+			Filename: filepath.Base(fileURL),
+			Size:     info.Size,
+		}
+
+		return ctx.JSON(200, types.NewSuccessResponse(data))
+	}
+}
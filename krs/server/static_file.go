@@ -2,14 +2,18 @@ package server
 
 import (
 	"fmt"
+	nhttp "net/http"
+	"path/filepath"
+
 	"github.com/go-kratos/kratos/v2/log"
 	"github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/nuominmin/biz/krs/middleware/errresp"
 	"github.com/nuominmin/biz/upload"
-	"path/filepath"
-	"strings"
 )
 
 // StaticFileRead 静态文件读取
+// 以流的方式返回文件，支持 Range、If-Range、ETag、If-None-Match 和 If-Modified-Since，
+// 浏览器可以直接拖动播放大视频。私有文件只能通过 SignURL 生成的签名地址访问
 func (s *service) StaticFileRead(uploadSvc upload.Service) func(http.Context) error {
 	return func(ctx http.Context) error {
 		filename := ctx.Vars().Get("filename")
@@ -17,27 +21,70 @@ func (s *service) StaticFileRead(uploadSvc upload.Service) func(http.Context) er
 		// 拼接文件路径
 		filename = filepath.Join(upload.DefaultUploadDir, filename)
 
-		data, err := uploadSvc.DownloadFile(filename)
+		// 私有文件校验签名
+		acl, err := uploadSvc.FileACL(filename)
 		if err != nil {
-			log.Errorf("read file error (%+v), filename: %s", err, filename)
-			ctx.Response().WriteHeader(500)
-			_, _ = ctx.Response().Write([]byte("500 Internal Server Error"))
+			log.Errorf("get file acl error (%+v), filename: %s", err, filename)
+			writeStatus(ctx, fileErrorStatus(err))
 			return nil
 		}
+		if acl == upload.ACLPrivate {
+			if err = uploadSvc.VerifySignedURL(filename, ctx.Request().URL.Query()); err != nil {
+				writeStatus(ctx, nhttp.StatusForbidden)
+				return nil
+			}
+		}
 
-		contentType := uploadSvc.GetContentType(filename)
-
-		// 设置正确的Content-Type和缓存头
-		ctx.Response().Header().Set("Content-Type", contentType)
-		ctx.Response().Header().Set("Cache-Control", "public, max-age=31536000") // 缓存1年
-		ctx.Response().Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+		file, err := uploadSvc.OpenFile(filename)
+		if err != nil {
+			status := fileErrorStatus(err)
+			if status != nhttp.StatusNotFound {
+				log.Errorf("read file error (%+v), filename: %s", err, filename)
+			}
+			writeStatus(ctx, status)
+			return nil
+		}
+		defer file.Close()
 
-		// 对于视频文件，添加支持范围请求的头部
-		if strings.HasPrefix(contentType, "video/") {
-			ctx.Response().Header().Set("Accept-Ranges", "bytes")
+		// 设置正确的Content-Type和缓存头，上传时指定的属性优先，ServeContent 根据 ETag 处理条件请求
+		header := ctx.Response().Header()
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = uploadSvc.GetContentType(filename)
 		}
+		header.Set("Content-Type", contentType)
+		cacheControl := file.CacheControl
+		if cacheControl == "" {
+			cacheControl = "public, max-age=31536000" // 缓存1年
+			if acl == upload.ACLPrivate {
+				// 签名地址过期前只允许浏览器缓存
+				cacheControl = "private, max-age=3600"
+			}
+		}
+		header.Set("Cache-Control", cacheControl)
+		if file.ContentDisposition != "" {
+			header.Set("Content-Disposition", file.ContentDisposition)
+		}
+		for k, v := range file.Metadata {
+			header.Set("X-Meta-"+k, v)
+		}
+		header.Set("ETag", file.ETag())
 
-		_, _ = ctx.Response().Write(data)
+		nhttp.ServeContent(ctx.Response(), ctx.Request(), filename, file.ModTime, file)
 		return nil
 	}
 }
+
+// fileErrorStatus 按 upload 的错误分类返回状态码，例如文件不存在返回 404，无法分类时返回 500
+func fileErrorStatus(err error) int {
+	if uploadErr := errresp.NewUploadError(err); uploadErr != nil {
+		return uploadErr.Status
+	}
+	return nhttp.StatusInternalServerError
+}
+
+// writeStatus 返回状态码和对应的文本，例如 404 Not Found
+func writeStatus(ctx http.Context, status int) {
+	ctx.Response().WriteHeader(status)
+	_, _ = ctx.Response().Write([]byte(fmt.Sprintf("%d %s", status, nhttp.StatusText(status))))
+}
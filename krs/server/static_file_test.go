@@ -0,0 +1,132 @@
+package server
+
+import (
+	"io"
+	nhttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/nuominmin/biz/upload"
+)
+
+// newStaticTestServer 启动挂载 StaticFileRead 的测试服务
+func newStaticTestServer(t *testing.T, uploadSvc upload.Service) *httptest.Server {
+	t.Helper()
+	svc := &service{opts: newOptions()}
+	srv := khttp.NewServer()
+	srv.Route("/").GET("/uploads/{filename:.*}", svc.StaticFileRead(uploadSvc))
+
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestStaticFilePrivateAfterRestart(t *testing.T) {
+	root := t.TempDir()
+	key := []byte("secret")
+	uploadSvc, err := upload.New("http://127.0.0.1:3000", "goods", upload.WithDriverParam(upload.ParamRoot, root),
+		upload.WithFileRepository(upload.NewMemoryFileRepository()), upload.WithSigningKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileURL, err := uploadSvc.UploadFile(strings.NewReader("secret"), "a.txt", upload.WithACL(upload.ACLPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后没有文件记录，访问权限从属性文件读取
+	restarted, err := upload.New("http://127.0.0.1:3000", "goods", upload.WithDriverParam(upload.ParamRoot, root),
+		upload.WithSigningKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newStaticTestServer(t, restarted)
+	path := strings.TrimPrefix(fileURL, "http://127.0.0.1:3000")
+
+	resp, err := nhttp.Get(ts.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != nhttp.StatusForbidden {
+		t.Errorf("expected 403 for unsigned request, got %d", resp.StatusCode)
+	}
+
+	signed, err := restarted.SignURL(fileURL, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = nhttp.Get(ts.URL + strings.TrimPrefix(signed, "http://127.0.0.1:3000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if data, _ := io.ReadAll(resp.Body); resp.StatusCode != nhttp.StatusOK || string(data) != "secret" {
+		t.Errorf("signed request = %d, %q", resp.StatusCode, data)
+	}
+}
+
+func TestStaticFileRangeAndConditional(t *testing.T) {
+	uploadSvc, err := upload.New("http://127.0.0.1:3000", "goods", upload.WithDriverParam(upload.ParamRoot, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileURL, err := uploadSvc.UploadFile(strings.NewReader("hello world"), "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newStaticTestServer(t, uploadSvc)
+	url := ts.URL + strings.TrimPrefix(fileURL, "http://127.0.0.1:3000")
+
+	resp, err := nhttp.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != nhttp.StatusOK || etag == "" {
+		t.Fatalf("get = %d, etag %q", resp.StatusCode, etag)
+	}
+
+	cases := []struct {
+		name         string
+		header       map[string]string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{"range", map[string]string{"Range": "bytes=0-4"}, nhttp.StatusPartialContent, "bytes 0-4/11", "hello"},
+		{"suffix range", map[string]string{"Range": "bytes=-5"}, nhttp.StatusPartialContent, "bytes 6-10/11", "world"},
+		{"unsatisfiable range", map[string]string{"Range": "bytes=20-30"}, nhttp.StatusRequestedRangeNotSatisfiable, "bytes */11", ""},
+		{"if-none-match", map[string]string{"If-None-Match": etag}, nhttp.StatusNotModified, "", ""},
+		{"if-none-match changed", map[string]string{"If-None-Match": `"stale"`}, nhttp.StatusOK, "", "hello world"},
+		{"if-range current", map[string]string{"Range": "bytes=6-10", "If-Range": etag}, nhttp.StatusPartialContent, "bytes 6-10/11", "world"},
+		{"if-range stale", map[string]string{"Range": "bytes=0-4", "If-Range": `"stale"`}, nhttp.StatusOK, "", "hello world"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := nhttp.NewRequest(nhttp.MethodGet, url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range c.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := nhttp.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			data, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != c.status || resp.Header.Get("Content-Range") != c.contentRange {
+				t.Fatalf("status = %d, Content-Range %q", resp.StatusCode, resp.Header.Get("Content-Range"))
+			}
+			if c.status != nhttp.StatusRequestedRangeNotSatisfiable && string(data) != c.body {
+				t.Errorf("body = %q, want %q", data, c.body)
+			}
+		})
+	}
+}
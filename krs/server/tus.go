@@ -0,0 +1,615 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	nhttp "net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/google/uuid"
+	"github.com/nuominmin/biz/krs/middleware/errresp"
+	"github.com/nuominmin/biz/krs/types"
+	"github.com/nuominmin/biz/upload"
+)
+
+const (
+	// tus 协议版本
+	tusVersion = "1.0.0"
+	// 支持的 tus 扩展
+	tusExtensions = "creation,expiration"
+	// PATCH 请求的 Content-Type
+	tusContentType = "application/offset+octet-stream"
+	// 默认的断点续传有效期
+	defaultTusExpiration = 24 * time.Hour
+	// 清理过期上传的最小间隔
+	tusPurgeInterval = time.Minute
+	// 锁文件超过该时间未刷新时视为持有者已退出
+	tusLockTimeout = 2 * time.Minute
+	// 持有锁期间刷新锁文件修改时间的间隔
+	tusLockRefresh = 30 * time.Second
+)
+
+// errTusUploadLocked 上传正在被其他实例写入
+var errTusUploadLocked = errors.New("tus upload locked")
+
+// tusCompleteFunc 上传完成后处理文件，返回响应数据
+type tusCompleteFunc func(uploadSvc upload.Service, dataPath string, info *TusUpload) (interface{}, error)
+
+// tusHandler 实现 tus 1.0 core、creation 和 expiration 扩展
+type tusHandler struct {
+	opts      options
+	uploadSvc upload.Service
+	basePath  string
+	checkName func(filename string) error
+	complete  tusCompleteFunc
+	owner     func(ctx http.Context) (string, error)
+	locks     tusLocks // 同一个上传的 PATCH 需要串行，多实例之间通过 tusDir 中的锁文件互斥
+
+	purgeMu   sync.Mutex
+	lastPurge time.Time
+}
+
+// tusLocks 按上传 ID 加锁，没有请求持有时删除，避免不存在的 ID 使锁无限增长
+type tusLocks struct {
+	mu    sync.Mutex
+	locks map[string]*tusLock
+}
+
+type tusLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock 锁定 id，返回解锁函数
+func (l *tusLocks) lock(id string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*tusLock)
+	}
+	lock, ok := l.locks[id]
+	if !ok {
+		lock = &tusLock{}
+		l.locks[id] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(l.locks, id)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// len 当前持有或等待的锁数量
+func (l *tusLocks) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.locks)
+}
+
+/*
+TusUpload 断点续传上传，完成后调用 UploadFile，路由示例：
+
+	h := svc.TusUpload(uploadSvc, "/files/")
+	r := srv.Route("/")
+	r.OPTIONS("/files", h)
+	r.POST("/files", h)
+	r.HEAD("/files/{id}", h)
+	r.PATCH("/files/{id}", h)
+	r.GET("/files/{id}", h) // 上传完成后获取结果
+
+客户端（如 tus-js-client）在 Upload-Metadata 中通过 filename 传入原文件名
+*/
+func (s *service) TusUpload(uploadSvc upload.Service, basePath string) func(http.Context) error {
+	h := s.newTusHandler(uploadSvc, basePath, tusUploadFile)
+	h.checkName = func(filename string) error {
+		ext := strings.ToLower(filepath.Ext(filename))
+		// 检查是否为允许的文件类型，如果未配置，则允许所有类型
+		if len(s.opts.allowedTypes) > 0 {
+			if _, ok := s.opts.allowedTypes[ext]; !ok {
+				return fmt.Errorf("File type %s is not allowed", ext)
+			}
+		}
+		return nil
+	}
+	return h.serve
+}
+
+// TusUploadModel3D 断点续传上传模型压缩包，完成后调用 ExtractAndSaveModel3D，路由同 TusUpload。
+// Upload-Metadata 中 preserve_layout 为 true 时保留压缩包的目录结构
+func (s *service) TusUploadModel3D(uploadSvc upload.Service, basePath string) func(http.Context) error {
+	h := s.newTusHandler(uploadSvc, basePath, tusExtractModel3D)
+	h.checkName = func(filename string) error {
+		if !upload.IsModelArchive(filename) {
+			return errors.New(modelArchiveTypeMessage)
+		}
+		return nil
+	}
+	return h.serve
+}
+
+func (s *service) newTusHandler(uploadSvc upload.Service, basePath string, complete tusCompleteFunc) *tusHandler {
+	return &tusHandler{
+		opts:      s.opts,
+		uploadSvc: uploadSvc,
+		basePath:  basePath,
+		complete:  complete,
+		owner:     s.owner,
+	}
+}
+
+// tusUploadFile 上传完成后保存文件
+func tusUploadFile(uploadSvc upload.Service, dataPath string, info *TusUpload) (interface{}, error) {
+	file, err := os.Open(dataPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open upload file: %v", err)
+	}
+	defer file.Close()
+
+	// 检查文件内容是否与扩展名一致
+	reader, err := uploadSvc.CheckContent(file, info.Filename(), info.Length)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid file content: %w", err)
+	}
+
+	fileURL, err := uploadSvc.UploadFile(reader, uploadSvc.GenerateUniqueFilename(info.Filename(), upload.WithOwner(info.Owner)),
+		upload.WithOwner(info.Owner), upload.WithOriginalName(info.Filename()), upload.WithSize(info.Length))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to upload: %w", err)
+	}
+
+	return types.Upload{
+		Url:         fileURL,
+		Filename:    filepath.Base(fileURL),
+		Size:        info.Length,
+		Derivatives: uploadSvc.DerivativeURLs(fileURL),
+	}, nil
+}
+
+// tusExtractModel3D 上传完成后解压模型
+func tusExtractModel3D(uploadSvc upload.Service, dataPath string, info *TusUpload) (interface{}, error) {
+	// 检查文件内容是否为ZIP
+	file, err := os.Open(dataPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open upload file: %v", err)
+	}
+	_, err = uploadSvc.CheckContent(file, info.Filename(), info.Length)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid file content: %w", err)
+	}
+
+	bundle, err := uploadSvc.ExtractModelBundle(context.Background(), dataPath, model3DOptions(info.Owner, info.Metadata["preserve_layout"])...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to extract model: %w", err)
+	}
+	return model3DResult(bundle), nil
+}
+
+func (h *tusHandler) serve(ctx http.Context) error {
+	w := ctx.Response()
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	r := ctx.Request()
+	if r.Method == nhttp.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(defaultMaxFileSize, 10))
+		w.WriteHeader(nhttp.StatusNoContent)
+		return nil
+	}
+
+	// 获取结果是普通的 JSON 接口，不要求 tus 头
+	if r.Method == nhttp.MethodGet {
+		return h.result(ctx)
+	}
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		return h.fail(ctx, nhttp.StatusPreconditionFailed, "unsupported tus version")
+	}
+
+	switch r.Method {
+	case nhttp.MethodPost:
+		return h.create(ctx)
+	case nhttp.MethodHead:
+		return h.head(ctx)
+	case nhttp.MethodPatch:
+		return h.patch(ctx)
+	default:
+		return h.fail(ctx, nhttp.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// create 创建上传（creation 扩展）
+func (h *tusHandler) create(ctx http.Context) error {
+	r := ctx.Request()
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return h.fail(ctx, nhttp.StatusBadRequest, "invalid Upload-Length")
+	}
+	// 检查文件大小是否超过最大限制
+	if length > defaultMaxFileSize {
+		return h.fail(ctx, nhttp.StatusRequestEntityTooLarge,
+			fmt.Sprintf("File size exceeds maximum limit of %d MB", defaultMaxFileSize/(1024*1024)))
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		return h.fail(ctx, nhttp.StatusBadRequest, err.Error())
+	}
+
+	owner, err := h.owner(ctx)
+	if err != nil {
+		return h.fail(ctx, nhttp.StatusUnauthorized, err.Error())
+	}
+
+	// 顺便清理过期的上传
+	h.purgeExpired()
+
+	now := time.Now()
+	info := &TusUpload{
+		ID:        strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Length:    length,
+		Metadata:  metadata,
+		Owner:     owner,
+		CreatedAt: now,
+		ExpiresAt: h.expiresAt(now),
+	}
+	if err = h.checkName(info.Filename()); err != nil {
+		return h.fail(ctx, nhttp.StatusBadRequest, err.Error())
+	}
+
+	// 检查上传者的存储配额
+	if err = h.uploadSvc.CheckQuota(owner, length); err != nil {
+		if errors.Is(err, upload.ErrQuotaExceeded) {
+			return h.fail(ctx, nhttp.StatusRequestEntityTooLarge, err.Error())
+		}
+		return h.fail(ctx, nhttp.StatusInternalServerError, fmt.Sprintf("Failed to check quota: %v", err))
+	}
+
+	// 创建数据文件
+	if err = os.MkdirAll(h.opts.tusDir, 0755); err != nil {
+		return h.fail(ctx, nhttp.StatusInternalServerError, fmt.Sprintf("Failed to create upload directory: %v", err))
+	}
+	dataFile, err := os.Create(h.dataPath(info.ID))
+	if err != nil {
+		return h.fail(ctx, nhttp.StatusInternalServerError, fmt.Sprintf("Failed to create upload file: %v", err))
+	}
+	dataFile.Close()
+
+	if err = h.opts.tusStore.Save(info); err != nil {
+		os.Remove(h.dataPath(info.ID))
+		return h.fail(ctx, nhttp.StatusInternalServerError, fmt.Sprintf("Failed to save upload: %v", err))
+	}
+
+	// 空文件直接完成
+	if info.Completed() {
+		if err = h.finish(info); err != nil {
+			return h.finishError(ctx, err)
+		}
+	}
+
+	w := ctx.Response()
+	w.Header().Set("Location", path.Join(h.basePath, info.ID))
+	h.setExpires(ctx, info)
+	w.WriteHeader(nhttp.StatusCreated)
+	return nil
+}
+
+// head 查询上传进度
+func (h *tusHandler) head(ctx http.Context) error {
+	info, err := h.get(ctx, ctx.Vars().Get("id"))
+	if err != nil {
+		return h.storeError(ctx, err)
+	}
+
+	w := ctx.Response()
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	h.setExpires(ctx, info)
+	w.WriteHeader(nhttp.StatusOK)
+	return nil
+}
+
+// patch 追加数据
+func (h *tusHandler) patch(ctx http.Context) error {
+	r := ctx.Request()
+	if r.Header.Get("Content-Type") != tusContentType {
+		return h.fail(ctx, nhttp.StatusUnsupportedMediaType, "Content-Type must be "+tusContentType)
+	}
+
+	id := ctx.Vars().Get("id")
+	defer h.locks.lock(id)()
+
+	info, err := h.get(ctx, id)
+	if err != nil {
+		return h.storeError(ctx, err)
+	}
+	unlock, err := h.lockFile(id)
+	if errors.Is(err, errTusUploadLocked) {
+		// 客户端（如 tus-js-client）收到 423 后会重试
+		return h.fail(ctx, nhttp.StatusLocked, "upload is locked by another request")
+	}
+	if err != nil {
+		return h.fail(ctx, nhttp.StatusInternalServerError, fmt.Sprintf("Failed to lock upload: %v", err))
+	}
+	defer unlock()
+	// 加锁前其他实例可能已写入，重新读取状态
+	if info, err = h.get(ctx, id); err != nil {
+		return h.storeError(ctx, err)
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != info.Offset {
+		return h.fail(ctx, nhttp.StatusConflict, "Upload-Offset does not match current offset")
+	}
+	if info.Completed() {
+		return h.fail(ctx, nhttp.StatusForbidden, "upload already completed")
+	}
+
+	dataFile, err := os.OpenFile(h.dataPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		return h.fail(ctx, nhttp.StatusInternalServerError, fmt.Sprintf("Failed to open upload file: %v", err))
+	}
+	if _, err = dataFile.Seek(info.Offset, io.SeekStart); err != nil {
+		dataFile.Close()
+		return h.fail(ctx, nhttp.StatusInternalServerError, fmt.Sprintf("Failed to seek upload file: %v", err))
+	}
+
+	// 连接中断时保留已写入的部分，客户端可以从新的 offset 继续上传
+	n, copyErr := io.Copy(dataFile, io.LimitReader(r.Body, info.Length-info.Offset))
+	dataFile.Close()
+
+	info.Offset += n
+	info.ExpiresAt = h.expiresAt(time.Now())
+	if err = h.opts.tusStore.Save(info); err != nil {
+		return h.fail(ctx, nhttp.StatusInternalServerError, fmt.Sprintf("Failed to save upload: %v", err))
+	}
+	if copyErr != nil {
+		log.Warnf("tus upload %s interrupted at offset %d: %v", id, info.Offset, copyErr)
+		return h.fail(ctx, nhttp.StatusInternalServerError, "Failed to read request body")
+	}
+
+	if info.Completed() {
+		if err = h.finish(info); err != nil {
+			return h.finishError(ctx, err)
+		}
+	}
+
+	w := ctx.Response()
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	h.setExpires(ctx, info)
+	w.WriteHeader(nhttp.StatusNoContent)
+	return nil
+}
+
+// result 返回上传完成后的结果
+func (h *tusHandler) result(ctx http.Context) error {
+	info, err := h.get(ctx, ctx.Vars().Get("id"))
+	if err != nil {
+		return h.storeError(ctx, err)
+	}
+	if !info.Completed() || info.Result == nil {
+		return h.fail(ctx, nhttp.StatusConflict, "upload not completed")
+	}
+	return ctx.JSON(200, types.NewSuccessResponse(info.Result))
+}
+
+// finish 上传完成，交给上传服务处理并删除数据文件
+func (h *tusHandler) finish(info *TusUpload) error {
+	dataPath := h.dataPath(info.ID)
+	defer os.Remove(dataPath)
+
+	result, err := h.complete(h.uploadSvc, dataPath, info)
+	if err != nil {
+		_ = h.opts.tusStore.Delete(info.ID)
+		return err
+	}
+
+	// 结果保留到过期后删除
+	info.Result = result
+	info.ExpiresAt = h.expiresAt(time.Now())
+	return h.opts.tusStore.Save(info)
+}
+
+// get 获取当前用户创建的上传，其他用户的上传视为不存在，已过期的上传删除后返回 ErrTusUploadExpired
+func (h *tusHandler) get(ctx http.Context, id string) (*TusUpload, error) {
+	info, err := h.opts.tusStore.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := h.owner(ctx)
+	if err != nil || owner != info.Owner {
+		return nil, ErrTusUploadNotFound
+	}
+	if info.Expired(time.Now()) {
+		h.remove(id)
+		return nil, ErrTusUploadExpired
+	}
+	return info, nil
+}
+
+// expiresAt 从 now 开始计算的过期时间，未设置有效期时为零值
+func (h *tusHandler) expiresAt(now time.Time) time.Time {
+	if h.opts.tusExpiration <= 0 {
+		return time.Time{}
+	}
+	return now.Add(h.opts.tusExpiration)
+}
+
+// setExpires 未完成的上传返回 Upload-Expires（expiration 扩展）
+func (h *tusHandler) setExpires(ctx http.Context, info *TusUpload) {
+	if !info.Completed() && !info.ExpiresAt.IsZero() {
+		ctx.Response().Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(nhttp.TimeFormat))
+	}
+}
+
+// remove 删除上传的数据文件和状态
+func (h *tusHandler) remove(id string) {
+	os.Remove(h.dataPath(id))
+	if err := h.opts.tusStore.Delete(id); err != nil {
+		log.Warnf("tus upload %s: failed to delete expired upload: %v", id, err)
+	}
+}
+
+// purgeExpired 删除过期的上传，以及超过有效期未写入且没有状态的数据文件（例如使用内存存储时进程重启后残留的文件），
+// 最多每分钟执行一次
+func (h *tusHandler) purgeExpired() {
+	if h.opts.tusExpiration <= 0 {
+		return
+	}
+	now := time.Now()
+	h.purgeMu.Lock()
+	if now.Sub(h.lastPurge) < tusPurgeInterval {
+		h.purgeMu.Unlock()
+		return
+	}
+	h.lastPurge = now
+	h.purgeMu.Unlock()
+
+	expired, err := h.opts.tusStore.Expired(now)
+	if err != nil {
+		log.Warnf("failed to find expired tus uploads: %v", err)
+		return
+	}
+	for _, info := range expired {
+		h.remove(info.ID)
+	}
+
+	entries, _ := os.ReadDir(h.opts.tusDir)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		// 实例退出时残留的锁文件
+		if strings.HasSuffix(entry.Name(), ".lock") && now.Sub(info.ModTime()) >= tusLockTimeout {
+			os.Remove(filepath.Join(h.opts.tusDir, entry.Name()))
+			continue
+		}
+		id, ok := strings.CutSuffix(entry.Name(), ".bin")
+		if !ok || now.Sub(info.ModTime()) < h.opts.tusExpiration {
+			continue
+		}
+		if _, err = h.opts.tusStore.Get(id); errors.Is(err, ErrTusUploadNotFound) {
+			os.Remove(filepath.Join(h.opts.tusDir, entry.Name()))
+		}
+	}
+}
+
+func (h *tusHandler) dataPath(id string) string {
+	return filepath.Join(h.opts.tusDir, filepath.Base(id)+".bin")
+}
+
+func (h *tusHandler) lockPath(id string) string {
+	return filepath.Join(h.opts.tusDir, filepath.Base(id)+".lock")
+}
+
+// lockFile 通过 O_EXCL 创建 tusDir 中的锁文件，使共享目录的多个实例不会同时写入同一个上传。
+// 已被锁定时返回 errTusUploadLocked，超过 tusLockTimeout 未刷新的锁文件视为残留并接管。
+// 持有期间定期刷新锁文件，返回解锁函数
+func (h *tusHandler) lockFile(id string) (func(), error) {
+	lockPath := h.lockPath(id)
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errors.Is(err, os.ErrExist) {
+		info, statErr := os.Stat(lockPath)
+		if statErr != nil || time.Since(info.ModTime()) < tusLockTimeout {
+			return nil, errTusUploadLocked
+		}
+		os.Remove(lockPath)
+		file, err = os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if errors.Is(err, os.ErrExist) {
+			return nil, errTusUploadLocked
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(tusLockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				os.Chtimes(lockPath, now, now)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		os.Remove(lockPath)
+	}, nil
+}
+
+func (h *tusHandler) storeError(ctx http.Context, err error) error {
+	if errors.Is(err, ErrTusUploadNotFound) {
+		return h.fail(ctx, nhttp.StatusNotFound, "upload not found")
+	}
+	if errors.Is(err, ErrTusUploadExpired) {
+		return h.fail(ctx, nhttp.StatusGone, "upload expired")
+	}
+	return h.fail(ctx, nhttp.StatusInternalServerError, err.Error())
+}
+
+// finishError 按 upload 的错误分类返回状态码，例如内容与扩展名不一致返回 400、超出配额返回 413，
+// 无法分类的错误（例如读取数据文件失败）返回 500
+func (h *tusHandler) finishError(ctx http.Context, err error) error {
+	if uploadErr := errresp.NewUploadError(err); uploadErr != nil {
+		return h.fail(ctx, uploadErr.Status, uploadErr.Message)
+	}
+	return h.fail(ctx, nhttp.StatusInternalServerError, err.Error())
+}
+
+func (h *tusHandler) fail(ctx http.Context, code int, message string) error {
+	ctx.Response().WriteHeader(code)
+	if ctx.Request().Method != nhttp.MethodHead {
+		_, _ = ctx.Response().Write([]byte(message))
+	}
+	return nil
+}
+
+// parseTusMetadata 解析 Upload-Metadata：逗号分隔的 "key base64(value)"
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, " ", 2)
+		var value []byte
+		if len(parts) == 2 {
+			var err error
+			if value, err = base64.StdEncoding.DecodeString(parts[1]); err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value for key %s", parts[0])
+			}
+		}
+		metadata[parts[0]] = string(value)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTusUploadNotFound 断点续传记录不存在
+	ErrTusUploadNotFound = errors.New("tus upload not found")
+	// ErrTusUploadExpired 断点续传已过期
+	ErrTusUploadExpired = errors.New("tus upload expired")
+)
+
+// TusUpload 断点续传状态
+type TusUpload struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata"`
+	Owner     string            `json:"owner,omitempty"`  // 创建上传的用户，只有该用户可以继续上传
+	Result    interface{}       `json:"result,omitempty"` // 上传完成后的结果
+	CreatedAt time.Time         `json:"created_at"`
+	// 过期时间，未完成的上传每次写入后延长，完成后为结果的保留时间，零值表示不过期
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// Filename 客户端在 Upload-Metadata 中传入的文件名
+func (u *TusUpload) Filename() string {
+	if name := u.Metadata["filename"]; name != "" {
+		return name
+	}
+	return u.Metadata["name"]
+}
+
+// Completed 是否已上传完成
+func (u *TusUpload) Completed() bool {
+	return u.Offset >= u.Length
+}
+
+// Expired 在 now 时是否已过期
+func (u *TusUpload) Expired(now time.Time) bool {
+	return !u.ExpiresAt.IsZero() && now.After(u.ExpiresAt)
+}
+
+// TusStore 断点续传状态存储，可替换为 Redis、数据库等实现以便多实例共享
+type TusStore interface {
+	Save(upload *TusUpload) error
+	// Get 获取状态，不存在时返回 ErrTusUploadNotFound
+	Get(id string) (*TusUpload, error)
+	Delete(id string) error
+	// Expired 返回在 now 时已过期的上传
+	Expired(now time.Time) ([]TusUpload, error)
+}
+
+type memoryTusStore struct {
+	mu      sync.RWMutex
+	uploads map[string]TusUpload
+}
+
+// NewMemoryTusStore 创建内存存储，仅适用于单实例部署
+func NewMemoryTusStore() TusStore {
+	return &memoryTusStore{
+		uploads: make(map[string]TusUpload),
+	}
+}
+
+func (m *memoryTusStore) Save(upload *TusUpload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploads[upload.ID] = *upload
+	return nil
+}
+
+func (m *memoryTusStore) Get(id string) (*TusUpload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	upload, ok := m.uploads[id]
+	if !ok {
+		return nil, ErrTusUploadNotFound
+	}
+	return &upload, nil
+}
+
+func (m *memoryTusStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.uploads, id)
+	return nil
+}
+
+func (m *memoryTusStore) Expired(now time.Time) ([]TusUpload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var expired []TusUpload
+	for _, upload := range m.uploads {
+		if upload.Expired(now) {
+			expired = append(expired, upload)
+		}
+	}
+	return expired, nil
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	nhttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/nuominmin/biz/upload"
+)
+
+// newTusTestServer 启动挂载 TusUpload 的测试服务，文件保存在临时目录的本地存储中
+func newTusTestServer(t *testing.T, optFns ...Option) (*tusHandler, *httptest.Server) {
+	t.Helper()
+	uploadSvc, err := upload.New("http://127.0.0.1:3000", "goods",
+		upload.WithDriverParam(upload.ParamRoot, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &service{opts: newOptions(append([]Option{WithTusDir(t.TempDir())}, optFns...)...)}
+	h := svc.newTusHandler(uploadSvc, "/files/", tusUploadFile)
+	h.checkName = func(string) error { return nil }
+
+	srv := khttp.NewServer()
+	r := srv.Route("/")
+	r.POST("/files", h.serve)
+	r.HEAD("/files/{id}", h.serve)
+	r.PATCH("/files/{id}", h.serve)
+	r.GET("/files/{id}", h.serve)
+
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return h, ts
+}
+
+func tusRequest(t *testing.T, method, url string, header map[string]string, body string) *nhttp.Response {
+	t.Helper()
+	req, err := nhttp.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := nhttp.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func tusCreate(t *testing.T, ts *httptest.Server, length int) string {
+	t.Helper()
+	resp := tusRequest(t, nhttp.MethodPost, ts.URL+"/files", map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("hello.txt")),
+	}, "")
+	if resp.StatusCode != nhttp.StatusCreated {
+		t.Fatalf("create status = %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Upload-Expires") == "" {
+		t.Error("expected Upload-Expires header")
+	}
+	return ts.URL + resp.Header.Get("Location")
+}
+
+func tusPatch(t *testing.T, location string, offset int, data string) *nhttp.Response {
+	t.Helper()
+	return tusRequest(t, nhttp.MethodPatch, location, map[string]string{
+		"Content-Type":  tusContentType,
+		"Upload-Offset": strconv.Itoa(offset),
+	}, data)
+}
+
+func TestTusUpload(t *testing.T) {
+	h, ts := newTusTestServer(t)
+	location := tusCreate(t, ts, len("hello world"))
+
+	if resp := tusRequest(t, nhttp.MethodHead, location, nil, ""); resp.Header.Get("Upload-Offset") != "0" ||
+		resp.Header.Get("Upload-Length") != "11" {
+		t.Fatalf("head = %d, offset %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+
+	if resp := tusPatch(t, location, 0, "hello "); resp.StatusCode != nhttp.StatusNoContent || resp.Header.Get("Upload-Offset") != "6" {
+		t.Fatalf("patch = %d, offset %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	// 偏移量与服务端不一致
+	if resp := tusPatch(t, location, 0, "world"); resp.StatusCode != nhttp.StatusConflict {
+		t.Errorf("expected 409 for wrong offset, got %d", resp.StatusCode)
+	}
+	if resp := tusRequest(t, nhttp.MethodHead, location, nil, ""); resp.Header.Get("Upload-Offset") != "6" {
+		t.Errorf("offset after conflict = %q", resp.Header.Get("Upload-Offset"))
+	}
+
+	// 最后一个分片完成上传并保存文件
+	if resp := tusPatch(t, location, 6, "world"); resp.StatusCode != nhttp.StatusNoContent {
+		t.Fatalf("final patch = %d", resp.StatusCode)
+	}
+	resp := tusRequest(t, nhttp.MethodGet, location, nil, "")
+	var result struct {
+		Data struct {
+			Url string `json:"url"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Data.Url == "" {
+		t.Fatalf("result = %+v, %v", result, err)
+	}
+	data, err := h.uploadSvc.DownloadFile(h.uploadSvc.RemoveDomainFromURL("http://127.0.0.1:3000", result.Data.Url))
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("uploaded file = %q, %v", data, err)
+	}
+
+	if entries, _ := os.ReadDir(h.opts.tusDir); len(entries) != 0 {
+		t.Errorf("data file should be removed after completion, got %d files", len(entries))
+	}
+	if resp = tusPatch(t, location, 11, "!"); resp.StatusCode != nhttp.StatusForbidden {
+		t.Errorf("expected 403 after completion, got %d", resp.StatusCode)
+	}
+}
+
+func TestTusUnknownUpload(t *testing.T) {
+	h, ts := newTusTestServer(t)
+	for i := 0; i < 10; i++ {
+		if resp := tusPatch(t, ts.URL+"/files/unknown"+strconv.Itoa(i), 0, "x"); resp.StatusCode != nhttp.StatusNotFound {
+			t.Fatalf("expected 404, got %d", resp.StatusCode)
+		}
+	}
+	if n := h.locks.len(); n != 0 {
+		t.Errorf("locks should not be kept for unknown uploads, got %d", n)
+	}
+}
+
+func TestTusExpiration(t *testing.T) {
+	h, ts := newTusTestServer(t, WithTusExpiration(50*time.Millisecond))
+	location := tusCreate(t, ts, 10)
+	if resp := tusPatch(t, location, 0, "abc"); resp.StatusCode != nhttp.StatusNoContent {
+		t.Fatalf("patch = %d", resp.StatusCode)
+	}
+
+	// 没有状态记录的残留数据文件
+	orphan := filepath.Join(h.opts.tusDir, "orphan.bin")
+	if err := os.WriteFile(orphan, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(orphan, old, old)
+
+	time.Sleep(100 * time.Millisecond)
+	if resp := tusRequest(t, nhttp.MethodHead, location, nil, ""); resp.StatusCode != nhttp.StatusGone {
+		t.Errorf("expected 410 for expired upload, got %d", resp.StatusCode)
+	}
+	id := location[strings.LastIndex(location, "/")+1:]
+	if _, err := os.Stat(h.dataPath(id)); !os.IsNotExist(err) {
+		t.Errorf("expired data file should be removed, got %v", err)
+	}
+
+	// 创建上传时清理过期的上传和残留文件
+	expired := tusCreate(t, ts, 10)
+	time.Sleep(100 * time.Millisecond)
+	h.lastPurge = time.Time{}
+	tusCreate(t, ts, 10)
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("orphan data file should be removed, got %v", err)
+	}
+	expiredID := expired[strings.LastIndex(expired, "/")+1:]
+	if _, err := h.opts.tusStore.Get(expiredID); err != ErrTusUploadNotFound {
+		t.Errorf("expired upload should be purged, got %v", err)
+	}
+}
+
+func TestTusLockedByOtherInstance(t *testing.T) {
+	h, ts := newTusTestServer(t)
+	location := tusCreate(t, ts, len("hello"))
+	id := location[strings.LastIndex(location, "/")+1:]
+
+	// 共享目录中其他实例持有的锁
+	lockPath := h.lockPath(id)
+	if err := os.WriteFile(lockPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if resp := tusPatch(t, location, 0, "hello"); resp.StatusCode != nhttp.StatusLocked {
+		t.Fatalf("expected 423 while locked, got %d", resp.StatusCode)
+	}
+	if resp := tusRequest(t, nhttp.MethodHead, location, nil, ""); resp.Header.Get("Upload-Offset") != "0" {
+		t.Errorf("offset after locked patch = %q", resp.Header.Get("Upload-Offset"))
+	}
+
+	// 超时未刷新的锁文件视为残留
+	old := time.Now().Add(-2 * tusLockTimeout)
+	os.Chtimes(lockPath, old, old)
+	if resp := tusPatch(t, location, 0, "hello"); resp.StatusCode != nhttp.StatusNoContent {
+		t.Fatalf("expected stale lock to be taken over, got %d", resp.StatusCode)
+	}
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("lock file should be removed after patch, got %v", err)
+	}
+}
+
+func TestTusFinishError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"unclassified", errors.New("disk failure"), nhttp.StatusInternalServerError},
+		{"invalid argument", fmt.Errorf("Invalid file content: %w", upload.ErrContentMismatch), nhttp.StatusBadRequest},
+		{"not found", fmt.Errorf("Failed to upload: %w", upload.ErrNotFound), nhttp.StatusNotFound},
+		{"quota exceeded", fmt.Errorf("Failed to upload: %w", upload.ErrQuotaExceeded), nhttp.StatusRequestEntityTooLarge},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h, ts := newTusTestServer(t)
+			h.complete = func(upload.Service, string, *TusUpload) (interface{}, error) {
+				return nil, c.err
+			}
+			location := tusCreate(t, ts, len("hello"))
+			if resp := tusPatch(t, location, 0, "hello"); resp.StatusCode != c.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, c.want)
+			}
+		})
+	}
+}
@@ -1,12 +1,16 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/nuominmin/biz/krs/middleware/errresp"
 	"github.com/nuominmin/biz/krs/types"
 	"github.com/nuominmin/biz/upload"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -25,31 +29,92 @@ func (s *service) Upload(uploadSvc upload.Service) func(http.Context) error {
 			return status.Errorf(codes.InvalidArgument, "File size exceeds maximum limit of %d MB", defaultMaxFileSize/(1024*1024))
 		}
 
-		// 获取文件扩展名
-		ext := strings.ToLower(filepath.Ext(handler.Filename))
+		// 检查是否为允许的文件类型
+		if err = s.checkAllowedType(handler.Filename); err != nil {
+			return err
+		}
+
+		// 检查上传者的存储配额
+		owner, err := s.owner(ctx)
+		if err != nil {
+			return err
+		}
+		if err = checkQuota(uploadSvc, owner, handler.Size); err != nil {
+			return err
+		}
 
-		// 检查是否为允许的文件类型，如果未配置，则允许所有类型
-		if s.opts.allowedTypes != nil && len(s.opts.allowedTypes) > 0 {
-			if _, ok := s.opts.allowedTypes[ext]; !ok {
-				return status.Errorf(codes.InvalidArgument, "File type %s is not allowed", ext)
-			}
+		// 检查文件内容是否与扩展名一致
+		reader, err := uploadSvc.CheckContent(file, handler.Filename, handler.Size)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "Invalid file content: %v", err)
 		}
 
 		// 生成唯一文件名
-		filename := uploadSvc.GenerateUniqueFilename(handler.Filename)
+		filename := uploadSvc.GenerateUniqueFilename(handler.Filename, upload.WithOwner(owner))
 
 		// 上传
 		var fileURL string
-		if fileURL, err = uploadSvc.UploadFile(file, filename); err != nil {
-			return status.Errorf(codes.Internal, "Failed to upload: %v, dir: %s, filename: %s", err, filename)
+		if fileURL, err = uploadSvc.UploadFile(reader, filename, upload.WithOwner(owner), upload.WithOriginalName(handler.Filename), upload.WithSize(handler.Size)); err != nil {
+			return uploadError(err, codes.Internal, "Failed to upload: %v, filename: %s", err, filename)
 		}
 
 		data := types.Upload{
-			Url:      fileURL,
-			Filename: filepath.Base(fileURL),
-			Size:     handler.Size,
+			Url:         fileURL,
+			Filename:    filepath.Base(fileURL),
+			Size:        handler.Size,
+			Derivatives: uploadSvc.DerivativeURLs(fileURL),
 		}
 
 		return ctx.JSON(200, types.NewSuccessResponse(data))
 	}
 }
+
+// checkAllowedType 检查是否为允许的文件类型，如果未配置，则允许所有类型
+func (s *service) checkAllowedType(filename string) error {
+	if len(s.opts.allowedTypes) > 0 {
+		ext := strings.ToLower(filepath.Ext(filename))
+		if _, ok := s.opts.allowedTypes[ext]; !ok {
+			return status.Errorf(codes.InvalidArgument, "File type %s is not allowed", ext)
+		}
+	}
+	return nil
+}
+
+// owner 当前用户作为上传者，未设置 WithJwt 时返回空字符串。
+// 路由处理函数不会自动执行服务端中间件，需要通过 ctx.Middleware 执行 jwt 中间件后获取用户
+func (s *service) owner(ctx http.Context) (string, error) {
+	if s.opts.jwt == nil {
+		return "", nil
+	}
+
+	var userId uint64
+	_, err := ctx.Middleware(func(c context.Context, _ interface{}) (interface{}, error) {
+		var err error
+		userId, err = s.opts.jwt.GetUserId(c)
+		return nil, err
+	})(ctx, nil)
+	if err != nil {
+		var authErr *errresp.Error
+		if errors.As(err, &authErr) {
+			return "", authErr
+		}
+		return "", errresp.NewAuthorizationError("")
+	}
+	return strconv.FormatUint(userId, 10), nil
+}
+
+// checkQuota 接收文件前检查存储配额，超出时返回业务错误
+func checkQuota(uploadSvc upload.QuotaManager, owner string, size int64) error {
+	if err := uploadSvc.CheckQuota(owner, size); err != nil {
+		return uploadError(err, codes.Internal, "Failed to check quota: %v", err)
+	}
+	return nil
+}
+
+// uploadError 按 upload 的错误分类返回对应的 HTTP 状态码和业务码，无法分类时返回 code
+func uploadError(err error, code codes.Code, format string, a ...any) error {
+	if uploadErr := errresp.NewUploadError(err); uploadErr != nil {
+		return uploadErr
+	}
+	return status.Errorf(code, format, a...)
+}
@@ -9,9 +9,13 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
+	"strconv"
 )
 
+// 模型压缩包格式不支持时的提示
+const modelArchiveTypeMessage = "File type must be ZIP or TAR (.tar, .tar.gz, .tar.zst)"
+
+// UploadModel3D 上传模型压缩包，表单字段 preserve_layout 为 true 时保留压缩包的目录结构
 func (s *service) UploadModel3D(uploadSvc upload.Service) func(http.Context) error {
 	return func(ctx http.Context) error {
 		// 获取文件
@@ -26,44 +30,77 @@ func (s *service) UploadModel3D(uploadSvc upload.Service) func(http.Context) err
 			return status.Errorf(codes.InvalidArgument, "File size exceeds maximum limit of %d MB", defaultMaxFileSize/(1024*1024))
 		}
 
-		// 检查文件类型必须是ZIP
-		ext := strings.ToLower(filepath.Ext(handler.Filename))
-		if ext != ".zip" {
-			return status.Errorf(codes.InvalidArgument, "File type must be ZIP")
+		// 检查文件类型必须是ZIP或TAR
+		if !upload.IsModelArchive(handler.Filename) {
+			return status.Errorf(codes.InvalidArgument, modelArchiveTypeMessage)
+		}
+
+		// 检查上传者的存储配额，解压后的大小未知，按压缩包大小预估
+		owner, err := s.owner(ctx)
+		if err != nil {
+			return err
+		}
+		if err = checkQuota(uploadSvc, owner, handler.Size); err != nil {
+			return err
+		}
+
+		// 检查文件内容是否与扩展名一致
+		reader, err := uploadSvc.CheckContent(file, handler.Filename, handler.Size)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "Invalid file content: %v", err)
 		}
 
 		// 创建临时目录用于解压
-		tempDir, err := os.MkdirTemp("", "model_upload_*")
+		tempDir, err := os.MkdirTemp("", upload.TempPrefix+"model_*")
 		if err != nil {
 			return status.Errorf(codes.Internal, "Failed to create temp directory: %v", err)
 		}
 		defer os.RemoveAll(tempDir)
 
-		// 保存上传的ZIP文件到临时位置
-		tempZipPath := filepath.Join(tempDir, "model.zip")
+		// 保存上传的压缩包到临时位置，格式由 ExtractAndSaveModel3D 根据文件头识别
+		tempZipPath := filepath.Join(tempDir, "model"+filepath.Ext(handler.Filename))
 		tempZipFile, err := os.Create(tempZipPath)
 		if err != nil {
 			return status.Errorf(codes.Internal, "Failed to create temp zip file: %v", err)
 		}
 		defer tempZipFile.Close()
 		// 复制上传的文件内容到临时ZIP文件
-		_, err = io.Copy(tempZipFile, file)
+		_, err = io.Copy(tempZipFile, reader)
 		if err != nil {
 			return status.Errorf(codes.Internal, "Failed to save temp zip file: %v", err)
 		}
 		tempZipFile.Close()
 
-		// 解压ZIP文件
-		modelURL, modelTextures, err := uploadSvc.ExtractAndSaveModel3D(tempZipPath)
+		// 解压压缩包
+		bundle, err := uploadSvc.ExtractModelBundle(ctx, tempZipPath,
+			model3DOptions(owner, ctx.Request().FormValue("preserve_layout"))...)
 		if err != nil {
-			return status.Errorf(codes.Internal, "Failed to extract model: %v", err)
+			return uploadError(err, codes.Internal, "Failed to extract model: %v", err)
 		}
 
-		// 返回模型URL和贴图映射列表
-		return ctx.JSON(200, types.NewSuccessResponse(map[string]interface{}{
-			"model_url":          modelURL,
-			"model_texture_urls": modelTextures, // 确保字段名为 model_texture_urls
-			"model_textures":     modelTextures, // 兼容前端当前使用的字段名
-		}))
+		// 返回模型URL、贴图映射列表和贴图检查结果
+		return ctx.JSON(200, types.NewSuccessResponse(model3DResult(bundle)))
+	}
+}
+
+// model3DOptions 解压选项，preserveLayout 为 true 时保留压缩包的目录结构
+func model3DOptions(owner, preserveLayout string) []upload.UploadOption {
+	opts := []upload.UploadOption{upload.WithOwner(owner)}
+	if ok, _ := strconv.ParseBool(preserveLayout); ok {
+		opts = append(opts, upload.WithPreserveLayout())
+	}
+	return opts
+}
+
+// model3DResult 模型上传的响应数据，references_parsed 为 true 时
+// missing_textures 为模型引用了但压缩包中没有的贴图，unused_files 为模型没有引用的文件
+func model3DResult(bundle *upload.ModelBundle) map[string]interface{} {
+	return map[string]interface{}{
+		"model_url":          bundle.ModelURL,
+		"model_texture_urls": bundle.Textures, // 确保字段名为 model_texture_urls
+		"model_textures":     bundle.Textures, // 兼容前端当前使用的字段名
+		"references_parsed":  bundle.ReferencesParsed,
+		"missing_textures":   bundle.MissingTextures,
+		"unused_files":       bundle.UnusedFiles,
 	}
 }
@@ -1,7 +1,27 @@
 package types
 
+import "time"
+
 type Upload struct {
 	Url      string `json:"url"`
 	Filename string `json:"filename"`
 	Size     int64  `json:"size"`
+	// 图片衍生图地址，key 为衍生图名称，例如 thumb
+	Derivatives map[string]string `json:"derivatives,omitempty"`
+}
+
+// File 文件浏览中的文件
+type File struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	ContentType string    `json:"content_type,omitempty"`
+}
+
+// FileList 文件浏览分页结果，NextCursor 为空时没有下一页
+type FileList struct {
+	List       []File `json:"list"`
+	Page       uint   `json:"page,omitempty"`
+	PageSize   uint   `json:"page_size"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
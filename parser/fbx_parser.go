@@ -3,6 +3,7 @@ package parser
 import (
 	"archive/zip"
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -18,6 +19,16 @@ type FBXParser interface {
 	ExtractSingleFileFromZip(file *zip.File, destPath string) error
 }
 
+// DefaultMaxExtractSize ExtractSingleFileFromZip 允许解压的最大文件大小
+const DefaultMaxExtractSize = 512 << 20
+
+var (
+	// ErrEntryTooLarge 解压后的文件超过大小限制
+	ErrEntryTooLarge = errors.New("zip entry exceeds size limit")
+	// ErrSymlinkEntry 不解压符号链接
+	ErrSymlinkEntry = errors.New("zip entry is a symlink")
+)
+
 type parser struct{}
 
 // TextureMapping 贴图映射结构
@@ -224,8 +235,17 @@ func (p *parser) parseBinaryFBXTextures(file *os.File) ([]string, error) {
 	return textures, nil
 }
 
-// ExtractSingleFileFromZip 从ZIP文件中提取单个文件
+// ExtractSingleFileFromZip 从ZIP文件中提取单个文件，解压后超过 DefaultMaxExtractSize 时删除目标文件并返回 ErrEntryTooLarge
 func (p *parser) ExtractSingleFileFromZip(file *zip.File, destPath string) error {
+	const maxSize = DefaultMaxExtractSize
+	if file.Mode()&os.ModeSymlink != 0 {
+		return ErrSymlinkEntry
+	}
+	// 先检查声明的大小，实际读取时 archive/zip 会校验不超过声明的大小
+	if file.UncompressedSize64 > uint64(maxSize) {
+		return fmt.Errorf("%w: %d > %d bytes", ErrEntryTooLarge, file.UncompressedSize64, maxSize)
+	}
+
 	// 打开ZIP中的文件
 	rc, err := file.Open()
 	if err != nil {
@@ -240,9 +260,17 @@ func (p *parser) ExtractSingleFileFromZip(file *zip.File, destPath string) error
 	}
 	defer outFile.Close()
 
-	// 复制文件内容
-	_, err = io.Copy(outFile, rc)
+	// 复制文件内容，多读一个字节用于判断是否超过限制
+	n, err := io.Copy(outFile, io.LimitReader(rc, maxSize+1))
+	if err == nil && n > maxSize {
+		err = fmt.Errorf("%w: more than %d bytes", ErrEntryTooLarge, maxSize)
+	}
 	if err != nil {
+		outFile.Close()
+		os.Remove(destPath)
+		if errors.Is(err, ErrEntryTooLarge) {
+			return err
+		}
 		return fmt.Errorf("failed to copy file content: %v", err)
 	}
 
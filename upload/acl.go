@@ -0,0 +1,194 @@
+package upload
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ACL 文件的访问权限
+type ACL string
+
+const (
+	// ACLDefault 使用驱动的默认权限：OSS 为公共读，S3 为 ParamACL，本地存储公开访问
+	ACLDefault ACL = ""
+	// ACLPublicRead 公共读
+	ACLPublicRead ACL = "public-read"
+	// ACLPrivate 私有，只能通过 SignURL 生成的签名地址访问
+	ACLPrivate ACL = "private"
+)
+
+// 签名地址的查询参数
+const (
+	// 过期时间，Unix 秒
+	QueryExpires = "expires"
+	// HMAC-SHA256 签名，十六进制
+	QuerySignature = "signature"
+)
+
+// ErrAccessDenied 签名地址无效或已过期
+var ErrAccessDenied = newKindError(ErrPermissionDenied, "access denied")
+
+// aclStorage 可以读取对象访问权限的存储驱动，例如本地存储把权限保存在属性文件中
+type aclStorage interface {
+	objectACL(key string) (ACL, error)
+}
+
+// URLSigner 支持原生签名地址的存储驱动，例如 OSS 和 S3
+type URLSigner interface {
+	// SignURL 生成 key 的下载地址，expires 后失效
+	SignURL(key string, expires time.Duration) (string, error)
+}
+
+// SignURL 生成私有文件的签名地址，expires 后失效。
+// 存储驱动支持原生签名时（例如 OSS、S3）使用驱动的签名地址，
+// 否则使用 WithSigningKey 设置的密钥签名，由 StaticFileRead 通过 VerifySignedURL 校验
+func (s *service) SignURL(fileURL string, expires time.Duration) (string, error) {
+	key := s.keyFromURL(fileURL)
+	if signer, ok := s.storage.(URLSigner); ok {
+		return signer.SignURL(key, expires)
+	}
+	if len(s.opts.signingKey) == 0 {
+		return "", fmt.Errorf("生成签名地址需要配置签名密钥")
+	}
+
+	expiresAt := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	query := url.Values{
+		QueryExpires:   {expiresAt},
+		QuerySignature: {hex.EncodeToString(s.signature(key, expiresAt))},
+	}
+	return s.fileURL(key) + "?" + query.Encode(), nil
+}
+
+// VerifySignedURL 校验 SignURL 生成的签名地址，filename 为文件路径，query 为地址的查询参数
+func (s *service) VerifySignedURL(filename string, query url.Values) error {
+	if len(s.opts.signingKey) == 0 {
+		return fmt.Errorf("%w: signing key is not configured", ErrAccessDenied)
+	}
+
+	expiresAt := query.Get(QueryExpires)
+	signature, err := hex.DecodeString(query.Get(QuerySignature))
+	if expiresAt == "" || err != nil || !hmac.Equal(signature, s.signature(s.cleanKey(filename), expiresAt)) {
+		return fmt.Errorf("%w: invalid signature", ErrAccessDenied)
+	}
+	unix, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return fmt.Errorf("%w: url expired", ErrAccessDenied)
+	}
+	return nil
+}
+
+// FileACL 获取文件的访问权限，衍生图与原图相同。没有文件记录时使用存储驱动保存的权限（例如本地存储的属性文件），
+// 仍未设置时为 WithDefaultACL 设置的权限，无法识别的权限按 ACLPrivate 处理
+func (s *service) FileACL(filename string) (ACL, error) {
+	key := s.cleanKey(filename)
+	record, err := s.lookupRecord(key)
+	if err == nil && record == nil {
+		record, err = s.lookupOriginalRecord(key)
+	}
+	if err != nil {
+		return ACLPrivate, fmt.Errorf("获取文件记录失败: %w", err)
+	}
+	return s.aclOf(key, record)
+}
+
+// aclOf 文件的访问权限，record 为文件或其原图的记录，没有记录时读取存储中的权限
+func (s *service) aclOf(key string, record *FileRecord) (ACL, error) {
+	acl := ACLDefault
+	if record != nil {
+		acl = record.ACL
+	} else if storage, ok := s.storage.(aclStorage); ok {
+		var err error
+		if acl, err = storage.objectACL(key); err != nil {
+			return ACLPrivate, err
+		}
+	}
+	if acl == ACLDefault {
+		acl = s.opts.defaultACL
+	}
+	return knownACL(acl), nil
+}
+
+// FilterVisible 返回 files 中对 owner 可见的文件，私有文件只对其上传者可见，衍生图按原图判断，
+// 无法确定权限的文件不可见。文件和原图的记录一次获取（见 RecordBatchGetter），没有记录的文件读取存储中的权限
+func (s *service) FilterVisible(files []FileInfo, owner string) ([]FileInfo, error) {
+	keys := make([]string, 0, len(files))
+	for _, file := range files {
+		keys = append(keys, file.Key)
+		keys = append(keys, s.originalKeys(file.Key)...)
+	}
+	records, err := s.lookupRecords(keys)
+	if err != nil {
+		return nil, fmt.Errorf("获取文件记录失败: %w", err)
+	}
+
+	visible := make([]FileInfo, 0, len(files))
+	for _, file := range files {
+		record := records[file.Key]
+		for _, original := range s.originalKeys(file.Key) {
+			if record != nil {
+				break
+			}
+			record = records[original]
+		}
+		acl, err := s.aclOf(file.Key, record)
+		if err != nil {
+			continue
+		}
+		if acl != ACLPrivate || (owner != "" && record != nil && record.HasOwner(owner)) {
+			visible = append(visible, file)
+		}
+	}
+	return visible, nil
+}
+
+// knownACL 无法识别的权限（例如手动修改的属性文件）按 ACLPrivate 处理，避免私有文件被公开访问
+func knownACL(acl ACL) ACL {
+	switch acl {
+	case ACLDefault, ACLPublicRead, ACLPrivate:
+		return acl
+	}
+	return ACLPrivate
+}
+
+// signature 签名内容为 key 和过期时间
+func (s *service) signature(key, expiresAt string) []byte {
+	mac := hmac.New(sha256.New, s.opts.signingKey)
+	mac.Write([]byte(key + "\n" + expiresAt))
+	return mac.Sum(nil)
+}
+
+// lookupOriginalRecord key 为衍生图时返回原图的文件记录，否则返回 nil
+func (s *service) lookupOriginalRecord(key string) (*FileRecord, error) {
+	for _, original := range s.originalKeys(key) {
+		record, err := s.lookupRecord(original)
+		if record != nil || err != nil {
+			return record, err
+		}
+	}
+	return nil, nil
+}
+
+// originalKeys key 为衍生图时可能的原图 key，衍生图的扩展名可能与原图不同，按顺序逐个尝试
+func (s *service) originalKeys(key string) []string {
+	var originals []string
+	stem := strings.TrimSuffix(key, path.Ext(key))
+	for _, v := range s.opts.imageVariants {
+		base, ok := strings.CutSuffix(stem, "_"+v.Name)
+		if !ok {
+			continue
+		}
+		for _, ext := range []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp"} {
+			if original := base + ext; derivativeKey(original, v) == key {
+				originals = append(originals, original)
+			}
+		}
+	}
+	return originals
+}
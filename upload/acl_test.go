@@ -0,0 +1,188 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrivateFiles(t *testing.T) {
+	svc, err := New("http://127.0.0.1:3000", "docs", WithStorage(newMemStorage()),
+		WithFileRepository(NewMemoryFileRepository()), WithSigningKey([]byte("secret")),
+		WithImageVariants(ImageVariant{Name: "thumb", Width: 10, Height: 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private, err := svc.UploadFile(strings.NewReader("contract"), "contract.txt", WithACL(ACLPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := svc.UploadFile(strings.NewReader("notice"), "notice.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acl, _ := svc.FileACL("docs/contract.txt"); acl != ACLPrivate {
+		t.Errorf("expected private acl, got %q", acl)
+	}
+	if acl, _ := svc.FileACL(public); acl != ACLDefault {
+		t.Errorf("expected default acl, got %q", acl)
+	}
+
+	// 私有图片的衍生图同样是私有的
+	if _, err = svc.UploadFile(bytes.NewReader(pngBytes(t, 40, 20)), "scan.png", WithACL(ACLPrivate)); err != nil {
+		t.Fatal(err)
+	}
+	if acl, _ := svc.FileACL("docs/scan_thumb.png"); acl != ACLPrivate {
+		t.Errorf("expected private derivative, got %q", acl)
+	}
+
+	signed, err := svc.SignURL(private, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/docs/contract.txt" {
+		t.Errorf("unexpected signed url %s", signed)
+	}
+	if err = svc.VerifySignedURL(u.Path, u.Query()); err != nil {
+		t.Errorf("valid signature rejected: %v", err)
+	}
+
+	// 签名只对签名的文件有效
+	if err = svc.VerifySignedURL("docs/notice.txt", u.Query()); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("signature for another file should be rejected, got %v", err)
+	}
+	tampered := u.Query()
+	tampered.Set(QueryExpires, "9999999999")
+	if err = svc.VerifySignedURL(u.Path, tampered); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("tampered expiry should be rejected, got %v", err)
+	}
+	if err = svc.VerifySignedURL(u.Path, url.Values{}); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("unsigned url should be rejected, got %v", err)
+	}
+
+	expired, err := svc.SignURL(private, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ = url.Parse(expired)
+	if err = svc.VerifySignedURL(u.Path, u.Query()); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("expired url should be rejected, got %v", err)
+	}
+}
+
+func TestPrivateFilesRequireRepository(t *testing.T) {
+	svc, err := New("", "docs", WithStorage(newMemStorage()), WithDefaultACL(ACLPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("contract"), "contract.txt"); err == nil {
+		t.Error("expected error without file repository")
+	}
+	if _, err = svc.SignURL("docs/contract.txt", time.Minute); err == nil {
+		t.Error("expected error without signing key")
+	}
+}
+
+func TestLocalACLSidecar(t *testing.T) {
+	root := t.TempDir()
+	svc, err := New("", "docs", WithDriverParam(ParamRoot, root), WithFileRepository(NewMemoryFileRepository()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("contract"), "contract.txt", WithACL(ACLPrivate)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有文件记录时读取属性文件中的权限
+	restarted, err := New("", "docs", WithDriverParam(ParamRoot, root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acl, err := restarted.FileACL("uploads/docs/contract.txt"); err != nil || acl != ACLPrivate {
+		t.Errorf("acl = %q, %v", acl, err)
+	}
+
+	// 无法识别的权限按私有处理
+	metaPath := filepath.Join(root, localMetaDir, "uploads", "docs", "contract.txt.json")
+	if err = os.WriteFile(metaPath, []byte(`{"acl":"authenticated-read"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if acl, err := restarted.FileACL("uploads/docs/contract.txt"); err != nil || acl != ACLPrivate {
+		t.Errorf("unknown acl = %q, %v", acl, err)
+	}
+}
+
+// countingRepository 统计单个和批量获取记录的次数
+type countingRepository struct {
+	FileRepository
+	gets, batches int
+}
+
+func (r *countingRepository) Get(key string) (*FileRecord, error) {
+	r.gets++
+	return r.FileRepository.Get(key)
+}
+
+func (r *countingRepository) GetMany(keys []string) (map[string]*FileRecord, error) {
+	r.batches++
+	return r.FileRepository.(RecordBatchGetter).GetMany(keys)
+}
+
+func TestFilterVisible(t *testing.T) {
+	repo := &countingRepository{FileRepository: NewMemoryFileRepository()}
+	svc, err := New("http://127.0.0.1:3000", "docs", WithStorage(newMemStorage()), WithFileRepository(repo),
+		WithImageVariants(ImageVariant{Name: "thumb", Width: 10, Height: 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("notice"), "notice.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("contract"), "contract.txt", WithOwner("alice"), WithACL(ACLPrivate)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(bytes.NewReader(pngBytes(t, 40, 20)), "scan.png", WithOwner("alice"), WithACL(ACLPrivate)); err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := svc.List("docs/", "", 10)
+	if err != nil || len(files) != 4 {
+		t.Fatalf("list = %v, %v", files, err)
+	}
+
+	keys := func(files []FileInfo) string {
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Key)
+		}
+		return strings.Join(names, ",")
+	}
+	repo.gets = 0
+	filter := svc.(*service)
+	for owner, want := range map[string]string{
+		"":      "docs/notice.txt",
+		"bob":   "docs/notice.txt",
+		"alice": "docs/contract.txt,docs/notice.txt,docs/scan.png,docs/scan_thumb.png",
+	} {
+		visible, err := filter.FilterVisible(files, owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := keys(visible); got != want {
+			t.Errorf("visible to %q = %s, want %s", owner, got, want)
+		}
+	}
+	// 每次过滤只批量获取一次记录
+	if repo.gets != 0 || repo.batches != 3 {
+		t.Errorf("gets = %d, batches = %d", repo.gets, repo.batches)
+	}
+}
@@ -0,0 +1,176 @@
+package upload
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"strings"
+)
+
+// 压缩包校验失败的原因，通过 ArchiveError 返回
+var (
+	ErrArchiveTooManyEntries = newKindError(ErrInvalidArgument, "too many entries in archive")
+	ErrArchiveTooLarge       = newKindError(ErrInvalidArgument, "archive uncompressed size exceeds limit")
+	ErrArchiveEntryTooLarge  = newKindError(ErrInvalidArgument, "archive entry exceeds size limit")
+	ErrArchiveRatio          = newKindError(ErrInvalidArgument, "archive entry compression ratio exceeds limit")
+	ErrArchiveUnsafePath     = newKindError(ErrInvalidArgument, "archive entry path is unsafe")
+	ErrArchiveSymlink        = newKindError(ErrInvalidArgument, "archive entry is a symlink")
+	ErrArchiveNested         = newKindError(ErrInvalidArgument, "nested archive is not allowed")
+	ErrArchiveInvalid        = newKindError(ErrInvalidArgument, "archive is corrupted")
+	ErrArchiveUnsupported    = newKindError(ErrInvalidArgument, "unsupported archive format")
+)
+
+// ArchiveError 压缩包内容不合法，属于客户端错误
+type ArchiveError struct {
+	// 出错的文件名，整体超限时为空
+	Entry string
+	Err   error
+}
+
+func (e *ArchiveError) Error() string {
+	if e.Entry == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Entry, e.Err)
+}
+
+func (e *ArchiveError) Unwrap() error {
+	return e.Err
+}
+
+// ExtractLimits 解压限制，0 表示不限制
+type ExtractLimits struct {
+	// 最多文件数（包括目录）
+	MaxEntries int
+	// 解压后的总大小
+	MaxTotalSize int64
+	// 单个文件解压后的大小
+	MaxEntrySize int64
+	// 最大压缩比，小于 ratioMinSize 的文件不检查。
+	// zip 按单个文件检查，tar.gz 和 tar.zst 按整个压缩包检查
+	MaxRatio int64
+}
+
+// DefaultExtractLimits 默认解压限制
+var DefaultExtractLimits = ExtractLimits{
+	MaxEntries:   1000,
+	MaxTotalSize: 1 << 30,
+	MaxEntrySize: 512 << 20,
+	MaxRatio:     100,
+}
+
+// 小文件压缩比可能很高（例如全零的贴图），只检查超过该大小的文件
+const ratioMinSize = 1 << 20
+
+// 不允许嵌套的压缩包格式
+var nestedArchiveTypes = map[string]bool{
+	".zip": true, ".rar": true, ".7z": true, ".tar": true, ".gz": true,
+	".tgz": true, ".bz2": true, ".xz": true, ".zst": true,
+}
+
+// archiveEntryInfo 校验所需的文件信息
+type archiveEntryInfo struct {
+	name           string
+	mode           os.FileMode
+	size           int64
+	compressedSize int64 // 未知时为 0
+}
+
+// archiveChecker 解压前根据文件头中声明的大小逐个检查压缩包中的文件。
+// archive/zip 和 archive/tar 读取时会校验实际大小不超过声明的大小，因此声明的大小可信
+type archiveChecker struct {
+	limits ExtractLimits
+	count  int
+	total  int64
+}
+
+// check 检查下一个文件
+func (c *archiveChecker) check(entry archiveEntryInfo) error {
+	c.count++
+	if c.limits.MaxEntries > 0 && c.count > c.limits.MaxEntries {
+		return &ArchiveError{Err: fmt.Errorf("%w: > %d", ErrArchiveTooManyEntries, c.limits.MaxEntries)}
+	}
+	if err := checkArchiveEntry(entry, c.limits); err != nil {
+		return &ArchiveError{Entry: entry.name, Err: err}
+	}
+	if entry.mode.IsDir() {
+		return nil
+	}
+
+	// 用减法比较，避免累加溢出
+	if c.limits.MaxTotalSize > 0 && entry.size > c.limits.MaxTotalSize-c.total {
+		return &ArchiveError{Err: fmt.Errorf("%w: %d bytes", ErrArchiveTooLarge, c.limits.MaxTotalSize)}
+	}
+	c.total += entry.size
+	return nil
+}
+
+// checkRatio 整体压缩的格式无法得知单个文件的压缩大小，按整个压缩包检查压缩比
+func (c *archiveChecker) checkRatio(archiveSize int64) error {
+	if c.limits.MaxRatio > 0 && c.total > ratioMinSize && archiveSize > 0 &&
+		c.total/archiveSize > c.limits.MaxRatio {
+		return &ArchiveError{Err: fmt.Errorf("%w: %d", ErrArchiveRatio, c.total/archiveSize)}
+	}
+	return nil
+}
+
+// checkArchiveEntry 检查单个文件的路径、类型和大小
+func checkArchiveEntry(entry archiveEntryInfo, limits ExtractLimits) error {
+	if !isSafeEntryName(entry.name) {
+		return ErrArchiveUnsafePath
+	}
+	if entry.mode&os.ModeSymlink != 0 {
+		return ErrArchiveSymlink
+	}
+	if entry.mode.IsDir() {
+		return nil
+	}
+	if !entry.mode.IsRegular() {
+		return fmt.Errorf("%w: unsupported file mode %s", ErrArchiveUnsafePath, entry.mode)
+	}
+	if nestedArchiveTypes[strings.ToLower(path.Ext(entry.name))] {
+		return ErrArchiveNested
+	}
+
+	if limits.MaxEntrySize > 0 && entry.size > limits.MaxEntrySize {
+		return fmt.Errorf("%w: %d > %d bytes", ErrArchiveEntryTooLarge, entry.size, limits.MaxEntrySize)
+	}
+	if limits.MaxRatio > 0 && entry.size > ratioMinSize && entry.compressedSize > 0 &&
+		entry.size/entry.compressedSize > limits.MaxRatio {
+		return fmt.Errorf("%w: %d", ErrArchiveRatio, entry.size/entry.compressedSize)
+	}
+	return nil
+}
+
+// isSafeEntryName 文件名必须是相对路径，且不能通过 .. 跳出解压目录（zip-slip）
+func isSafeEntryName(name string) bool {
+	if name == "" || strings.ContainsRune(name, 0) {
+		return false
+	}
+
+	name = strings.ReplaceAll(name, "\\", "/")
+	// 绝对路径和 Windows 盘符
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// entryPath 将压缩包中的文件名转换为统一使用 / 分隔的相对路径，文件名已经过 isSafeEntryName 检查
+func entryPath(name string) string {
+	return path.Clean(strings.ReplaceAll(name, "\\", "/"))
+}
+
+// clampInt64 防止伪造的超大 uint64 转换为负数绕过大小检查
+func clampInt64(n uint64) int64 {
+	if n > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(n)
+}
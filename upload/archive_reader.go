@@ -0,0 +1,240 @@
+package upload
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 压缩包格式
+const (
+	archiveZip    = "zip"
+	archiveTar    = "tar"
+	archiveTarGz  = "tar.gz"
+	archiveTarZst = "tar.zst"
+)
+
+// zstd 解压窗口上限，防止恶意的帧头申请过多内存
+const zstdMaxWindow = 128 << 20
+
+// archiveReader 按顺序读取压缩包中的文件，zip 和 tar 使用相同的方式遍历
+type archiveReader interface {
+	// Next 返回下一个文件，读取完毕时返回 io.EOF。返回的 reader 在下一次调用 Next 前有效
+	Next() (*archiveEntryInfo, io.Reader, error)
+	// Format 压缩包格式
+	Format() string
+	Close() error
+}
+
+// IsModelArchive 文件名是否为支持的模型压缩包格式
+func IsModelArchive(filename string) bool {
+	filename = strings.ToLower(filename)
+	for _, ext := range ModelArchiveTypes {
+		if strings.HasSuffix(filename, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// openArchive 根据文件头识别压缩包格式并打开
+func openArchive(archivePath string) (archiveReader, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %v", err)
+	}
+
+	header, _ := bufio.NewReader(file).Peek(SniffLen)
+	detected := DetectType(header)
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read archive: %v", err)
+	}
+
+	switch detected {
+	case ".zip", ".xlsx":
+		file.Close()
+		reader, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, &ArchiveError{Err: fmt.Errorf("%w: %v", ErrArchiveInvalid, err)}
+		}
+		return &zipArchiveReader{reader: reader}, nil
+	case ".tar":
+		return newTarArchiveReader(archiveTar, file, file, nil), nil
+	case ".gz":
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, &ArchiveError{Err: fmt.Errorf("%w: %v", ErrArchiveInvalid, err)}
+		}
+		return newTarArchiveReader(archiveTarGz, file, gz, gz), nil
+	case ".zst":
+		zr, err := zstd.NewReader(file, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+		if err != nil {
+			file.Close()
+			return nil, &ArchiveError{Err: fmt.Errorf("%w: %v", ErrArchiveInvalid, err)}
+		}
+		return newTarArchiveReader(archiveTarZst, file, zr, zstdCloser{zr}), nil
+	default:
+		file.Close()
+		return nil, &ArchiveError{Err: ErrArchiveUnsupported}
+	}
+}
+
+// zipArchiveReader 遍历 zip 中的文件，文件内容在第一次读取时才解压
+type zipArchiveReader struct {
+	reader  *zip.ReadCloser
+	index   int
+	current *zipEntryReader
+}
+
+func (z *zipArchiveReader) Next() (*archiveEntryInfo, io.Reader, error) {
+	z.closeCurrent()
+	if z.index >= len(z.reader.File) {
+		return nil, nil, io.EOF
+	}
+
+	file := z.reader.File[z.index]
+	z.index++
+	entry := zipEntryInfo(file)
+	z.current = &zipEntryReader{file: file}
+	return &entry, z.current, nil
+}
+
+func (z *zipArchiveReader) Format() string {
+	return archiveZip
+}
+
+func (z *zipArchiveReader) Close() error {
+	z.closeCurrent()
+	return z.reader.Close()
+}
+
+func (z *zipArchiveReader) closeCurrent() {
+	if z.current != nil && z.current.rc != nil {
+		z.current.rc.Close()
+	}
+	z.current = nil
+}
+
+type zipEntryReader struct {
+	file *zip.File
+	rc   io.ReadCloser
+}
+
+func (r *zipEntryReader) Read(p []byte) (int, error) {
+	if r.rc == nil {
+		rc, err := r.file.Open()
+		if err != nil {
+			return 0, &ArchiveError{Entry: r.file.Name, Err: fmt.Errorf("%w: %v", ErrArchiveInvalid, err)}
+		}
+		r.rc = rc
+	}
+	return r.rc.Read(p)
+}
+
+// zipEntryInfo 读取 zip 文件头中的信息
+func zipEntryInfo(file *zip.File) archiveEntryInfo {
+	return archiveEntryInfo{
+		name:           file.Name,
+		mode:           file.Mode(),
+		size:           clampInt64(file.UncompressedSize64),
+		compressedSize: clampInt64(file.CompressedSize64),
+	}
+}
+
+// tarArchiveReader 遍历 tar 中的文件，支持 gzip 和 zstd 压缩
+type tarArchiveReader struct {
+	format       string
+	file         *os.File
+	decompressor io.Closer
+	reader       *tar.Reader
+}
+
+func newTarArchiveReader(format string, file *os.File, stream io.Reader, decompressor io.Closer) *tarArchiveReader {
+	return &tarArchiveReader{
+		format:       format,
+		file:         file,
+		decompressor: decompressor,
+		reader:       tar.NewReader(stream),
+	}
+}
+
+func (t *tarArchiveReader) Next() (*archiveEntryInfo, io.Reader, error) {
+	for {
+		header, err := t.reader.Next()
+		if err == io.EOF {
+			return nil, nil, io.EOF
+		}
+		if err != nil {
+			return nil, nil, &ArchiveError{Err: fmt.Errorf("%w: %v", ErrArchiveInvalid, err)}
+		}
+		// pax 全局头不是文件
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		entry := tarEntryInfo(header)
+		return &entry, t.reader, nil
+	}
+}
+
+func (t *tarArchiveReader) Format() string {
+	return t.format
+}
+
+func (t *tarArchiveReader) Close() error {
+	if t.decompressor != nil {
+		t.decompressor.Close()
+	}
+	return t.file.Close()
+}
+
+// tarEntryInfo 读取 tar 文件头中的信息，硬链接按符号链接处理
+func tarEntryInfo(header *tar.Header) archiveEntryInfo {
+	mode := header.FileInfo().Mode()
+	if header.Typeflag == tar.TypeLink {
+		mode |= os.ModeSymlink
+	}
+	return archiveEntryInfo{
+		name: header.Name,
+		mode: mode,
+		size: header.Size,
+	}
+}
+
+// zstdCloser zstd.Decoder 的 Close 没有返回值
+type zstdCloser struct {
+	decoder *zstd.Decoder
+}
+
+func (z zstdCloser) Close() error {
+	z.decoder.Close()
+	return nil
+}
+
+// extractEntry 将压缩包中的文件保存到 destPath，失败时删除不完整的文件。
+// 文件大小已经过 archiveChecker 检查，读取时由 archive/zip 和 archive/tar 保证不超过声明的大小
+func extractEntry(reader io.Reader, destPath string) error {
+	file, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	if _, err = io.Copy(file, reader); err != nil {
+		file.Close()
+		os.Remove(destPath)
+		return fmt.Errorf("failed to extract file: %v", err)
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(destPath)
+		return fmt.Errorf("failed to extract file: %v", err)
+	}
+	return nil
+}
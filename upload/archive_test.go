@@ -0,0 +1,284 @@
+package upload
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type zipEntry struct {
+	name string
+	data []byte
+	mode os.FileMode
+}
+
+func writeZip(t testing.TB, entries ...zipEntry) string {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		if entry.mode != 0 {
+			header.SetMode(entry.mode)
+		}
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zipPath := filepath.Join(t.TempDir(), "model.zip")
+	if err := os.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return zipPath
+}
+
+// writeTar 生成 tar 压缩包，format 为 tar、tar.gz 或 tar.zst。
+// mode 为符号链接时 data 为链接目标
+func writeTar(t *testing.T, format string, entries ...zipEntry) string {
+	var buf bytes.Buffer
+	var stream io.WriteCloser
+	switch format {
+	case archiveTarGz:
+		stream = gzip.NewWriter(&buf)
+	case archiveTarZst:
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = zw
+	default:
+		stream = nopWriteCloser{&buf}
+	}
+
+	w := tar.NewWriter(stream)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.data)), Typeflag: tar.TypeReg}
+		if entry.mode&os.ModeSymlink != 0 {
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, string(entry.data), 0
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := w.Write(entry.data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tarPath := filepath.Join(t.TempDir(), "model."+format)
+	if err := os.WriteFile(tarPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return tarPath
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// randomBytes 生成不可压缩的数据
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+func TestExtractLimits(t *testing.T) {
+	model := zipEntry{name: "model.obj", data: []byte("v 0 0 0")}
+	limits := ExtractLimits{MaxEntries: 3, MaxTotalSize: 4 << 20, MaxEntrySize: 3 << 20, MaxRatio: 100}
+
+	cases := []struct {
+		name    string
+		entries []zipEntry
+		err     error
+	}{
+		{"too many entries", []zipEntry{model, {name: "a.png"}, {name: "b.png"}, {name: "c.png"}}, ErrArchiveTooManyEntries},
+		{"zip slip", []zipEntry{model, {name: "../evil.png"}}, ErrArchiveUnsafePath},
+		{"zip slip backslash", []zipEntry{model, {name: "textures\\..\\..\\evil.png"}}, ErrArchiveUnsafePath},
+		{"absolute path", []zipEntry{model, {name: "/etc/evil.png"}}, ErrArchiveUnsafePath},
+		{"symlink", []zipEntry{model, {name: "link.png", data: []byte("/etc/passwd"), mode: os.ModeSymlink | 0777}}, ErrArchiveSymlink},
+		{"nested archive", []zipEntry{model, {name: "inner.zip", data: []byte("PK")}}, ErrArchiveNested},
+		{"entry too large", []zipEntry{model, {name: "big.png", data: randomBytes(4 << 20)}}, ErrArchiveEntryTooLarge},
+		{"compression ratio", []zipEntry{model, {name: "zeros.png", data: make([]byte, 2<<20)}}, ErrArchiveRatio},
+		{"total size", []zipEntry{model,
+			{name: "a.png", data: randomBytes(2400 << 10)},
+			{name: "b.png", data: randomBytes(2400 << 10)},
+		}, ErrArchiveTooLarge},
+	}
+
+	mem := newMemStorage()
+	svc, err := New("", "models", WithStorage(mem), WithExtractLimits(limits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		_, _, err := svc.ExtractAndSaveModel3D(writeZip(t, c.entries...))
+		var archiveErr *ArchiveError
+		if !errors.As(err, &archiveErr) || !errors.Is(err, c.err) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+	}
+	if len(mem.objects) != 0 {
+		t.Errorf("nothing should be uploaded from a rejected archive, got %d objects", len(mem.objects))
+	}
+
+	// 合法的压缩包
+	if _, _, err = svc.ExtractAndSaveModel3D(writeZip(t, model, zipEntry{name: "textures/wood.png", data: []byte("png")})); err != nil {
+		t.Errorf("valid archive rejected: %v", err)
+	}
+}
+
+func TestExtractTarModel3D(t *testing.T) {
+	model := zipEntry{name: "bundle/model.obj", data: []byte("v 0 0 0")}
+	texture := zipEntry{name: "bundle/textures/wood.png", data: []byte("png")}
+
+	for _, format := range []string{archiveTar, archiveTarGz, archiveTarZst} {
+		mem := newMemStorage()
+		svc, err := New("", "models", WithStorage(mem))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		modelURL, textures, err := svc.ExtractAndSaveModel3D(writeTar(t, format, model, texture))
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !strings.HasSuffix(modelURL, ".obj") {
+			t.Errorf("%s: unexpected model url %s", format, modelURL)
+		}
+		// 与 zip 相同的贴图映射
+		if len(textures) != 1 || textures[0].Source != "wood.png" || !strings.HasSuffix(textures[0].Target, ".png") {
+			t.Errorf("%s: unexpected textures %+v", format, textures)
+		}
+		if len(mem.objects) != 2 {
+			t.Errorf("%s: expected 2 objects, got %d", format, len(mem.objects))
+		}
+	}
+}
+
+func TestExtractTarLimits(t *testing.T) {
+	model := zipEntry{name: "model.obj", data: []byte("v 0 0 0")}
+	cases := []struct {
+		name string
+		path string
+		err  error
+	}{
+		{"tar slip", writeTar(t, archiveTar, model, zipEntry{name: "../evil.png"}), ErrArchiveUnsafePath},
+		{"tar symlink", writeTar(t, archiveTarGz, model, zipEntry{name: "link.png", data: []byte("/etc/passwd"), mode: os.ModeSymlink}), ErrArchiveSymlink},
+		{"nested archive", writeTar(t, archiveTarZst, model, zipEntry{name: "inner.tar.gz", data: []byte("gz")}), ErrArchiveNested},
+		// 整体压缩时按压缩包大小检查压缩比
+		{"compression ratio", writeTar(t, archiveTarGz, model, zipEntry{name: "zeros.png", data: make([]byte, 2<<20)}), ErrArchiveRatio},
+	}
+
+	mem := newMemStorage()
+	svc, err := New("", "models", WithStorage(mem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		_, _, err := svc.ExtractAndSaveModel3D(c.path)
+		var archiveErr *ArchiveError
+		if !errors.As(err, &archiveErr) || !errors.Is(err, c.err) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+	}
+	if len(mem.objects) != 0 {
+		t.Errorf("nothing should be uploaded from a rejected archive, got %d objects", len(mem.objects))
+	}
+
+	if _, _, err = svc.ExtractAndSaveModel3D(filepath.Join(t.TempDir(), "missing.tar")); err == nil {
+		t.Error("expected error for missing archive")
+	}
+}
+
+func TestIsModelArchive(t *testing.T) {
+	for name, want := range map[string]bool{
+		"a.zip": true, "a.TAR": true, "a.tar.gz": true, "a.tgz": true, "a.tar.zst": true, "a.tzst": true,
+		"a.gz": false, "a.rar": false, "a.fbx": false,
+	} {
+		if got := IsModelArchive(name); got != want {
+			t.Errorf("IsModelArchive(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestExtractPreserveLayout(t *testing.T) {
+	mem := newMemStorage()
+	svc, err := New("http://cdn.example.com", "models", WithStorage(mem))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := writeZip(t,
+		zipEntry{name: "model.obj", data: []byte("v 0 0 0")},
+		zipEntry{name: "textures/wood/diffuse.png", data: []byte("wood")},
+		zipEntry{name: "textures\\metal\\diffuse.png", data: []byte("metal")},
+	)
+	modelURL, textures, err := svc.ExtractAndSaveModel3D(archive, WithPreserveLayout())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 模型和贴图在同一个目录下，相对路径保持不变
+	dir := strings.TrimSuffix(modelURL, "model.obj")
+	if dir == modelURL || !strings.HasPrefix(dir, "http://cdn.example.com/models/") {
+		t.Fatalf("unexpected model url %s", modelURL)
+	}
+	want := map[string]string{
+		"textures/wood/diffuse.png":  dir + "textures/wood/diffuse.png",
+		"textures/metal/diffuse.png": dir + "textures/metal/diffuse.png",
+	}
+	if len(textures) != len(want) {
+		t.Fatalf("unexpected textures %+v", textures)
+	}
+	for _, texture := range textures {
+		if want[texture.Source] != texture.Target {
+			t.Errorf("unexpected mapping %s -> %s", texture.Source, texture.Target)
+		}
+	}
+	if len(mem.objects) != 3 {
+		t.Errorf("same-named textures should not collide, got %d objects", len(mem.objects))
+	}
+
+	// 每次上传使用单独的目录
+	other, _, err := svc.ExtractAndSaveModel3D(archive, WithPreserveLayout())
+	if err != nil || other == modelURL {
+		t.Errorf("expected a new upload directory, got %s, %v", other, err)
+	}
+
+	dedupSvc, err := New("", "models", WithStorage(newMemStorage()), WithContentAddressed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = dedupSvc.ExtractAndSaveModel3D(archive, WithPreserveLayout()); err == nil {
+		t.Error("expected error with content addressing")
+	}
+}
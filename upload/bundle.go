@@ -0,0 +1,316 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nuominmin/biz/parser"
+)
+
+// ErrNoModelFound 压缩包中没有支持的模型文件
+var ErrNoModelFound = newKindError(ErrInvalidArgument, "no supported 3D model file found in archive")
+
+// BundleEntryError 压缩包中单个文件上传失败的原因
+type BundleEntryError struct {
+	// 压缩包中的文件路径
+	Entry string
+	Err   error
+}
+
+func (e BundleEntryError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Entry, e.Err)
+}
+
+// BundleError 模型压缩包上传失败，本次已上传的文件已全部删除
+type BundleError struct {
+	// 整体失败的原因，例如没有找到模型文件或读取压缩包失败
+	Err error
+	// 上传失败的文件
+	Entries []BundleEntryError
+	// 删除已上传的文件时的错误，不为空时可能残留部分文件
+	Rollback error
+}
+
+func (e *BundleError) Error() string {
+	var parts []string
+	if e.Err != nil {
+		parts = append(parts, e.Err.Error())
+	}
+	if len(e.Entries) > 0 {
+		entries := make([]string, 0, len(e.Entries))
+		for _, entry := range e.Entries {
+			entries = append(entries, entry.Error())
+		}
+		parts = append(parts, fmt.Sprintf("%d entries failed: %s", len(e.Entries), strings.Join(entries, "; ")))
+	}
+	if e.Rollback != nil {
+		parts = append(parts, fmt.Sprintf("rollback failed: %v", e.Rollback))
+	}
+	return "model bundle upload failed: " + strings.Join(parts, "; ")
+}
+
+// Unwrap 支持通过 errors.Is 判断具体原因，例如 ErrQuotaExceeded
+func (e *BundleError) Unwrap() []error {
+	var errs []error
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	for _, entry := range e.Entries {
+		errs = append(errs, entry.Err)
+	}
+	if e.Rollback != nil {
+		errs = append(errs, e.Rollback)
+	}
+	return errs
+}
+
+// ModelBundle 模型压缩包的上传结果
+type ModelBundle struct {
+	// 模型文件地址
+	ModelURL string `json:"model_url"`
+	// 贴图映射，顺序与压缩包中一致
+	Textures []parser.TextureMapping `json:"textures"`
+	// 是否解析到了模型的贴图引用，目前只支持 FBX。为 false 时不检查缺少和多余的文件
+	ReferencesParsed bool `json:"references_parsed"`
+	// 模型引用了但压缩包中没有的贴图
+	MissingTextures []string `json:"missing_textures"`
+	// 压缩包中模型没有引用的文件（相对路径）
+	UnusedFiles []string `json:"unused_files"`
+}
+
+// textureReport 根据模型的贴图引用找出缺少和多余的文件，model 为模型文件的相对路径。
+// FBX 中的贴图引用只保留文件名，按文件名不区分大小写匹配
+func textureReport(references []string, results []*bundleResult, model string) (missing, unused []string) {
+	files := make(map[string]bool, len(results))
+	for _, result := range results {
+		files[strings.ToLower(path.Base(result.path))] = true
+	}
+
+	missing, unused = []string{}, []string{}
+	referenced := make(map[string]bool, len(references))
+	for _, reference := range references {
+		name := strings.ToLower(path.Base(strings.ReplaceAll(reference, "\\", "/")))
+		if referenced[name] {
+			continue
+		}
+		referenced[name] = true
+		if !files[name] {
+			missing = append(missing, reference)
+		}
+	}
+	for _, result := range results {
+		if result.path != model && !referenced[strings.ToLower(path.Base(result.path))] {
+			unused = append(unused, result.path)
+		}
+	}
+	return missing, unused
+}
+
+// 默认并发上传的文件数
+const defaultExtractConcurrency = 4
+
+// 小于该大小的文件读入内存，否则写入临时文件
+const spoolMemLimit = 1 << 20
+
+// bundleTx 记录模型压缩包上传过程中新建的文件，失败时全部删除。
+// 覆盖的已有文件和内容寻址命中的已有对象不是本次新建的，不删除
+type bundleTx struct {
+	svc    *service
+	mu     sync.Mutex
+	keys   []string
+	owner  string                   // 上传者，内容寻址时回滚只移除其引用
+	failed map[int]BundleEntryError // 按文件在压缩包中的顺序
+}
+
+// upload 上传压缩包中的第 index 个文件，失败时记录原因
+func (tx *bundleTx) upload(index int, entry string, reader io.Reader, name string, opts uploadOptions) (string, error) {
+	key, created, err := tx.svc.upload(reader, name, opts)
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if err != nil {
+		if tx.failed == nil {
+			tx.failed = make(map[int]BundleEntryError)
+		}
+		tx.failed[index] = BundleEntryError{Entry: entry, Err: err}
+		return "", err
+	}
+	if created {
+		tx.keys = append(tx.keys, key)
+		tx.owner = opts.owner
+	}
+	return tx.svc.fileURL(key), nil
+}
+
+// abort 删除本次上传的所有文件，返回包含失败原因的 BundleError
+func (tx *bundleTx) abort(err error) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	var errs []error
+	for _, key := range tx.keys {
+		if deleteErr := tx.svc.rollback(key, tx.owner); deleteErr != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, deleteErr))
+		}
+	}
+	tx.keys = nil
+
+	indexes := make([]int, 0, len(tx.failed))
+	for index := range tx.failed {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	entries := make([]BundleEntryError, 0, len(indexes))
+	for _, index := range indexes {
+		entries = append(entries, tx.failed[index])
+	}
+	return &BundleError{Err: err, Entries: entries, Rollback: errors.Join(errs...)}
+}
+
+// rollback 删除本次上传新建的文件。内容寻址且有文件记录时只移除 owner 的引用，
+// 没有文件记录时对象是本次新写入的，直接删除
+func (s *service) rollback(key, owner string) error {
+	if s.opts.contentAddressed && s.opts.repository == nil {
+		if err := s.storage.Delete(key); err != nil {
+			return err
+		}
+		s.deleteDerivatives(key)
+		return nil
+	}
+	return s.deleteFile(key, owner, true)
+}
+
+// bundleResult 压缩包中一个文件的上传结果，失败时 url 为空
+type bundleResult struct {
+	path string // 压缩包中的相对路径
+	url  string
+}
+
+type bundleJob struct {
+	index  int
+	result *bundleResult
+	reader io.ReadCloser
+	name   string
+	opts   uploadOptions
+}
+
+// uploadBundle 顺序读取压缩包中的文件，通过 extractConcurrency 个协程并发上传。
+// target 返回文件上传时的文件名和选项。返回的结果与压缩包中的文件顺序一致，
+// 单个文件上传失败记录在 tx 中，超出配额时停止读取后续文件
+func (s *service) uploadBundle(ctx context.Context, tx *bundleTx, archive archiveReader, tempDir string,
+	target func(relPath string) (string, uploadOptions)) ([]*bundleResult, error) {
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan bundleJob)
+	var wg sync.WaitGroup
+	for i := 0; i < max(s.opts.extractConcurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				url, err := tx.upload(job.index, job.result.path, job.reader, job.name, job.opts)
+				job.reader.Close()
+				// 超出配额时后续文件也无法上传
+				if errors.Is(err, ErrQuotaExceeded) {
+					cancel()
+				}
+				job.result.url = url
+			}
+		}()
+	}
+
+	var results []*bundleResult
+	var readErr error
+read:
+	for index := 0; workerCtx.Err() == nil; index++ {
+		entry, reader, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+		// 跳过目录
+		if entry.mode.IsDir() {
+			continue
+		}
+
+		// 读取后交给上传协程，tar 只能顺序读取
+		data, err := spoolEntry(reader, tempDir)
+		if err != nil {
+			var archiveErr *ArchiveError
+			if !errors.As(err, &archiveErr) {
+				err = &ArchiveError{Entry: entry.name, Err: fmt.Errorf("%w: %v", ErrArchiveInvalid, err)}
+			}
+			readErr = err
+			break
+		}
+
+		result := &bundleResult{path: entryPath(entry.name)}
+		results = append(results, result)
+		name, opts := target(result.path)
+		select {
+		case jobs <- bundleJob{index: index, result: result, reader: data, name: name, opts: opts}:
+		case <-workerCtx.Done():
+			data.Close()
+			break read
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	// 调用方取消
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// spoolEntry 读取压缩包中的文件，小文件保存在内存中，大文件写入 dir 下的临时文件，关闭时删除
+func spoolEntry(reader io.Reader, dir string) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, reader, spoolMemLimit); err == io.EOF {
+		return io.NopCloser(&buf), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(dir, "entry_*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	spooled := &spooledFile{File: file}
+	if _, err = io.Copy(file, io.MultiReader(&buf, reader)); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	return spooled, nil
+}
+
+// spooledFile 关闭时删除的临时文件
+type spooledFile struct {
+	*os.File
+}
+
+func (f *spooledFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
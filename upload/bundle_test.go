@@ -0,0 +1,226 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuominmin/biz/pagination"
+)
+
+// failingStorage 对匹配的 key 返回上传失败
+type failingStorage struct {
+	*memStorage
+	fail func(key string) bool
+}
+
+func (f *failingStorage) Put(key string, reader io.Reader, opts PutOptions) error {
+	if f.fail(key) {
+		return errors.New("rejected by storage")
+	}
+	return f.memStorage.Put(key, reader, opts)
+}
+
+func TestExtractRollback(t *testing.T) {
+	mem := newMemStorage()
+	storage := &failingStorage{memStorage: mem, fail: func(key string) bool { return strings.HasSuffix(key, ".tga") }}
+	repository := NewMemoryFileRepository()
+	svc, err := New("", "models", WithStorage(storage), WithFileRepository(repository),
+		WithQuota(NewMemoryQuotaStore(), StaticQuota(Quota{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := writeZip(t,
+		zipEntry{name: "model.obj", data: []byte("v 0 0 0")},
+		zipEntry{name: "textures/wood.png", data: []byte("png")},
+		zipEntry{name: "textures/a.tga", data: []byte("tga")},
+		zipEntry{name: "textures/b.tga", data: []byte("tga")},
+	)
+	_, _, err = svc.ExtractAndSaveModel3D(archive, WithOwner("1"))
+	var bundleErr *BundleError
+	if !errors.As(err, &bundleErr) {
+		t.Fatalf("expected BundleError, got %v", err)
+	}
+	if len(bundleErr.Entries) != 2 || bundleErr.Entries[0].Entry != "textures/a.tga" || bundleErr.Entries[1].Entry != "textures/b.tga" {
+		t.Errorf("unexpected failed entries %+v", bundleErr.Entries)
+	}
+	if bundleErr.Rollback != nil {
+		t.Errorf("unexpected rollback error %v", bundleErr.Rollback)
+	}
+
+	// 已上传的文件、记录和配额用量全部回滚
+	if len(mem.objects) != 0 {
+		t.Errorf("uploaded files should be deleted, got %d objects", len(mem.objects))
+	}
+	if _, total, _ := svc.FindRecords(RecordFilter{}, pagination.PageReq{Page: 1, PageSize: 10}); total != 0 {
+		t.Errorf("records should be deleted, got %d", total)
+	}
+	if usage, _, _ := svc.GetUsage("1"); usage != (Usage{}) {
+		t.Errorf("usage should be released, got %+v", usage)
+	}
+}
+
+func TestExtractNoModel(t *testing.T) {
+	mem := newMemStorage()
+	svc, err := New("", "models", WithStorage(mem))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = svc.ExtractAndSaveModel3D(writeZip(t, zipEntry{name: "wood.png", data: []byte("png")}))
+	var bundleErr *BundleError
+	if !errors.As(err, &bundleErr) || !errors.Is(err, ErrNoModelFound) {
+		t.Fatalf("expected ErrNoModelFound, got %v", err)
+	}
+	if len(mem.objects) != 0 {
+		t.Errorf("uploaded textures should be deleted, got %d objects", len(mem.objects))
+	}
+}
+
+func TestExtractRollbackKeepsSharedObjects(t *testing.T) {
+	mem := newMemStorage()
+	svc, err := New("", "models", WithStorage(mem), WithContentAddressed())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 其他地方已上传的相同内容
+	shared, err := svc.UploadFile(strings.NewReader("png"), "shared.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = svc.ExtractAndSaveModel3D(writeZip(t, zipEntry{name: "wood.png", data: []byte("png")})); !errors.Is(err, ErrNoModelFound) {
+		t.Fatalf("expected ErrNoModelFound, got %v", err)
+	}
+	if _, ok := mem.objects[strings.TrimPrefix(shared, "/")]; !ok {
+		t.Error("content-addressed object uploaded before the bundle should be kept")
+	}
+}
+
+// slowStorage 模拟网络延迟的存储
+type slowStorage struct {
+	*memStorage
+	delay time.Duration
+}
+
+func (s *slowStorage) Put(key string, reader io.Reader, opts PutOptions) error {
+	time.Sleep(s.delay)
+	return s.memStorage.Put(key, reader, opts)
+}
+
+// textureBundle 生成包含一个模型和 n 个贴图的压缩包
+func textureBundle(t testing.TB, n int) string {
+	entries := []zipEntry{{name: "model.obj", data: []byte("v 0 0 0")}}
+	for i := 0; i < n; i++ {
+		entries = append(entries, zipEntry{name: fmt.Sprintf("textures/%03d.png", i), data: randomBytes(4 << 10)})
+	}
+	return writeZip(t, entries...)
+}
+
+func TestExtractConcurrentOrder(t *testing.T) {
+	storage := &slowStorage{memStorage: newMemStorage(), delay: time.Millisecond}
+	svc, err := New("", "models", WithStorage(storage), WithExtractConcurrency(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, textures, err := svc.ExtractAndSaveModel3D(textureBundle(t, 50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(textures) != 50 {
+		t.Fatalf("expected 50 textures, got %d", len(textures))
+	}
+	// 与压缩包中的顺序一致
+	for i, texture := range textures {
+		if want := fmt.Sprintf("%03d.png", i); texture.Source != want {
+			t.Fatalf("texture %d: expected %s, got %s", i, want, texture.Source)
+		}
+	}
+}
+
+func TestExtractCancel(t *testing.T) {
+	mem := newMemStorage()
+	svc, err := New("", "models", WithStorage(&slowStorage{memStorage: mem, delay: 5 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err = svc.ExtractAndSaveModel3DContext(ctx, textureBundle(t, 50))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context deadline exceeded, got %v", err)
+	}
+	if len(mem.objects) != 0 {
+		t.Errorf("uploaded files should be deleted after cancel, got %d objects", len(mem.objects))
+	}
+}
+
+func BenchmarkExtractAndSaveModel3D(b *testing.B) {
+	archive := textureBundle(b, 50)
+	for _, concurrency := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			svc, err := New("", "models", WithStorage(&slowStorage{memStorage: newMemStorage(), delay: time.Millisecond}),
+				WithExtractConcurrency(concurrency))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err = svc.ExtractAndSaveModel3D(archive); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractTextureReport(t *testing.T) {
+	fbx := "; FBX 7.4.0 project file\n" +
+		"Model: \"Texture::wood\", \"Texture\" {\n" +
+		"\tRelativeFilename: \"textures\\\\wood.png\"\n" +
+		"\tFileName: \"C:\\\\art\\\\metal.png\"\n" +
+		"}\n"
+	archive := writeZip(t,
+		zipEntry{name: "model.fbx", data: []byte(fbx)},
+		zipEntry{name: "textures/Wood.png", data: []byte("wood")},
+		zipEntry{name: "textures/stone.png", data: []byte("stone")},
+		zipEntry{name: "readme.txt", data: []byte("readme")},
+	)
+
+	svc, err := New("", "models", WithStorage(newMemStorage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := svc.ExtractModelBundle(context.Background(), archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bundle.ReferencesParsed {
+		t.Fatal("expected texture references to be parsed")
+	}
+	if len(bundle.MissingTextures) != 1 || bundle.MissingTextures[0] != "metal.png" {
+		t.Errorf("unexpected missing textures %v", bundle.MissingTextures)
+	}
+	if len(bundle.UnusedFiles) != 2 || bundle.UnusedFiles[0] != "textures/stone.png" || bundle.UnusedFiles[1] != "readme.txt" {
+		t.Errorf("unexpected unused files %v", bundle.UnusedFiles)
+	}
+	if len(bundle.Textures) != 2 {
+		t.Errorf("unexpected textures %+v", bundle.Textures)
+	}
+
+	// 没有 FBX 时不检查
+	bundle, err = svc.ExtractModelBundle(context.Background(), writeZip(t, zipEntry{name: "model.obj", data: []byte("v 0 0 0")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.ReferencesParsed || bundle.MissingTextures != nil || bundle.UnusedFiles != nil {
+		t.Errorf("unexpected report without FBX %+v", bundle)
+	}
+}
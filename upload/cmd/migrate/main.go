@@ -0,0 +1,148 @@
+// migrate 将文件从一个存储迁移到另一个存储，例如从本地 uploads/ 目录迁移到 OSS：
+//
+//	migrate -src-driver local -src-root . \
+//		-dst-driver oss -dst-endpoint oss-cn-hangzhou.aliyuncs.com -dst-key id -dst-secret secret -dst-bucket bucket \
+//		-prefix uploads/ -checkpoint migrate.json -verify
+//
+// 中断后使用相同的参数重新运行，从断点继续。加 -dry-run 只统计需要复制的文件。
+// -rewrite 从标准输入逐行读取旧的访问地址，输出新的访问地址，用于更新业务表中保存的地址
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/nuominmin/biz/upload"
+)
+
+// supportedDrivers 命令行参数可以配置的存储驱动
+var supportedDrivers = []string{upload.DriverLocal, upload.DriverOss, upload.DriverS3}
+
+// backendFlags 一端存储的配置
+type backendFlags struct {
+	driver   string
+	root     string
+	endpoint string
+	region   string
+	key      string
+	secret   string
+	bucket   string
+	host     string
+}
+
+func newBackendFlags(fs *flag.FlagSet, prefix, usage string) *backendFlags {
+	b := &backendFlags{}
+	fs.StringVar(&b.driver, prefix+"-driver", upload.DriverLocal, usage+"存储驱动，可选 "+strings.Join(supportedDrivers, "、"))
+	fs.StringVar(&b.root, prefix+"-root", "", usage+"本地存储的根目录")
+	fs.StringVar(&b.endpoint, prefix+"-endpoint", "", usage+"对象存储的 endpoint")
+	fs.StringVar(&b.region, prefix+"-region", "", usage+"S3 的 region")
+	fs.StringVar(&b.key, prefix+"-key", "", usage+"对象存储的 access key id")
+	fs.StringVar(&b.secret, prefix+"-secret", "", usage+"对象存储的 access key secret")
+	fs.StringVar(&b.bucket, prefix+"-bucket", "", usage+"对象存储的 bucket")
+	fs.StringVar(&b.host, prefix+"-host", "", usage+"文件访问地址的域名，例如 https://cdn.example.com")
+	return b
+}
+
+// service 根据配置创建上传服务
+func (b *backendFlags) service() (upload.Service, error) {
+	optFns := []upload.Option{upload.WithDriver(b.driver)}
+	switch b.driver {
+	case upload.DriverLocal:
+		if b.root != "" {
+			optFns = append(optFns, upload.WithDriverParam(upload.ParamRoot, b.root))
+		}
+	case upload.DriverS3:
+		optFns = append(optFns, upload.WithS3Config(b.endpoint, b.region, b.key, b.secret, b.bucket))
+	case upload.DriverOss:
+		optFns = append(optFns, upload.WithOssConfig(b.endpoint, b.key, b.secret, b.bucket, b.host))
+	default:
+		return nil, fmt.Errorf("不支持的存储驱动 %q，可选 %s", b.driver, strings.Join(supportedDrivers, "、"))
+	}
+	return upload.New(b.host, "", optFns...)
+}
+
+func main() {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	src := newBackendFlags(fs, "src", "源")
+	dst := newBackendFlags(fs, "dst", "目标")
+	var opts upload.MigrateOptions
+	fs.StringVar(&opts.Prefix, "prefix", "", "只迁移 key 以此开头的文件，例如 uploads/")
+	fs.IntVar(&opts.Concurrency, "concurrency", 4, "并发复制的文件数")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "只统计需要复制的文件，不写入")
+	fs.BoolVar(&opts.Verify, "verify", false, "复制后校验 SHA-256")
+	fs.StringVar(&opts.Checkpoint, "checkpoint", "", "断点文件，中断后从断点继续")
+	rewrite := fs.Bool("rewrite", false, "从标准输入读取旧的访问地址，输出新的访问地址")
+	verbose := fs.Bool("v", false, "输出每个文件的处理结果")
+	fs.Parse(os.Args[1:])
+
+	if *rewrite {
+		rewriteURLs(src.host, dst.host)
+		return
+	}
+
+	srcSvc, err := src.service()
+	if err != nil {
+		fatalf("创建源存储失败: %v", err)
+	}
+	dstSvc, err := dst.service()
+	if err != nil {
+		fatalf("创建目标存储失败: %v", err)
+	}
+
+	opts.Progress = func(key string, copied bool, err error) {
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "失败 %s: %v\n", key, err)
+		case *verbose && copied:
+			fmt.Println("复制", key)
+		case *verbose:
+			fmt.Println("跳过", key)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report, err := upload.Migrate(ctx, srcSvc, dstSvc, opts)
+	if report != nil {
+		action := "复制"
+		if opts.DryRun {
+			action = "需要复制"
+		}
+		fmt.Printf("%s %d 个文件（%d 字节），跳过 %d 个，失败 %d 个\n",
+			action, report.Copied, report.Bytes, report.Skipped, len(report.Errors))
+	}
+	if err != nil {
+		fatalf("迁移中断: %v", err)
+	}
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+// rewriteURLs 逐行将旧域名的访问地址改为新域名，其他域名的地址原样输出
+func rewriteURLs(oldHost, newHost string) {
+	// 只需要地址转换，不访问存储
+	svc, err := upload.New("", "")
+	if err != nil {
+		fatalf("创建上传服务失败: %v", err)
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		fmt.Println(svc.AddDomainToURL(newHost, svc.RemoveDomainFromURL(oldHost, line)))
+	}
+	if err = scanner.Err(); err != nil {
+		fatalf("读取标准输入失败: %v", err)
+	}
+}
+
+func fatalf(format string, a ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	os.Exit(1)
+}
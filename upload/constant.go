@@ -3,6 +3,16 @@ package upload
 const (
 	// 默认上传路径
 	DefaultUploadDir = "uploads"
+	// 系统临时目录中临时文件的前缀，清理时按前缀匹配
+	TempPrefix = "biz_upload_"
+)
+
+// Content-Disposition 类型，见 WithContentDisposition
+const (
+	// 浏览器内联显示
+	DispositionInline = "inline"
+	// 浏览器下载
+	DispositionAttachment = "attachment"
 )
 
 var (
@@ -44,6 +54,21 @@ var (
 	// 压缩文件格式
 	ZipTypes = []string{
 		".zip",
+		".tar",
+		".gz",
+		".tgz",
+		".zst",
+		".tzst",
+	}
+
+	// 模型压缩包格式
+	ModelArchiveTypes = []string{
+		".zip",
+		".tar",
+		".tar.gz",
+		".tgz",
+		".tar.zst",
+		".tzst",
 	}
 
 	// 导入文件格式
@@ -0,0 +1,69 @@
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// uploadContentAddressed 按内容寻址上传：边写临时文件边计算 SHA-256，
+// 以哈希作为文件名，相同内容已存在时跳过写入，返回对象的 key 以及是否新写入了对象
+func (s *service) uploadContentAddressed(reader io.Reader, name string, header []byte, opts uploadOptions) (string, bool, error) {
+	tempFile, sum, err := spoolTemp(reader)
+	if err != nil {
+		return "", false, err
+	}
+	defer removeTemp(tempFile)
+
+	return s.putContentAddressed(tempFile, sum, name, header, opts)
+}
+
+// putContentAddressed 以哈希 sum 命名写入内容，相同内容已存在时跳过写入
+func (s *service) putContentAddressed(reader io.ReadSeeker, sum, name string, header []byte, opts uploadOptions) (string, bool, error) {
+	key := s.joinPath(s.dir, s.contentAddressedName(sum, name))
+
+	// 已存在相同内容
+	if _, err := s.storage.Stat(key); err == nil {
+		return key, false, nil
+	}
+
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return "", false, fmt.Errorf("读取临时文件失败: %w", err)
+	}
+	if err := s.storage.Put(key, reader, s.putOptions(key, header, opts)); err != nil {
+		return "", false, err
+	}
+
+	return key, true, nil
+}
+
+// spoolTemp 把内容写入临时文件并计算 SHA-256，调用方通过 removeTemp 删除临时文件
+func spoolTemp(reader io.Reader) (*os.File, string, error) {
+	tempFile, err := os.CreateTemp("", TempPrefix+"*")
+	if err != nil {
+		return nil, "", fmt.Errorf("创建临时文件失败: %w", err)
+	}
+
+	hasher := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(tempFile, hasher), reader); err != nil {
+		removeTemp(tempFile)
+		return nil, "", fmt.Errorf("复制文件内容失败: %w", err)
+	}
+	return tempFile, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// removeTemp 关闭并删除临时文件
+func removeTemp(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
+
+// contentAddressedName 由哈希和原文件扩展名组成文件名，保留 name 中的子目录
+func (s *service) contentAddressedName(sum, name string) string {
+	dir := filepath.ToSlash(filepath.Dir(name))
+	return s.joinPath(strings.TrimPrefix(dir, "."), sum+strings.ToLower(filepath.Ext(name)))
+}
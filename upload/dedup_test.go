@@ -0,0 +1,167 @@
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuominmin/biz/pagination"
+)
+
+func TestContentAddressedUpload(t *testing.T) {
+	mem := newMemStorage()
+	svc, err := New("http://cdn.example.com", "textures", WithStorage(mem), WithContentAddressed())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := svc.UploadFile(strings.NewReader("wood"), svc.GenerateUniqueFilename("diffuse.PNG"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := svc.UploadFile(strings.NewReader("wood"), svc.GenerateUniqueFilename("copy.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte("wood"))
+	expected := "http://cdn.example.com/textures/" + hex.EncodeToString(sum[:]) + ".png"
+	if first != expected || second != expected {
+		t.Errorf("urls = %s, %s, want %s", first, second, expected)
+	}
+	if len(mem.objects) != 1 {
+		t.Errorf("expected 1 stored object, got %d", len(mem.objects))
+	}
+
+	third, err := svc.UploadFile(strings.NewReader("stone"), "stone.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third == first || len(mem.objects) != 2 {
+		t.Errorf("different content should be stored separately")
+	}
+}
+
+func TestContentAddressedRefs(t *testing.T) {
+	mem := newMemStorage()
+	quotas := NewMemoryQuotaStore()
+	svc, err := New("", "textures", WithStorage(mem), WithContentAddressed(),
+		WithFileRepository(NewMemoryFileRepository()), WithQuota(quotas, StaticQuota(Quota{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileURL, err := svc.UploadFile(strings.NewReader("wood"), "a.png", WithOwner("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("wood"), "b.png", WithOwner("bob")); err != nil {
+		t.Fatal(err)
+	}
+	// 同一上传者重复上传不增加引用
+	if _, err = svc.UploadFile(strings.NewReader("wood"), "c.png", WithOwner("bob")); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := svc.GetRecord(fileURL)
+	if err != nil || record.Owner != "alice" || record.RefCount() != 2 {
+		t.Fatalf("record = %+v, %v", record, err)
+	}
+	for _, owner := range []string{"alice", "bob"} {
+		if usage, _, _ := svc.GetUsage(owner); usage.Files != 1 || usage.Bytes != 4 {
+			t.Errorf("%s usage = %+v", owner, usage)
+		}
+	}
+
+	// 多个引用时必须指定上传者，其他人不能删除
+	if err = svc.DeleteFile(fileURL); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expected invalid argument without owner, got %v", err)
+	}
+	if err = svc.DeleteFile(fileURL, WithOwner("mallory")); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected permission denied for other owner, got %v", err)
+	}
+
+	// 第一个上传者删除后对象保留，由下一个引用接替
+	if err = svc.DeleteFile(fileURL, WithOwner("alice")); err != nil {
+		t.Fatal(err)
+	}
+	if len(mem.objects) != 1 {
+		t.Fatal("shared object should be kept")
+	}
+	if record, _ = svc.GetRecord(fileURL); record.Owner != "bob" || record.RefCount() != 1 {
+		t.Errorf("record after delete = %+v", record)
+	}
+	if usage, _, _ := svc.GetUsage("alice"); usage.Files != 0 {
+		t.Errorf("alice usage should be released, got %+v", usage)
+	}
+
+	if err = svc.DeleteFile(fileURL, WithOwner("bob")); err != nil {
+		t.Fatal(err)
+	}
+	if len(mem.objects) != 0 {
+		t.Error("object should be deleted with the last reference")
+	}
+	if _, err = svc.GetRecord(fileURL); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("record should be deleted, got %v", err)
+	}
+}
+
+func TestContentAddressedSharedRecord(t *testing.T) {
+	mem := newMemStorage()
+	svc, err := New("", "textures", WithStorage(mem), WithContentAddressed(), WithFileRepository(NewMemoryFileRepository()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileURL, err := svc.UploadFile(strings.NewReader("wood"), "a.png", WithOwner("alice"), WithOriginalName("a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 其他上传者不能修改共享对象的访问权限
+	if _, err = svc.UploadFile(strings.NewReader("wood"), "b.png", WithOwner("bob"), WithACL(ACLPrivate)); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expected invalid argument for acl change, got %v", err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("wood"), "b.png", WithOwner("bob"), WithOriginalName("b.png"),
+		WithTTL(time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一个上传者的记录不被覆盖
+	record, err := svc.GetRecord(fileURL)
+	if err != nil || record.Owner != "alice" || record.OriginalName != "a.png" || !record.ExpiresAt.IsZero() || record.ACL != "" {
+		t.Fatalf("record = %+v, %v", record, err)
+	}
+	if records, total, _ := svc.FindRecords(RecordFilter{Owner: "bob"}, pagination.PageReq{Page: 1, PageSize: 10}); total != 1 || records[0].Key != record.Key {
+		t.Errorf("bob should find the shared record, got %d", total)
+	}
+
+	// 只移除过期的引用
+	time.Sleep(5 * time.Millisecond)
+	if n, err := svc.Sweep(); err != nil || n != 1 {
+		t.Fatalf("sweep = %d, %v", n, err)
+	}
+	if record, err = svc.GetRecord(fileURL); err != nil || record.Owner != "alice" || record.RefCount() != 1 {
+		t.Errorf("record after sweep = %+v, %v", record, err)
+	}
+	if len(mem.objects) != 1 {
+		t.Error("shared object should be kept")
+	}
+}
+
+func TestContentAddressedDeleteRequiresRepository(t *testing.T) {
+	mem := newMemStorage()
+	svc, err := New("", "textures", WithStorage(mem), WithContentAddressed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileURL, err := svc.UploadFile(strings.NewReader("wood"), "a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = svc.DeleteFile(fileURL); !errors.Is(err, ErrInvalidArgument) || len(mem.objects) != 1 {
+		t.Errorf("expected delete to be refused without repository, got %v", err)
+	}
+}
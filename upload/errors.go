@@ -0,0 +1,120 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// 错误分类，存储驱动和服务返回的错误可以通过 errors.Is 判断属于哪一类，
+// 例如 errors.Is(err, ErrNotFound)。每一类都实现了 HTTPStatus() int，
+// 可以通过 errors.As 获取对应的 HTTP 状态码，例如 ErrQuotaExceeded 为 413，
+// 以及分类名称 Kind() string，例如 quota_exceeded
+var (
+	ErrNotFound           error = &errorKind{"not_found", "not found", http.StatusNotFound}
+	ErrAlreadyExists      error = &errorKind{"already_exists", "already exists", http.StatusConflict}
+	ErrPermissionDenied   error = &errorKind{"permission_denied", "permission denied", http.StatusForbidden}
+	ErrQuotaExceeded      error = &errorKind{"quota_exceeded", "storage quota exceeded", http.StatusRequestEntityTooLarge}
+	ErrInvalidArgument    error = &errorKind{"invalid_argument", "invalid argument", http.StatusBadRequest}
+	ErrBackendUnavailable error = &errorKind{"backend_unavailable", "storage backend unavailable", http.StatusServiceUnavailable}
+)
+
+// errorKind 错误分类
+type errorKind struct {
+	name   string
+	msg    string
+	status int
+}
+
+func (k *errorKind) Error() string {
+	return k.msg
+}
+
+// HTTPStatus 该类错误对应的 HTTP 状态码
+func (k *errorKind) HTTPStatus() int {
+	return k.status
+}
+
+// Kind 分类名称，不同分类的状态码可能相同，其他包可以不依赖 upload 区分分类
+func (k *errorKind) Kind() string {
+	return k.name
+}
+
+// kindError 属于某一类错误的哨兵错误，errors.Is 可以同时匹配自身和所属的分类
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func newKindError(kind error, msg string) error {
+	return &kindError{msg: msg, kind: kind}
+}
+
+// kindErrorf 按格式生成属于 kind 分类的错误
+func kindErrorf(kind error, format string, a ...any) error {
+	return newKindError(kind, fmt.Sprintf(format, a...))
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+// StorageError 存储驱动返回的错误，保留驱动原有的错误信息，Kind 为错误分类
+type StorageError struct {
+	// 操作，例如 upload、download、stat
+	Op  string
+	Key string
+	// 错误分类，例如 ErrNotFound，无法分类时为空
+	Kind error
+	Err  error
+}
+
+func (e *StorageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StorageError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// errorKinds 所有的错误分类
+var errorKinds = []error{ErrNotFound, ErrAlreadyExists, ErrPermissionDenied, ErrQuotaExceeded, ErrInvalidArgument, ErrBackendUnavailable}
+
+// kindOf 返回错误所属的分类，无法分类时返回 nil
+func kindOf(err error) error {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return nil
+}
+
+// storageError 将驱动的错误包装为 StorageError
+func storageError(op, key string, kind, err error) error {
+	return &StorageError{Op: op, Key: key, Kind: kind, Err: err}
+}
+
+// statusKind 根据对象存储响应的状态码推断错误分类
+func statusKind(statusCode int) error {
+	switch {
+	case statusCode == http.StatusNotFound:
+		return ErrNotFound
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrPermissionDenied
+	case statusCode == http.StatusConflict || statusCode == http.StatusPreconditionFailed:
+		return ErrAlreadyExists
+	case statusCode == http.StatusBadRequest:
+		return ErrInvalidArgument
+	case statusCode == http.StatusTooManyRequests || statusCode >= 500:
+		return ErrBackendUnavailable
+	}
+	return nil
+}
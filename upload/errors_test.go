@@ -0,0 +1,84 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	local, err := New("http://127.0.0.1:3000", "goods", WithDriverParam(ParamRoot, t.TempDir()),
+		WithFileRepository(NewMemoryFileRepository()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, server := newFakeS3("assets")
+	defer server.Close()
+	s3, err := NewS3Service("http://cdn.example.com", "goods",
+		WithS3Config(server.URL, "", testS3AccessKeyId, testS3AccessKeySecret, "assets"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	badSecret, err := NewS3Service("http://cdn.example.com", "goods",
+		WithS3Config(server.URL, "", testS3AccessKeyId, "wrong-secret", "assets"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, localOpenErr := local.OpenFile("uploads/goods/missing.txt")
+	_, s3StatErr := s3.(*service).storage.Stat("goods/missing.txt")
+	_, s3DownloadErr := s3.DownloadFile("goods/missing.txt")
+	_, badSecretErr := badSecret.UploadFile(strings.NewReader("x"), "a.txt")
+	_, mismatchErr := local.CheckContent(strings.NewReader("not a png"), "a.png", 9)
+	_, recordErr := local.GetRecord("uploads/goods/missing.txt")
+	_, copyErr := local.Copy("uploads/goods/a.txt", "uploads/goods/a.txt")
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{"local open", localOpenErr, ErrNotFound},
+		{"local delete", local.DeleteFile("uploads/goods/missing.txt"), ErrNotFound},
+		{"s3 stat", s3StatErr, ErrNotFound},
+		{"s3 download", s3DownloadErr, ErrNotFound},
+		{"s3 bad secret", badSecretErr, ErrBackendUnavailable},
+		{"content mismatch", mismatchErr, ErrInvalidArgument},
+		{"record", recordErr, ErrNotFound},
+		{"signed url", local.VerifySignedURL("uploads/goods/a.txt", nil), ErrPermissionDenied},
+		{"copy to itself", copyErr, ErrInvalidArgument},
+	} {
+		if !errors.Is(tc.err, tc.kind) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.kind, tc.err)
+		}
+		// 每个错误只属于一类
+		if kind := kindOf(tc.err); kind != tc.kind {
+			t.Errorf("%s: classified as %v", tc.name, kind)
+		}
+	}
+
+	// 保留驱动原有的错误信息
+	var storageErr *StorageError
+	if !errors.As(s3DownloadErr, &storageErr) || storageErr.Key != "goods/missing.txt" ||
+		!strings.Contains(s3DownloadErr.Error(), "does not exist") {
+		t.Errorf("unexpected storage error: %v", s3DownloadErr)
+	}
+}
+
+func TestErrorHTTPStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		status int
+	}{
+		{storageError("stat", "a.txt", ErrNotFound, errors.New("missing")), 404},
+		{fmt.Errorf("upload: %w", kindErrorf(ErrQuotaExceeded, "over limit")), 413},
+		{&ArchiveError{Entry: "../a", Err: ErrArchiveUnsafePath}, 400},
+		{ErrAccessDenied, 403},
+	} {
+		var statusErr interface{ HTTPStatus() int }
+		if !errors.As(tc.err, &statusErr) || statusErr.HTTPStatus() != tc.status {
+			t.Errorf("%v: expected status %d", tc.err, tc.status)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/nuominmin/biz/pagination"
+)
+
+const (
+	// 系统临时目录中的临时文件超过该时间视为残留
+	staleTempAge = 24 * time.Hour
+	// 旧版本 ExtractAndSaveModel3D 使用的临时目录
+	legacyTempDir = "./temp"
+)
+
+// CommitFile 将临时文件转为永久文件，设置 WithOwner 时只能提交该上传者的文件，
+// 内容寻址时只提交该上传者的引用
+func (s *service) CommitFile(fileURL string, opts ...UploadOption) error {
+	if s.opts.repository == nil {
+		return ErrRecordNotFound
+	}
+
+	options := newUploadOptions("", opts...)
+	record, err := s.opts.repository.Get(s.keyFromURL(fileURL))
+	if err != nil {
+		return err
+	}
+	owner := record.Owner
+	if options.owner != "" {
+		if !record.hasRef(options.owner) {
+			return kindErrorf(ErrPermissionDenied, "文件不属于上传者 %s: %s", options.owner, record.Key)
+		}
+		owner = options.owner
+	}
+
+	expiresAt := record.expiresAt(owner)
+	if expiresAt.IsZero() {
+		return nil
+	}
+	if time.Now().After(expiresAt) {
+		return kindErrorf(ErrInvalidArgument, "临时文件已过期: %s", record.Key)
+	}
+
+	record.setExpiresAt(owner, time.Time{})
+	return s.opts.repository.Save(record)
+}
+
+// Sweep 删除已过期的临时文件并清理残留的临时文件，返回删除的临时文件数量
+func (s *service) Sweep() (int, error) {
+	now := time.Now()
+	cleanTempFiles(now, s.opts.tempDirs...)
+	if o, ok := s.storage.(*ossStorage); ok {
+		o.removeStaleCheckpoints(s.dirPrefix(), now.Add(-staleTempAge))
+	}
+
+	if s.opts.repository == nil {
+		return 0, nil
+	}
+
+	// 先收集再删除，避免删除过程中分页错位
+	filter := RecordFilter{ExpiredBefore: time.Now()}
+	var expired []FileRecord
+	for page := uint(1); ; page++ {
+		records, total, err := s.opts.repository.Find(filter, pagination.PageReq{Page: page, PageSize: 500})
+		if err != nil {
+			return 0, fmt.Errorf("查询过期文件失败: %w", err)
+		}
+		expired = append(expired, records...)
+		if len(records) == 0 || int64(len(expired)) >= total {
+			break
+		}
+	}
+
+	var errs []error
+	deleted := 0
+	for i := range expired {
+		if err := s.deleteExpired(&expired[i]); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// deleteExpired 删除过期文件，对象已不存在时只删除记录
+func (s *service) deleteExpired(record *FileRecord) error {
+	// 内容寻址的对象被多个上传者引用时只移除过期的引用
+	var err error
+	for _, owner := range record.expiredOwners(time.Now()) {
+		if err = s.deleteFile(record.Key, owner, true); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	if _, statErr := s.storage.Stat(record.Key); statErr == nil {
+		return err
+	}
+
+	s.deleteDerivatives(record.Key)
+	if err = s.deleteRecord(record.Key); err != nil {
+		return err
+	}
+	return s.releaseQuota(record)
+}
+
+// StartSweeper 在后台定期调用 Sweep，ctx 取消时退出
+func (s *service) StartSweeper(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if n, err := s.Sweep(); err != nil {
+					log.Warnf("upload: sweep expired files: deleted %d, error: %v", n, err)
+				}
+			}
+		}
+	}()
+}
+
+// cleanTempFiles 清理进程异常退出等原因在系统临时目录中残留超过 staleTempAge 的临时文件、
+// dirs 目录中超过各自 maxAge 的文件，以及旧版本残留的 ./temp 目录
+func cleanTempFiles(now time.Time, dirs ...tempDir) {
+	matches, _ := filepath.Glob(filepath.Join(os.TempDir(), TempPrefix+"*"))
+	removeBefore(matches, now.Add(-staleTempAge))
+	for _, dir := range dirs {
+		entries, _ := os.ReadDir(dir.dir)
+		files := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(dir.dir, entry.Name()))
+			}
+		}
+		removeBefore(files, now.Add(-dir.maxAge))
+	}
+
+	os.Remove(filepath.Join(legacyTempDir, "temp_model.fbx"))
+	// 目录不为空时删除失败，不影响其他文件
+	os.Remove(legacyTempDir)
+}
+
+// removeBefore 删除修改时间早于 before 的文件
+func removeBefore(files []string, before time.Time) {
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil && info.ModTime().Before(before) {
+			os.RemoveAll(file)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package upload
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemporaryUploads(t *testing.T) {
+	mem := newMemStorage()
+	svc, err := New("http://cdn.example.com", "drafts", WithStorage(mem), WithFileRepository(NewMemoryFileRepository()),
+		WithQuota(NewMemoryQuotaStore(), StaticQuota(Quota{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	draft, err := svc.UploadFile(strings.NewReader("draft"), "draft.txt", WithOwner("1"), WithTTL(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, err := svc.UploadFile(strings.NewReader("committed"), "committed.txt", WithOwner("1"), WithTTL(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("permanent"), "permanent.txt", WithOwner("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err = svc.CommitFile(committed); err != nil {
+		t.Fatal(err)
+	}
+
+	// 其他上传者不能提交
+	if err = svc.CommitFile(draft, WithOwner("2")); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected permission denied for other owner, got %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if err = svc.CommitFile(draft, WithOwner("1")); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("committing an expired file should fail with invalid argument, got %v", err)
+	}
+
+	deleted, err := svc.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected 1 deleted file, got %d", deleted)
+	}
+	if _, ok := mem.objects["drafts/draft.txt"]; ok {
+		t.Error("expired draft should be deleted")
+	}
+	if len(mem.objects) != 2 {
+		t.Errorf("expected 2 remaining objects, got %d", len(mem.objects))
+	}
+	if _, err = svc.GetRecord(draft); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expired record should be deleted, got %v", err)
+	}
+	if usage, _, _ := svc.GetUsage("1"); usage.Files != 2 {
+		t.Errorf("quota should be released for the expired file, usage %+v", usage)
+	}
+}
+
+func TestTemporaryUploadRequiresRepository(t *testing.T) {
+	svc, err := New("", "drafts", WithStorage(newMemStorage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("draft"), "draft.txt", WithTTL(time.Hour)); err == nil {
+		t.Error("expected error without file repository")
+	}
+}
+
+func TestSweepTempFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll(legacyTempDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(legacyTempDir, "temp_model.fbx"), []byte("fbx"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := os.CreateTemp("", TempPrefix+"*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.Close()
+	old := time.Now().Add(-2 * staleTempAge)
+	if err = os.Chtimes(stale.Name(), old, old); err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := os.CreateTemp("", TempPrefix+"*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh.Close()
+	defer os.Remove(fresh.Name())
+
+	// WithTempDir 设置的目录按各自的有效期清理
+	cacheDir, keepDir := t.TempDir(), t.TempDir()
+	staleUpload, keptUpload := filepath.Join(cacheDir, "abc.bin"), filepath.Join(keepDir, "abc.bin")
+	for _, file := range []string{staleUpload, keptUpload} {
+		if err = os.WriteFile(file, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(file, old, old)
+	}
+
+	svc, err := New("", "drafts", WithStorage(newMemStorage()),
+		WithTempDir(cacheDir, staleTempAge), WithTempDir(keepDir, 3*staleTempAge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(stale.Name()); !os.IsNotExist(err) {
+		t.Error("stale temp file should be removed")
+	}
+	if _, err = os.Stat(staleUpload); !os.IsNotExist(err) {
+		t.Error("stale file in temp dirs should be removed")
+	}
+	if _, err = os.Stat(keptUpload); err != nil {
+		t.Error("file within the directory's max age should be kept")
+	}
+	if _, err = os.Stat(fresh.Name()); err != nil {
+		t.Error("fresh temp file should be kept")
+	}
+	if _, err = os.Stat(legacyTempDir); !os.IsNotExist(err) {
+		t.Error("legacy temp directory should be removed")
+	}
+}
@@ -0,0 +1,394 @@
+package upload
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	// 注册解码器
+	_ "image/gif"
+
+	"github.com/go-kratos/kratos/v2/log"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// 衍生图缩放模式
+const (
+	// ResizeFit 等比缩放到框内，不放大
+	ResizeFit = "fit"
+	// ResizeFill 等比缩放铺满后居中裁剪，输出尺寸与设置一致
+	ResizeFill = "fill"
+)
+
+// 衍生图编码格式
+const (
+	ImageFormatJPEG = "jpeg"
+	ImageFormatPNG  = "png"
+	// ImageFormatWebP 需要先通过 RegisterImageEncoder 注册编码器
+	ImageFormatWebP = "webp"
+)
+
+const (
+	// 默认编码质量
+	defaultImageQuality = 85
+	// 生成衍生图时允许的最大像素数，防止解压炸弹
+	maxImagePixels = 100_000_000
+)
+
+// ImageVariant 衍生图规格
+type ImageVariant struct {
+	// 名称，例如 thumb，作为文件名后缀
+	Name string
+	// 宽高，fit 模式下为 0 时按另一边等比缩放
+	Width  int
+	Height int
+	// 缩放模式，默认 ResizeFit
+	Mode string
+	// 编码质量 1-100，默认 85，png 忽略
+	Quality int
+	// 编码格式，为空时 png、gif 输出 png，其他输出 jpeg
+	Format string
+}
+
+// DefaultImageVariants 常用的缩略图、中图和大图
+var DefaultImageVariants = []ImageVariant{
+	{Name: "thumb", Width: 200, Height: 200, Mode: ResizeFill, Quality: 80},
+	{Name: "medium", Width: 800, Height: 800, Mode: ResizeFit},
+	{Name: "large", Width: 1600, Height: 1600, Mode: ResizeFit},
+}
+
+// ImageEncoder 图片编码器，quality 为 1-100
+type ImageEncoder func(w io.Writer, img image.Image, quality int) error
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = make(map[string]ImageEncoder)
+)
+
+func init() {
+	RegisterImageEncoder(ImageFormatJPEG, func(w io.Writer, img image.Image, quality int) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	})
+	RegisterImageEncoder(ImageFormatPNG, func(w io.Writer, img image.Image, _ int) error {
+		return png.Encode(w, img)
+	})
+}
+
+// RegisterImageEncoder 注册衍生图编码器，例如使用 github.com/chai2010/webp 注册 webp：
+//
+//	upload.RegisterImageEncoder(upload.ImageFormatWebP, func(w io.Writer, img image.Image, quality int) error {
+//		return webp.Encode(w, img, &webp.Options{Quality: float32(quality)})
+//	})
+//
+// 重复注册或 encoder 为 nil 时 panic
+func RegisterImageEncoder(format string, encoder ImageEncoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	if encoder == nil {
+		panic("upload: RegisterImageEncoder encoder is nil")
+	}
+	if _, dup := encoders[format]; dup {
+		panic("upload: RegisterImageEncoder called twice for format " + format)
+	}
+	encoders[format] = encoder
+}
+
+func imageEncoder(format string) (ImageEncoder, bool) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	encoder, ok := encoders[format]
+	return encoder, ok
+}
+
+// validateImageVariants 检查衍生图规格
+func validateImageVariants(variants []ImageVariant) error {
+	names := make(map[string]bool, len(variants))
+	for _, v := range variants {
+		if v.Name == "" || strings.ContainsAny(v.Name, "/\\.") {
+			return fmt.Errorf("衍生图名称无效: '%s'", v.Name)
+		}
+		if names[v.Name] {
+			return fmt.Errorf("衍生图名称重复: %s", v.Name)
+		}
+		names[v.Name] = true
+
+		if v.Width < 0 || v.Height < 0 || (v.Width == 0 && v.Height == 0) {
+			return fmt.Errorf("衍生图 %s 尺寸无效: %dx%d", v.Name, v.Width, v.Height)
+		}
+		if v.Mode != "" && v.Mode != ResizeFit && v.Mode != ResizeFill {
+			return fmt.Errorf("衍生图 %s 缩放模式无效: %s", v.Name, v.Mode)
+		}
+		if v.Quality < 0 || v.Quality > 100 {
+			return fmt.Errorf("衍生图 %s 质量无效: %d", v.Name, v.Quality)
+		}
+		if v.Format != "" {
+			if _, ok := imageEncoder(v.Format); !ok {
+				return fmt.Errorf("衍生图 %s 的格式 %s 未注册编码器", v.Name, v.Format)
+			}
+		}
+	}
+	return nil
+}
+
+// isDerivable 是否需要为该文件生成衍生图，只处理可以解码的位图格式
+func (s *service) isDerivable(key string) bool {
+	if len(s.opts.imageVariants) == 0 {
+		return false
+	}
+	switch strings.ToLower(path.Ext(key)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp":
+		return true
+	}
+	return false
+}
+
+// derivativeKey 衍生图的存储 key，例如 uploads/abc.jpg 的 thumb 为 uploads/abc_thumb.jpg
+func derivativeKey(key string, v ImageVariant) string {
+	ext := path.Ext(key)
+	return strings.TrimSuffix(key, ext) + "_" + v.Name + variantExt(v, ext)
+}
+
+// variantFormat 衍生图的编码格式
+func variantFormat(v ImageVariant, srcExt string) string {
+	if v.Format != "" {
+		return v.Format
+	}
+	switch strings.ToLower(srcExt) {
+	case ".png", ".gif":
+		return ImageFormatPNG
+	}
+	return ImageFormatJPEG
+}
+
+// variantExt 衍生图的扩展名
+func variantExt(v ImageVariant, srcExt string) string {
+	if format := variantFormat(v, srcExt); format != ImageFormatJPEG {
+		return "." + format
+	}
+	return ".jpg"
+}
+
+// DerivativeURLs 返回文件已保存的衍生图地址，key 为衍生图名称，没有衍生图时返回 nil。
+// 无法解码或尺寸过大的图片、配置衍生图前上传的图片没有衍生图
+func (s *service) DerivativeURLs(fileURL string) map[string]string {
+	key := s.keyFromURL(fileURL)
+	if !s.isDerivable(key) {
+		return nil
+	}
+
+	var urls map[string]string
+	for _, v := range s.opts.imageVariants {
+		dKey := derivativeKey(key, v)
+		if _, err := s.storage.Stat(dKey); err != nil {
+			continue
+		}
+		if urls == nil {
+			urls = make(map[string]string, len(s.opts.imageVariants))
+		}
+		urls[v.Name] = s.fileURL(dKey)
+	}
+	return urls
+}
+
+// derivative 编码后的衍生图
+type derivative struct {
+	key  string
+	data []byte
+}
+
+// makeDerivatives 解码图片并按配置生成衍生图
+func (s *service) makeDerivatives(reader io.ReadSeeker, key string) ([]derivative, error) {
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("读取临时文件失败: %w", err)
+	}
+	config, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		return nil, fmt.Errorf("解析图片失败: %w", err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, fmt.Errorf("图片尺寸过大: %dx%d", config.Width, config.Height)
+	}
+
+	if _, err = reader.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("读取临时文件失败: %w", err)
+	}
+	src, _, err := image.Decode(reader)
+	if err != nil {
+		return nil, fmt.Errorf("解码图片失败: %w", err)
+	}
+
+	derivatives := make([]derivative, 0, len(s.opts.imageVariants))
+	for _, v := range s.opts.imageVariants {
+		format := variantFormat(v, path.Ext(key))
+		encoder, ok := imageEncoder(format)
+		if !ok {
+			return nil, fmt.Errorf("格式 %s 未注册编码器", format)
+		}
+
+		quality := v.Quality
+		if quality == 0 {
+			quality = defaultImageQuality
+		}
+
+		// jpeg 不支持透明通道，需要铺白色背景
+		var buf bytes.Buffer
+		if err = encoder(&buf, resizeImage(src, v, format == ImageFormatJPEG), quality); err != nil {
+			return nil, fmt.Errorf("生成衍生图 %s 失败: %w", v.Name, err)
+		}
+		derivatives = append(derivatives, derivative{key: derivativeKey(key, v), data: buf.Bytes()})
+	}
+	return derivatives, nil
+}
+
+// resizeImage 按规格缩放图片，opaque 为 true 时在白色背景上绘制
+func resizeImage(src image.Image, v ImageVariant, opaque bool) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	srcRect := bounds
+	var dstW, dstH int
+
+	if v.Mode == ResizeFill && v.Width > 0 && v.Height > 0 {
+		// 按目标宽高比居中裁剪
+		dstW, dstH = v.Width, v.Height
+		if srcW*dstH > srcH*dstW {
+			cropW := srcH * dstW / dstH
+			srcRect.Min.X += (srcW - cropW) / 2
+			srcRect.Max.X = srcRect.Min.X + cropW
+		} else {
+			cropH := srcW * dstH / dstW
+			srcRect.Min.Y += (srcH - cropH) / 2
+			srcRect.Max.Y = srcRect.Min.Y + cropH
+		}
+	} else {
+		dstW, dstH = fitSize(srcW, srcH, v.Width, v.Height)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	op := draw.Src
+	if opaque {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		op = draw.Over
+	}
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, srcRect, op, nil)
+	return dst
+}
+
+// fitSize 计算等比缩放到 maxW x maxH 框内的尺寸，为 0 的一边不限制，不放大
+func fitSize(srcW, srcH, maxW, maxH int) (int, int) {
+	scale := 1.0
+	if maxW > 0 && srcW > maxW {
+		scale = float64(maxW) / float64(srcW)
+	}
+	if maxH > 0 && srcH > maxH {
+		if s := float64(maxH) / float64(srcH); s < scale {
+			scale = s
+		}
+	}
+	w, h := int(float64(srcW)*scale+0.5), int(float64(srcH)*scale+0.5)
+	return max(w, 1), max(h, 1)
+}
+
+// uploadImage 上传图片原图和衍生图，返回原图的 key 以及是否新写入了原图。
+// 原图先写入临时文件，解码失败或尺寸过大时只保存原图；衍生图上传失败时删除本次新写入的原图和衍生图
+func (s *service) uploadImage(reader io.Reader, name string, header []byte, opts uploadOptions) (string, bool, error) {
+	tempFile, sum, err := spoolTemp(reader)
+	if err != nil {
+		return "", false, err
+	}
+	defer removeTemp(tempFile)
+
+	key := s.joinPath(s.dir, name)
+	if s.opts.contentAddressed {
+		// 内容寻址的 key 由内容决定，衍生图在确定 key 后再命名
+		key = s.joinPath(s.dir, s.contentAddressedName(sum, name))
+	}
+	derivatives, err := s.makeDerivatives(tempFile, key)
+	if err != nil {
+		log.Warnf("upload: skip derivatives of %s: %v", key, err)
+	}
+
+	created := true
+	if s.opts.contentAddressed {
+		if _, created, err = s.putContentAddressed(tempFile, sum, name, header, opts); err != nil {
+			return "", false, err
+		}
+	} else {
+		if _, err = tempFile.Seek(0, io.SeekStart); err != nil {
+			return "", false, fmt.Errorf("读取临时文件失败: %w", err)
+		}
+		if err = s.storage.Put(key, tempFile, s.putOptions(key, header, opts)); err != nil {
+			return "", false, err
+		}
+	}
+
+	// 内容寻址下已存在的原图和衍生图可能被其他上传引用，不删除
+	if err = s.putDerivatives(derivatives, opts, created); err != nil {
+		if created {
+			s.storage.Delete(key)
+		}
+		return "", false, err
+	}
+	return key, created, nil
+}
+
+// putDerivatives 上传衍生图，失败时 rollback 为 true 则删除本次已上传的衍生图
+func (s *service) putDerivatives(derivatives []derivative, opts uploadOptions, rollback bool) error {
+	// 衍生图的格式可能与原图不同，不使用原图的 Content-Type
+	opts.contentType = ""
+	opts.size = 0
+
+	for i, d := range derivatives {
+		if err := s.storage.Put(d.key, bytes.NewReader(d.data), s.putOptions(d.key, d.data, opts)); err != nil {
+			if rollback {
+				for _, done := range derivatives[:i] {
+					s.storage.Delete(done.key)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// storeDerivatives 为已写入存储的图片生成衍生图，例如浏览器直传的文件。无法解码或尺寸过大时只保留原图
+func (s *service) storeDerivatives(key string, opts uploadOptions) error {
+	if !s.isDerivable(key) {
+		return nil
+	}
+	reader, err := s.storage.Get(key)
+	if err != nil {
+		return err
+	}
+	tempFile, _, err := spoolTemp(reader)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+	defer removeTemp(tempFile)
+
+	derivatives, err := s.makeDerivatives(tempFile, key)
+	if err != nil {
+		log.Warnf("upload: skip derivatives of %s: %v", key, err)
+		return nil
+	}
+	return s.putDerivatives(derivatives, opts, true)
+}
+
+// deleteDerivatives 删除文件的衍生图，配置衍生图前上传的文件没有衍生图，忽略删除错误
+func (s *service) deleteDerivatives(key string) {
+	if !s.isDerivable(key) {
+		return
+	}
+	for _, v := range s.opts.imageVariants {
+		s.storage.Delete(derivativeKey(key, v))
+	}
+}
@@ -0,0 +1,160 @@
+package upload
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func pngBytes(t *testing.T, width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 200})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageVariants(t *testing.T) {
+	mem := newMemStorage()
+	svc, err := New("http://cdn.example.com", "images", WithStorage(mem), WithImageVariants(
+		ImageVariant{Name: "thumb", Width: 100, Height: 100, Mode: ResizeFill},
+		ImageVariant{Name: "medium", Width: 200, Format: ImageFormatJPEG, Quality: 70},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileURL, err := svc.UploadFile(bytes.NewReader(pngBytes(t, 400, 300)), "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	urls := svc.DerivativeURLs(fileURL)
+	expected := map[string]string{
+		"thumb":  "http://cdn.example.com/images/photo_thumb.png",
+		"medium": "http://cdn.example.com/images/photo_medium.jpg",
+	}
+	for name, url := range expected {
+		if urls[name] != url {
+			t.Errorf("derivative %s = %s, want %s", name, urls[name], url)
+		}
+	}
+
+	sizes := map[string][2]int{
+		"images/photo_thumb.png":  {100, 100},
+		"images/photo_medium.jpg": {200, 150},
+	}
+	for key, size := range sizes {
+		config, format, err := image.DecodeConfig(bytes.NewReader(mem.objects[key]))
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		if config.Width != size[0] || config.Height != size[1] {
+			t.Errorf("%s size = %dx%d, want %dx%d", key, config.Width, config.Height, size[0], size[1])
+		}
+		if !strings.HasSuffix(key, "."+strings.Replace(format, "jpeg", "jpg", 1)) {
+			t.Errorf("%s encoded as %s", key, format)
+		}
+	}
+
+	if err = svc.DeleteFile("images/photo.png"); err != nil {
+		t.Fatal(err)
+	}
+	if len(mem.objects) != 0 {
+		t.Errorf("derivatives should be deleted with the original, left %d objects", len(mem.objects))
+	}
+}
+
+func TestImageVariantsSkipNonImage(t *testing.T) {
+	mem := newMemStorage()
+	svc, err := New("http://cdn.example.com", "images", WithStorage(mem), WithImageVariants(DefaultImageVariants...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileURL, err := svc.UploadFile(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), "icon.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if urls := svc.DerivativeURLs(fileURL); urls != nil {
+		t.Errorf("svg should not have derivatives: %v", urls)
+	}
+
+	// 无法解码的图片只保存原图
+	if _, err = svc.UploadFile(strings.NewReader("not an image"), "broken.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if len(mem.objects) != 2 || mem.objects["images/broken.jpg"] == nil {
+		t.Errorf("expected the svg and the broken original to be stored, got %d objects", len(mem.objects))
+	}
+	if urls := svc.DerivativeURLs("http://cdn.example.com/images/broken.jpg"); urls != nil {
+		t.Errorf("broken image should not have derivatives: %v", urls)
+	}
+
+	// 配置衍生图前上传的图片没有衍生图
+	plain, err := New("http://cdn.example.com", "images", WithStorage(mem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileURL, err = plain.UploadFile(bytes.NewReader(pngBytes(t, 40, 20)), "old.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if urls := svc.DerivativeURLs(fileURL); urls != nil {
+		t.Errorf("image uploaded without variants should not have derivatives: %v", urls)
+	}
+}
+
+func TestImageVariantsContentAddressedRollback(t *testing.T) {
+	mem := newMemStorage()
+	storage := &failingStorage{memStorage: mem, fail: func(key string) bool { return strings.Contains(key, "_medium") }}
+	svc, err := New("http://cdn.example.com", "images", WithStorage(storage), WithContentAddressed(),
+		WithImageVariants(DefaultImageVariants...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 衍生图失败时删除新写入的原图
+	if _, err = svc.UploadFile(bytes.NewReader(pngBytes(t, 40, 20)), "a.png"); err == nil {
+		t.Fatal("expected derivative error")
+	}
+	if len(mem.objects) != 0 {
+		t.Errorf("new original and derivatives should be removed, left %d objects", len(mem.objects))
+	}
+
+	// 已存在的原图可能被其他上传引用，保留
+	storage.fail = func(string) bool { return false }
+	if _, err = svc.UploadFile(bytes.NewReader(pngBytes(t, 40, 20)), "a.png"); err != nil {
+		t.Fatal(err)
+	}
+	storage.fail = func(key string) bool { return strings.Contains(key, "_medium") }
+	if _, err = svc.UploadFile(bytes.NewReader(pngBytes(t, 40, 20)), "a.png"); err == nil {
+		t.Fatal("expected derivative error")
+	}
+	if len(mem.objects) != 1+len(DefaultImageVariants) {
+		t.Errorf("existing original and derivatives should be kept, got %d objects", len(mem.objects))
+	}
+}
+
+func TestInvalidImageVariants(t *testing.T) {
+	invalid := []ImageVariant{
+		{Name: "", Width: 100},
+		{Name: "thumb"},
+		{Name: "thumb", Width: 100, Mode: "stretch"},
+		{Name: "thumb", Width: 100, Format: "avif"},
+	}
+	for _, v := range invalid {
+		if _, err := New("", "images", WithStorage(newMemStorage()), WithImageVariants(v)); err == nil {
+			t.Errorf("expected error for variant %+v", v)
+		}
+	}
+}
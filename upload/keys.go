@@ -0,0 +1,170 @@
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+)
+
+// KeyInfo 生成文件名时可用的信息
+type KeyInfo struct {
+	// 原文件名
+	Filename string
+	// 上传者，见 WithOwner
+	Owner string
+	// 生成文件名的时间
+	Time time.Time
+}
+
+// KeyStrategy 根据原文件名等信息生成服务目录下的文件名，可以包含 "/" 分隔的子目录，结果必须唯一
+type KeyStrategy func(info KeyInfo) string
+
+// FlatKeys 默认的文件名：32 位随机十六进制加原扩展名，所有文件位于同一目录
+func FlatKeys(info KeyInfo) string {
+	return randomName() + path.Ext(SanitizeFilename(info.Filename))
+}
+
+// DateKeys 按上传日期分目录，例如 2026/10/17/<随机名>.png，next 为空时使用 FlatKeys
+func DateKeys(next KeyStrategy) KeyStrategy {
+	next = orFlat(next)
+	return func(info KeyInfo) string {
+		return path.Join(info.Time.Format("2006/01/02"), next(info))
+	}
+}
+
+// HashPrefixKeys 按文件名哈希的前缀分 levels 级目录，每级两位十六进制，例如 3f/a2/<随机名>.png，
+// 文件均匀分布在 256^levels 个目录中。next 为空时使用 FlatKeys
+func HashPrefixKeys(levels int, next KeyStrategy) KeyStrategy {
+	next = orFlat(next)
+	return func(info KeyInfo) string {
+		name := next(info)
+		sum := sha256.Sum256([]byte(name))
+		prefix := hex.EncodeToString(sum[:])
+		parts := make([]string, 0, levels+1)
+		for i := 0; i < levels && 2*i+2 <= len(prefix); i++ {
+			parts = append(parts, prefix[2*i:2*i+2])
+		}
+		return path.Join(append(parts, name)...)
+	}
+}
+
+// UserKeys 按上传者分目录，例如 u123/<随机名>.png，没有上传者时为 anonymous。next 为空时使用 FlatKeys。
+// 不同的上传者对应不同的目录，见 ownerDir
+func UserKeys(next KeyStrategy) KeyStrategy {
+	next = orFlat(next)
+	return func(info KeyInfo) string {
+		return path.Join(ownerDir(info.Owner), next(info))
+	}
+}
+
+// 上传者目录名的最大长度，超过时截断并加上哈希
+const maxOwnerDirLength = 64
+
+// ownerDir 上传者的目录名。小写字母、数字和 "-" 原样保留，其他字节编码为 "_" 加两位大写十六进制，
+// 例如 "a/b" 为 a_2Fb、"a_b" 为 a_5Fb、"Alice" 为 _41lice，不同的上传者不会对应同一个目录，
+// 在不区分大小写的文件系统上同样如此。与 anonymous 或 Windows 保留设备名相同时编码首字母，
+// 过长时截断并加上 "~" 和完整上传者的哈希
+func ownerDir(owner string) string {
+	if owner == "" {
+		return "anonymous"
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(owner); i++ {
+		c := owner[i]
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "_%02X", c)
+		}
+	}
+	dir := b.String()
+	if dir == "anonymous" || reservedNames[strings.ToUpper(dir)] {
+		dir = fmt.Sprintf("_%02X", dir[0]) + dir[1:]
+	}
+	if len(dir) > maxOwnerDirLength {
+		sum := sha256.Sum256([]byte(owner))
+		dir = dir[:maxOwnerDirLength] + "~" + hex.EncodeToString(sum[:16])
+	}
+	return dir
+}
+
+// OriginalNameKeys 保留清理后的原文件名并加 8 位随机后缀，例如 报表_1a2b3c4d.csv
+func OriginalNameKeys(info KeyInfo) string {
+	name := SanitizeFilename(info.Filename)
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "_" + randomName()[:8] + ext
+}
+
+func orFlat(strategy KeyStrategy) KeyStrategy {
+	if strategy == nil {
+		return FlatKeys
+	}
+	return strategy
+}
+
+func randomName() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// 文件系统（Windows）和 URL 的保留字符
+const reservedChars = `/\:*?"<>|#%&{}$;^[]'` + "`"
+
+// 文件名的最大长度（字符数），不含扩展名
+const maxFilenameLength = 100
+
+// Windows 保留的设备名，不区分大小写，带扩展名同样保留
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFilename 清理文件名，使其可以安全地作为存储 key 和 URL 的一部分：
+// 去掉目录部分，统一为 Unicode NFC 形式（macOS 上传的文件名通常为 NFD），
+// 文件系统和 URL 的保留字符、控制字符和空白替换为 "_"，去掉首尾的 "." 和 "_"，
+// 避开 Windows 保留的设备名，并限制长度。清理后为空时返回 file
+func SanitizeFilename(name string) string {
+	return sanitizeName(path.Base(strings.ReplaceAll(name, "\\", "/")))
+}
+
+// sanitizeName 清理单级路径，其中的 "/" 同样替换为 "_"
+func sanitizeName(name string) string {
+	name = norm.NFC.String(name)
+
+	var b strings.Builder
+	underscore := false
+	for _, r := range name {
+		if r == utf8.RuneError || unicode.IsControl(r) || unicode.IsSpace(r) || strings.ContainsRune(reservedChars, r) {
+			r = '_'
+		}
+		// 连续的替换字符只保留一个
+		if r == '_' && underscore {
+			continue
+		}
+		underscore = r == '_'
+		b.WriteRune(r)
+	}
+	name = strings.Trim(b.String(), "._")
+
+	ext := path.Ext(name)
+	base := strings.TrimRight(strings.TrimSuffix(name, ext), "._")
+	if runes := []rune(base); len(runes) > maxFilenameLength {
+		base = string(runes[:maxFilenameLength])
+	}
+	if base == "" {
+		base = "file"
+	}
+	if reservedNames[strings.ToUpper(base)] {
+		base = "_" + base
+	}
+	return base + ext
+}
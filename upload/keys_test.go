@@ -0,0 +1,121 @@
+package upload
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := map[string]string{
+		"report.pdf":                 "report.pdf",
+		"../../etc/passwd":           "passwd",
+		`C:\Users\me\photo.JPG`:      "photo.JPG",
+		"cafe\u0301 menu.png":        "caf\u00e9_menu.png", // NFD 转为 NFC
+		"a<b>c:d?e*f|g#h%i.txt":      "a_b_c_d_e_f_g_h_i.txt",
+		"  \t..hidden  ":             "hidden",
+		"报表 2024.csv":                "报表_2024.csv",
+		"con.txt":                    "_con.txt",
+		"???":                        "file",
+		"name\x00with\x1fcontrol.md": "name_with_control.md",
+	}
+	for input, want := range tests {
+		if got := SanitizeFilename(input); got != want {
+			t.Errorf("SanitizeFilename(%q) = %q, want %q", input, got, want)
+		}
+	}
+
+	long := SanitizeFilename(strings.Repeat("长", 300) + ".txt")
+	if n := len([]rune(long)); n != maxFilenameLength+len(".txt") {
+		t.Errorf("long name not truncated, got %d runes", n)
+	}
+}
+
+func TestKeyStrategies(t *testing.T) {
+	info := KeyInfo{Filename: "年度 报表.CSV", Owner: "u/42", Time: time.Date(2026, 10, 17, 9, 0, 0, 0, time.Local)}
+	tests := []struct {
+		name     string
+		strategy KeyStrategy
+		pattern  string
+	}{
+		{"flat", FlatKeys, `^[0-9a-f]{32}\.CSV$`},
+		{"date", DateKeys(nil), `^2026/10/17/[0-9a-f]{32}\.CSV$`},
+		{"hash", HashPrefixKeys(2, nil), `^[0-9a-f]{2}/[0-9a-f]{2}/[0-9a-f]{32}\.CSV$`},
+		{"user", UserKeys(DateKeys(OriginalNameKeys)), `^u_2F42/2026/10/17/年度_报表_[0-9a-f]{8}\.CSV$`},
+		{"original", OriginalNameKeys, `^年度_报表_[0-9a-f]{8}\.CSV$`},
+	}
+	for _, tt := range tests {
+		key := tt.strategy(info)
+		if !regexp.MustCompile(tt.pattern).MatchString(key) {
+			t.Errorf("%s: key %q does not match %s", tt.name, key, tt.pattern)
+		}
+		if key == tt.strategy(info) {
+			t.Errorf("%s: keys should be unique", tt.name)
+		}
+	}
+	if key := UserKeys(nil)(KeyInfo{Filename: "a.png"}); !strings.HasPrefix(key, "anonymous/") {
+		t.Errorf("expected anonymous directory, got %q", key)
+	}
+}
+
+func TestServiceKeyStrategy(t *testing.T) {
+	mem := newMemStorage()
+	svc, err := New("http://127.0.0.1:3000", "goods", WithStorage(mem), WithKeyStrategy(UserKeys(OriginalNameKeys)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := svc.GenerateUniqueFilename("demo.txt", WithOwner("alice"))
+	fileURL, err := svc.UploadFile(strings.NewReader("hello"), name, WithOwner("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^http://127\.0\.0\.1:3000/goods/alice/demo_[0-9a-f]{8}\.txt$`).MatchString(fileURL) {
+		t.Errorf("unexpected url %s", fileURL)
+	}
+
+	// 默认仍为扁平的随机文件名
+	flat, err := New("", "goods", WithStorage(mem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name = flat.GenerateUniqueFilename("demo.txt"); !regexp.MustCompile(`^[0-9a-f]{32}\.txt$`).MatchString(name) {
+		t.Errorf("unexpected default name %q", name)
+	}
+}
+
+func TestOwnerDir(t *testing.T) {
+	tests := map[string]string{
+		"":          "anonymous",
+		"u123":      "u123",
+		"a/b":       "a_2Fb",
+		"a b":       "a_20b",
+		"a_b":       "a_5Fb",
+		"alice.":    "alice_2E",
+		"Alice":     "_41lice",
+		"..":        "_2E_2E",
+		"anonymous": "_61nonymous",
+		"con":       "_63on",
+		"用户":        "_E7_94_A8_E6_88_B7",
+	}
+	for owner, want := range tests {
+		if got := ownerDir(owner); got != want {
+			t.Errorf("ownerDir(%q) = %q, want %q", owner, got, want)
+		}
+	}
+
+	// 不同的上传者不会对应同一个目录，不区分大小写时同样如此
+	seen := make(map[string]string)
+	for _, owner := range []string{"a/b", "a b", "a_b", "a_5Fb", "alice", "alice.", "Alice", "ALICE", "anonymous", "",
+		strings.Repeat("x", 100), strings.Repeat("x", 101), strings.Repeat("x", 64)} {
+		dir := strings.ToLower(ownerDir(owner))
+		if other, ok := seen[dir]; ok {
+			t.Errorf("owners %q and %q share directory %q", owner, other, dir)
+		}
+		seen[dir] = owner
+		if len(dir) > maxOwnerDirLength+33 || strings.ContainsAny(dir, "/.") {
+			t.Errorf("invalid directory %q for owner %q", dir, owner)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package upload
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	RegisterDriver(DriverLocal, newLocalStorage)
+}
+
+// localStorage 本地文件系统存储
+type localStorage struct {
+	root string
+}
+
+// newLocalStorage 创建本地存储，Params[ParamRoot] 为根目录，默认为当前工作目录
+func newLocalStorage(cfg DriverConfig) (Storage, error) {
+	root := cfg.Params[ParamRoot]
+	if root == "" {
+		root = "."
+	}
+	return &localStorage{root: root}, nil
+}
+
+// fullPath 将 key 转换为本地文件路径
+func (l *localStorage) fullPath(key string) string {
+	return filepath.Join(l.root, filepath.FromSlash(key))
+}
+
+// 保存文件属性和元数据的目录，位于根目录下，key 为 a/b.png 的属性保存在 .meta/a/b.png.json
+const localMetaDir = ".meta"
+
+// localMeta 本地文件的属性和元数据
+type localMeta struct {
+	ObjectAttrs
+	Tags map[string]string `json:"tags,omitempty"`
+	// 访问权限，没有文件记录仓库时（例如重启后）由 FileACL 读取
+	ACL ACL `json:"acl,omitempty"`
+	// 内容的 MD5，由 Stat 作为 ETag 返回
+	MD5 string `json:"md5,omitempty"`
+}
+
+// metaPath 文件属性的保存路径
+func (l *localStorage) metaPath(key string) string {
+	return filepath.Join(l.root, localMetaDir, filepath.FromSlash(key)+".json")
+}
+
+// Put 写入文件
+func (l *localStorage) Put(key string, reader io.Reader, opts PutOptions) error {
+	filename := l.fullPath(key)
+
+	// 创建目录
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return localError("upload", key, fmt.Errorf("创建目录失败 (%s): %w", filepath.Dir(key), err))
+	}
+
+	// 创建目标文件
+	destFile, err := os.Create(filename)
+	if err != nil {
+		return localError("upload", key, fmt.Errorf("创建目标文件失败: %w", err))
+	}
+
+	// 复制文件内容，同时计算 MD5
+	hash := md5.New()
+	_, err = io.Copy(destFile, io.TeeReader(reader, hash))
+
+	// 关闭目标文件
+	destFile.Close()
+	if err != nil {
+		os.Remove(filename)
+		return localError("upload", key, fmt.Errorf("复制文件内容失败: %w", err))
+	}
+
+	if err = l.putMeta(key, opts, hex.EncodeToString(hash.Sum(nil))); err != nil {
+		os.Remove(filename)
+		return localError("upload", key, err)
+	}
+	return nil
+}
+
+// putMeta 保存文件属性和内容的 MD5，都没有时删除旧的属性文件
+func (l *localStorage) putMeta(key string, opts PutOptions, md5sum string) error {
+	meta := localMeta{
+		ObjectAttrs: ObjectAttrs{
+			ContentType:        opts.ContentType,
+			CacheControl:       opts.CacheControl,
+			ContentDisposition: opts.ContentDisposition,
+			Metadata:           opts.Metadata,
+		},
+		Tags: opts.Tags,
+		ACL:  opts.ACL,
+		MD5:  md5sum,
+	}
+	metaPath := l.metaPath(key)
+	if meta.ContentType == "" && meta.CacheControl == "" && meta.ContentDisposition == "" &&
+		len(meta.Metadata) == 0 && len(meta.Tags) == 0 && meta.ACL == ACLDefault && meta.MD5 == "" {
+		os.Remove(metaPath)
+		return nil
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("序列化文件属性失败: %w", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(metaPath), 0755); err != nil {
+		return fmt.Errorf("创建目录失败 (%s): %w", filepath.Dir(metaPath), err)
+	}
+	if err = os.WriteFile(metaPath, data, 0644); err != nil {
+		return fmt.Errorf("保存文件属性失败: %w", err)
+	}
+	return nil
+}
+
+// readMeta 读取文件属性，没有属性文件时（例如旧版本上传的文件）属性为空
+func (l *localStorage) readMeta(key string) (localMeta, error) {
+	var meta localMeta
+	data, err := os.ReadFile(l.metaPath(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, fmt.Errorf("读取文件属性失败: %w", err)
+	}
+	if err = json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("读取文件属性失败: %w", err)
+	}
+	return meta, nil
+}
+
+// Copy 复制文件及其属性
+func (l *localStorage) Copy(srcKey, dstKey string) error {
+	src, err := l.Open(srcKey)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	meta, err := l.readMeta(srcKey)
+	if err != nil {
+		return localError("copy", srcKey, err)
+	}
+	return l.Put(dstKey, src, PutOptions{
+		ContentType:        meta.ContentType,
+		CacheControl:       meta.CacheControl,
+		ContentDisposition: meta.ContentDisposition,
+		Metadata:           meta.Metadata,
+		Tags:               meta.Tags,
+		ACL:                meta.ACL,
+	})
+}
+
+// objectACL 读取属性文件中保存的访问权限
+func (l *localStorage) objectACL(key string) (ACL, error) {
+	meta, err := l.readMeta(key)
+	if err != nil {
+		return ACLPrivate, localError("stat", key, err)
+	}
+	return meta.ACL, nil
+}
+
+// Get 读取文件
+func (l *localStorage) Get(key string) (io.ReadCloser, error) {
+	return l.Open(key)
+}
+
+// Open 打开文件用于随机读取
+func (l *localStorage) Open(key string) (io.ReadSeekCloser, error) {
+	file, err := os.Open(l.fullPath(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errLocalNotExist("download", key)
+		}
+		return nil, localError("download", key, fmt.Errorf("打开文件失败: %w", err))
+	}
+	return file, nil
+}
+
+// Delete 删除文件
+func (l *localStorage) Delete(key string) error {
+	filename := l.fullPath(key)
+
+	// 检查文件是否存在
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return errLocalNotExist("delete", key)
+	}
+
+	// 删除文件
+	if err := os.Remove(filename); err != nil {
+		return localError("delete", key, fmt.Errorf("删除文件失败: %w", err))
+	}
+	os.Remove(l.metaPath(key))
+	return nil
+}
+
+// Stat 获取文件信息
+func (l *localStorage) Stat(key string) (*FileInfo, error) {
+	info, err := os.Stat(l.fullPath(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errLocalNotExist("stat", key)
+		}
+		return nil, localError("stat", key, fmt.Errorf("获取文件信息失败: %w", err))
+	}
+	if info.IsDir() {
+		return nil, storageError("stat", key, ErrNotFound, fmt.Errorf("不是文件: %s", key))
+	}
+
+	meta, err := l.readMeta(key)
+	if err != nil {
+		return nil, localError("stat", key, err)
+	}
+	return &FileInfo{
+		Key:         key,
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		ETag:        meta.MD5,
+		ObjectAttrs: meta.ObjectAttrs,
+	}, nil
+}
+
+// List 按前缀分页列出文件，游标为上一页最后一个 key。
+// 按 key 的顺序遍历目录，跳过游标之前和不匹配前缀的目录，取满一页即停止
+func (l *localStorage) List(prefix, cursor string, limit int) ([]FileInfo, string, error) {
+	// 只需遍历前缀所在的目录
+	dir := ""
+	if d := path.Dir(prefix); d != "." && d != "/" {
+		dir = d + "/"
+	}
+
+	var files []FileInfo
+	full := func() bool { return limit > 0 && len(files) > limit }
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := os.ReadDir(l.fullPath(dir))
+		if err != nil {
+			return err
+		}
+
+		// 目录按 "name/" 排序，与 key 的字典序一致
+		names := make([]string, len(entries))
+		for i, entry := range entries {
+			names[i] = dir + entry.Name()
+			if entry.IsDir() {
+				names[i] += "/"
+			}
+		}
+		sort.Sort(byName{names, entries})
+
+		for i, entry := range entries {
+			key := names[i]
+			if entry.IsDir() {
+				// 跳过文件属性目录、不匹配前缀的目录和游标之前的目录
+				if key == localMetaDir+"/" ||
+					!strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) ||
+					key <= cursor && !strings.HasPrefix(cursor, key) {
+					continue
+				}
+				if err = walk(key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+			} else if strings.HasPrefix(key, prefix) && key > cursor {
+				info, err := entry.Info()
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				files = append(files, FileInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+			}
+			if full() {
+				return nil
+			}
+		}
+		return nil
+	}
+	if err := walk(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, "", localError("list", prefix, fmt.Errorf("遍历目录失败: %w", err))
+	}
+
+	var next string
+	if full() {
+		files = files[:limit]
+		next = files[limit-1].Key
+	}
+	return files, next, nil
+}
+
+// byName 按 names 排序目录项
+type byName struct {
+	names   []string
+	entries []os.DirEntry
+}
+
+func (b byName) Len() int           { return len(b.names) }
+func (b byName) Less(i, j int) bool { return b.names[i] < b.names[j] }
+func (b byName) Swap(i, j int) {
+	b.names[i], b.names[j] = b.names[j], b.names[i]
+	b.entries[i], b.entries[j] = b.entries[j], b.entries[i]
+}
+
+// errLocalNotExist 文件不存在的错误
+func errLocalNotExist(op, key string) error {
+	return storageError(op, key, ErrNotFound, fmt.Errorf("文件不存在: %s", key))
+}
+
+// localError 包装本地文件操作的错误，根据 os 的错误判断分类，其他错误（例如磁盘已满）视为存储不可用。
+// 写入时读取上传内容返回的错误（例如 ErrContentMismatch）保留原有的分类
+func localError(op, key string, err error) error {
+	kind := ErrBackendUnavailable
+	switch {
+	case kindOf(err) != nil:
+		kind = nil
+	case errors.Is(err, fs.ErrNotExist):
+		kind = ErrNotFound
+	case errors.Is(err, fs.ErrPermission):
+		kind = ErrPermissionDenied
+	case errors.Is(err, fs.ErrExist):
+		kind = ErrAlreadyExists
+	}
+	return storageError(op, key, kind, err)
+}
@@ -0,0 +1,188 @@
+package upload
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// CopyStorage 支持服务端复制的存储驱动，不支持时读取后重新写入
+type CopyStorage interface {
+	// Copy 复制文件，属性和访问权限与源文件一致
+	Copy(srcKey, dstKey string) error
+}
+
+// Stat 获取文件信息
+func (s *service) Stat(filename string) (*FileInfo, error) {
+	return s.storage.Stat(s.cleanKey(filename))
+}
+
+// List 按前缀分页列出文件，按 key 排序。cursor 为上一页返回的游标，为空时从第一页开始，
+// 返回的游标为空时没有下一页。prefix 为存储 key 的前缀，例如 uploads/goods/
+func (s *service) List(prefix, cursor string, limit int) ([]FileInfo, string, error) {
+	prefix = strings.TrimPrefix(strings.ReplaceAll(prefix, "\\", "/"), "/")
+	return s.storage.List(prefix, cursor, limit)
+}
+
+// Copy 复制文件及其衍生图，返回新文件的访问地址。
+// 源文件有上传记录时复制记录并计入上传者的配额，目标文件已存在时覆盖。
+// 设置 WithOwner 时只能复制该上传者的文件，复制的记录属于该上传者，也不能覆盖其他上传者的文件
+func (s *service) Copy(src, dst string, opts ...UploadOption) (string, error) {
+	return s.transfer(src, dst, false, newUploadOptions("", opts...).owner)
+}
+
+// Move 移动文件及其衍生图，返回新文件的访问地址。上传记录随文件移动，目标文件已存在时覆盖。
+// 设置 WithOwner 时只能移动该上传者的文件，被多个上传者引用的内容寻址文件不能移动
+func (s *service) Move(src, dst string, opts ...UploadOption) (string, error) {
+	return s.transfer(src, dst, true, newUploadOptions("", opts...).owner)
+}
+
+// transfer 复制文件，move 为 true 时复制完成后删除源文件，owner 不为空时检查文件是否属于该上传者
+func (s *service) transfer(src, dst string, move bool, owner string) (string, error) {
+	srcKey, dstKey := s.cleanKey(src), s.cleanKey(dst)
+	if srcKey == dstKey {
+		return "", kindErrorf(ErrInvalidArgument, "源文件和目标文件相同: %s", srcKey)
+	}
+
+	record, err := s.lookupRecord(srcKey)
+	if err != nil {
+		return "", fmt.Errorf("获取文件记录失败: %w", err)
+	}
+	prev, err := s.lookupRecord(dstKey)
+	if err != nil {
+		return "", fmt.Errorf("获取文件记录失败: %w", err)
+	}
+	if err = checkTransfer(record, prev, move, owner); err != nil {
+		return "", err
+	}
+
+	if err = s.copyObject(srcKey, dstKey); err != nil {
+		return "", err
+	}
+	s.copyDerivatives(srcKey, dstKey)
+
+	// 复制的记录属于复制者，内容寻址的其他引用不随之复制
+	if record != nil && !move {
+		copied := *record
+		if owner != "" {
+			copied.Owner, copied.ExpiresAt = owner, record.expiresAt(owner)
+		}
+		copied.Refs = nil
+		record = &copied
+	}
+
+	// 复制时新文件计入用量，移动时用量不变。覆盖的文件不再存在，释放其用量
+	undo := func() {}
+	switch {
+	case record != nil && !move:
+		if undo, err = s.reserveQuota(record.Owner, record.Size, prev); err != nil {
+			if prev == nil {
+				s.storage.Delete(dstKey)
+				s.deleteDerivatives(dstKey)
+			}
+			return "", err
+		}
+	case prev != nil:
+		if err = s.releaseQuota(prev); err != nil {
+			return "", err
+		}
+	}
+
+	if record != nil {
+		moved := *record
+		moved.Key = dstKey
+		moved.URL = s.fileURL(dstKey)
+		if !move {
+			moved.CreatedAt = time.Now()
+		}
+		if err = s.opts.repository.Save(&moved); err != nil {
+			undo()
+			return "", fmt.Errorf("保存文件记录失败: %w", err)
+		}
+	} else if prev != nil {
+		if err = s.deleteRecord(dstKey); err != nil {
+			return "", fmt.Errorf("删除文件记录失败: %w", err)
+		}
+	}
+
+	if !move {
+		return s.fileURL(dstKey), nil
+	}
+
+	if record != nil {
+		if err = s.deleteRecord(srcKey); err != nil {
+			return "", fmt.Errorf("删除文件记录失败: %w", err)
+		}
+	}
+	if err = s.storage.Delete(srcKey); err != nil {
+		return "", fmt.Errorf("删除源文件失败: %w", err)
+	}
+	s.deleteDerivatives(srcKey)
+	return s.fileURL(dstKey), nil
+}
+
+// checkTransfer 检查复制或移动的权限：设置上传者时源文件和被覆盖的文件都必须属于该上传者，
+// 被多个上传者引用的内容寻址文件不能移动或覆盖
+func checkTransfer(record, prev *FileRecord, move bool, owner string) error {
+	if owner != "" {
+		if record != nil && !record.hasRef(owner) {
+			return kindErrorf(ErrPermissionDenied, "文件不属于上传者 %s: %s", owner, record.Key)
+		}
+		if prev != nil && !prev.hasRef(owner) {
+			return kindErrorf(ErrPermissionDenied, "目标文件不属于上传者 %s: %s", owner, prev.Key)
+		}
+	}
+	if move && record != nil && record.RefCount() > 1 {
+		return kindErrorf(ErrInvalidArgument, "文件被 %d 个上传者引用，不能移动: %s", record.RefCount(), record.Key)
+	}
+	if prev != nil && prev.RefCount() > 1 {
+		return kindErrorf(ErrInvalidArgument, "目标文件被 %d 个上传者引用，不能覆盖: %s", prev.RefCount(), prev.Key)
+	}
+	return nil
+}
+
+// copyObject 复制对象，驱动不支持服务端复制时读取后重新写入，此时不保留对象标签。
+// 访问权限与源文件一致，没有文件记录时使用驱动保存的权限，衍生图使用原图的权限
+func (s *service) copyObject(srcKey, dstKey string) error {
+	if storage, ok := s.storage.(CopyStorage); ok {
+		return storage.Copy(srcKey, dstKey)
+	}
+
+	info, err := s.storage.Stat(srcKey)
+	if err != nil {
+		return err
+	}
+	acl, err := s.FileACL(srcKey)
+	if err != nil {
+		return err
+	}
+	reader, err := s.storage.Get(srcKey)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	putOpts := PutOptions{
+		ContentType:        info.ContentType,
+		ACL:                acl,
+		CacheControl:       info.CacheControl,
+		ContentDisposition: info.ContentDisposition,
+		Metadata:           info.Metadata,
+	}
+	if putOpts.ContentType == "" {
+		putOpts.ContentType = s.GetContentType(dstKey)
+	}
+	return s.storage.Put(dstKey, reader, putOpts)
+}
+
+// copyDerivatives 复制衍生图，扩展名改变时衍生图的格式可能不一致，跳过。
+// 配置衍生图前上传的文件没有衍生图，忽略复制错误
+func (s *service) copyDerivatives(srcKey, dstKey string) {
+	if !s.isDerivable(srcKey) || !strings.EqualFold(path.Ext(srcKey), path.Ext(dstKey)) {
+		return
+	}
+	for _, v := range s.opts.imageVariants {
+		s.copyObject(derivativeKey(srcKey, v), derivativeKey(dstKey, v))
+	}
+}
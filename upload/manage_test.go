@@ -0,0 +1,187 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCopyMove(t *testing.T) {
+	mem := newMemStorage()
+	repo := NewMemoryFileRepository()
+	svc, err := New("http://cdn.example.com", "goods", WithStorage(mem), WithFileRepository(repo),
+		WithQuota(NewMemoryQuotaStore(), StaticQuota(Quota{MaxFiles: 2})),
+		WithImageVariants(ImageVariant{Name: "thumb", Width: 10, Height: 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(bytes.NewReader(pngBytes(t, 40, 20)), "a.png", WithOwner("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	fileURL, err := svc.Copy("goods/a.png", "goods/b.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileURL != "http://cdn.example.com/goods/b.png" {
+		t.Errorf("unexpected url: %s", fileURL)
+	}
+	for _, key := range []string{"goods/a.png", "goods/a_thumb.png", "goods/b.png", "goods/b_thumb.png"} {
+		if _, ok := mem.objects[key]; !ok {
+			t.Errorf("missing object %s", key)
+		}
+	}
+	if record, err := svc.GetRecord(fileURL); err != nil || record.Owner != "1" || record.Key != "goods/b.png" {
+		t.Errorf("unexpected copied record %+v, %v", record, err)
+	}
+	if usage, _, _ := svc.GetUsage("1"); usage.Files != 2 {
+		t.Errorf("copy should count towards quota, got %+v", usage)
+	}
+
+	// 移动不改变用量，源文件、衍生图和记录都被删除
+	if _, err = svc.Move("goods/b.png", "goods/c.png"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"goods/b.png", "goods/b_thumb.png"} {
+		if _, ok := mem.objects[key]; ok {
+			t.Errorf("moved object %s should be removed", key)
+		}
+	}
+	if _, ok := mem.objects["goods/c_thumb.png"]; !ok {
+		t.Error("derivative should be moved")
+	}
+	if _, err = svc.GetRecord("goods/b.png"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("source record should be removed, got %v", err)
+	}
+	if _, err = svc.GetRecord("goods/c.png"); err != nil {
+		t.Errorf("record should be moved: %v", err)
+	}
+	if usage, _, _ := svc.GetUsage("1"); usage.Files != 2 {
+		t.Errorf("move should not change usage, got %+v", usage)
+	}
+
+	// 超出配额时删除复制的文件
+	if _, err = svc.Copy("goods/a.png", "goods/d.png"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if _, ok := mem.objects["goods/d.png"]; ok {
+		t.Error("rejected copy should be removed")
+	}
+
+	if _, err = svc.Copy("goods/a.png", "/goods/a.png"); err == nil {
+		t.Error("expected error when copying to itself")
+	}
+
+	files, next, err := svc.List("/goods/", "", 0)
+	if err != nil || next != "" || len(files) != 4 {
+		t.Errorf("list = %+v, %s, %v", files, next, err)
+	}
+}
+
+func TestLocalCopyKeepsAttrs(t *testing.T) {
+	svc, err := New("http://127.0.0.1:3000", "goods", WithDriverParam(ParamRoot, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("a,b"), "a.csv",
+		WithCacheControl("no-cache"), WithMetadata(map[string]string{"owner": "42"})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.Copy("uploads/goods/a.csv", "uploads/goods/sub/b.csv"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := svc.Stat("uploads/goods/sub/b.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 3 || info.CacheControl != "no-cache" || info.Metadata["owner"] != "42" {
+		t.Errorf("unexpected copied file: %+v", info)
+	}
+}
+
+func TestCopyMoveOwner(t *testing.T) {
+	svc, err := New("", "goods", WithStorage(newMemStorage()), WithFileRepository(NewMemoryFileRepository()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("a"), "a.txt", WithOwner("1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("b"), "b.txt", WithOwner("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不能复制、移动其他上传者的文件，也不能覆盖其他上传者的文件
+	if _, err = svc.Copy("goods/a.txt", "goods/c.txt", WithOwner("2")); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected permission denied for copy, got %v", err)
+	}
+	if _, err = svc.Move("goods/a.txt", "goods/c.txt", WithOwner("2")); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected permission denied for move, got %v", err)
+	}
+	if _, err = svc.Copy("goods/a.txt", "goods/b.txt", WithOwner("1")); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected permission denied for overwrite, got %v", err)
+	}
+
+	if _, err = svc.Move("goods/a.txt", "goods/c.txt", WithOwner("1")); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := svc.GetRecord("goods/c.txt"); err != nil || record.Owner != "1" {
+		t.Errorf("moved record = %+v, %v", record, err)
+	}
+}
+
+func TestMoveSharedContent(t *testing.T) {
+	svc, err := New("", "textures", WithStorage(newMemStorage()), WithContentAddressed(), WithFileRepository(NewMemoryFileRepository()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileURL, err := svc.UploadFile(strings.NewReader("wood"), "a.png", WithOwner("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("wood"), "a.png", WithOwner("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 移动会使其他上传者的地址失效
+	if _, err = svc.Move(fileURL, "textures/b.png", WithOwner("1")); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expected invalid argument for shared content, got %v", err)
+	}
+	// 复制的记录只属于复制者
+	if _, err = svc.Copy(fileURL, "textures/b.png", WithOwner("2")); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := svc.GetRecord("textures/b.png"); err != nil || record.Owner != "2" || record.RefCount() != 1 {
+		t.Errorf("copied record = %+v, %v", record, err)
+	}
+}
+
+func TestCopyKeepsACLWithoutRepository(t *testing.T) {
+	primary, secondary := t.TempDir(), t.TempDir()
+	newMirror := func(optFns ...Option) Service {
+		t.Helper()
+		p, _ := newLocalStorage(DriverConfig{Params: map[string]string{ParamRoot: primary}})
+		s, _ := newLocalStorage(DriverConfig{Params: map[string]string{ParamRoot: secondary}})
+		svc, err := NewMirrorService("", "docs", p, s, optFns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return svc
+	}
+
+	svc := newMirror(WithFileRepository(NewMemoryFileRepository()))
+	if _, err := svc.UploadFile(strings.NewReader("contract"), "a.txt", WithACL(ACLPrivate)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有文件记录时按属性文件中的权限复制
+	restarted := newMirror()
+	if _, err := restarted.Copy("docs/a.txt", "docs/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if acl, err := restarted.FileACL("docs/b.txt"); err != nil || acl != ACLPrivate {
+		t.Errorf("copied acl = %q, %v", acl, err)
+	}
+}
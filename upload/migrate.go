@@ -0,0 +1,381 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// MigrateOptions 迁移选项
+type MigrateOptions struct {
+	// 只迁移 key 以 Prefix 开头的文件，例如 uploads/
+	Prefix string
+	// 并发复制的文件数，默认为 4
+	Concurrency int
+	// 只统计需要复制的文件，不写入目标存储
+	DryRun bool
+	// 复制后重新读取目标文件校验 SHA-256。目标文件已存在时同样比较内容，而不只是大小和 ETag 中的 MD5
+	Verify bool
+	// 断点文件路径，每复制完一批文件保存进度，中断后重新运行时从断点继续，迁移完成后删除
+	Checkpoint string
+	// 重写文件记录中访问地址的旧域名和新域名，为空时分别使用源服务和目标服务的域名
+	OldHost string
+	NewHost string
+	// 每个文件处理完成后调用，用于输出进度，可能被多个协程同时调用
+	Progress func(key string, copied bool, err error)
+}
+
+// MigrateReport 迁移结果
+type MigrateReport struct {
+	// 已复制的文件数，DryRun 时为需要复制的文件数
+	Copied int `json:"copied"`
+	// 目标存储中已存在且一致的文件数
+	Skipped int `json:"skipped"`
+	// 已复制的字节数
+	Bytes int64 `json:"bytes"`
+	// 复制失败的文件
+	Errors []MigrateError `json:"-"`
+}
+
+// MigrateError 迁移单个文件失败的原因
+type MigrateError struct {
+	Key string
+	Err error
+}
+
+func (e MigrateError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+// 每批列出并复制的文件数，每批完成后保存断点
+const migrateBatch = 100
+
+// 默认并发复制的文件数
+const defaultMigrateConcurrency = 4
+
+// migrateCheckpoint 断点文件的内容，Cursor 之前（含）的文件都已处理，Failed 为其中复制失败的文件
+type migrateCheckpoint struct {
+	Prefix string   `json:"prefix"`
+	Cursor string   `json:"cursor"`
+	Failed []string `json:"failed"`
+	MigrateReport
+}
+
+// baseService 返回 svc 的内部实现，New、NewOssService 和 NewMirrorService 创建的服务都支持
+func baseService(svc Service) (*service, error) {
+	if b, ok := svc.(interface{ base() *service }); ok {
+		return b.base(), nil
+	}
+	return nil, kindErrorf(ErrInvalidArgument, "不支持的上传服务: %T", svc)
+}
+
+func (s *service) base() *service {
+	return s
+}
+
+// Migrate 将 src 存储中的文件按 key 原样复制到 dst 的存储，包括衍生图，保留文件属性和访问权限。
+// 两端都配置了文件记录仓库时同时复制文件记录，并将访问地址改为新域名。
+// 目标文件已存在且一致时跳过，单个文件失败记录在结果中并继续迁移后续文件
+func Migrate(ctx context.Context, src, dst Service, opts MigrateOptions) (*MigrateReport, error) {
+	from, err := baseService(src)
+	if err != nil {
+		return nil, err
+	}
+	to, err := baseService(dst)
+	if err != nil {
+		return nil, err
+	}
+	if opts.OldHost == "" {
+		opts.OldHost = from.host
+	}
+	if opts.NewHost == "" {
+		opts.NewHost = to.host
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultMigrateConcurrency
+	}
+
+	m := &migration{from: from, to: to, opts: opts}
+	checkpoint, err := m.loadCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := checkpoint.Cursor
+	m.report = checkpoint.MigrateReport
+	// 重试上次运行失败的文件
+	if err = m.retry(ctx, checkpoint.Failed); err != nil {
+		return &m.report, err
+	}
+	for {
+		if err = ctx.Err(); err != nil {
+			return &m.report, err
+		}
+		files, next, err := from.storage.List(opts.Prefix, cursor, migrateBatch)
+		if err != nil {
+			return &m.report, fmt.Errorf("列出源文件失败: %w", err)
+		}
+		m.copyBatch(ctx, files)
+		if ctx.Err() != nil {
+			// 本批可能有未处理的文件，不更新断点
+			return &m.report, ctx.Err()
+		}
+
+		if len(files) > 0 {
+			cursor = files[len(files)-1].Key
+		}
+		if next == "" {
+			break
+		}
+		if err = m.saveCheckpoint(cursor); err != nil {
+			return &m.report, err
+		}
+	}
+
+	// 有失败的文件时保留断点，重新运行时只重试失败的文件
+	if len(m.report.Errors) > 0 {
+		return &m.report, m.saveCheckpoint(cursor)
+	}
+	if opts.Checkpoint != "" && !opts.DryRun {
+		os.Remove(opts.Checkpoint)
+	}
+	return &m.report, nil
+}
+
+// retry 重新复制断点中失败的文件，源文件已删除的跳过
+func (m *migration) retry(ctx context.Context, keys []string) error {
+	var files []FileInfo
+	for _, key := range keys {
+		info, err := m.from.storage.Stat(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			m.record(FileInfo{Key: key}, false, err)
+			continue
+		}
+		files = append(files, *info)
+	}
+	m.copyBatch(ctx, files)
+	return ctx.Err()
+}
+
+// migration 一次迁移的状态
+type migration struct {
+	from   *service
+	to     *service
+	opts   MigrateOptions
+	mu     sync.Mutex
+	report MigrateReport
+}
+
+// copyBatch 并发复制一批文件，全部完成后返回
+func (m *migration) copyBatch(ctx context.Context, files []FileInfo) {
+	jobs := make(chan FileInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < m.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				copied, err := m.copyFile(file)
+				m.record(file, copied, err)
+			}
+		}()
+	}
+
+	for _, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- file
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func (m *migration) record(file FileInfo, copied bool, err error) {
+	m.mu.Lock()
+	switch {
+	case err != nil:
+		m.report.Errors = append(m.report.Errors, MigrateError{Key: file.Key, Err: err})
+	case copied:
+		m.report.Copied++
+		m.report.Bytes += file.Size
+	default:
+		m.report.Skipped++
+	}
+	m.mu.Unlock()
+
+	if m.opts.Progress != nil {
+		m.opts.Progress(file.Key, copied, err)
+	}
+}
+
+// copyFile 复制单个文件和文件记录，目标文件已存在且一致时返回 false
+func (m *migration) copyFile(file FileInfo) (bool, error) {
+	same, err := m.sameFile(file)
+	if err != nil {
+		return false, err
+	}
+	if same {
+		return false, m.copyRecord(file.Key)
+	}
+	if m.opts.DryRun {
+		return true, nil
+	}
+
+	info, err := m.from.storage.Stat(file.Key)
+	if err != nil {
+		return false, err
+	}
+	acl, err := m.from.FileACL(file.Key)
+	if err != nil {
+		return false, err
+	}
+	reader, err := m.from.storage.Get(file.Key)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	counter := newHashCounter(reader)
+	err = m.to.storage.Put(file.Key, counter, PutOptions{
+		ContentType:        info.ContentType,
+		ACL:                acl,
+		CacheControl:       info.CacheControl,
+		ContentDisposition: info.ContentDisposition,
+		Metadata:           info.Metadata,
+		Size:               info.Size,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if m.opts.Verify {
+		sum, err := objectHash(m.to.storage, file.Key)
+		if err != nil {
+			return false, err
+		}
+		if sum != counter.Sum() {
+			return false, fmt.Errorf("校验失败: 目标文件的 SHA-256 与源文件不一致")
+		}
+	}
+	return true, m.copyRecord(file.Key)
+}
+
+// sameFile 判断目标存储中的文件是否与源文件一致。Verify 时比较 SHA-256，
+// 否则两端都有内容的 MD5（ETag）时比较 MD5，没有时只比较大小
+func (m *migration) sameFile(file FileInfo) (bool, error) {
+	info, err := m.to.storage.Stat(file.Key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.Size != file.Size {
+		return false, nil
+	}
+	if !m.opts.Verify {
+		same, ok, err := sameContent(m.from.storage, m.to.storage, file, *info)
+		if err != nil || ok {
+			return same, err
+		}
+		return true, nil
+	}
+
+	srcSum, err := objectHash(m.from.storage, file.Key)
+	if err != nil {
+		return false, err
+	}
+	dstSum, err := objectHash(m.to.storage, file.Key)
+	if err != nil {
+		return false, err
+	}
+	return srcSum == dstSum, nil
+}
+
+// copyRecord 复制文件记录，访问地址改为新域名。衍生图等没有记录的文件跳过
+func (m *migration) copyRecord(key string) error {
+	if m.opts.DryRun || m.to.opts.repository == nil {
+		return nil
+	}
+	record, err := m.from.lookupRecord(key)
+	if err != nil || record == nil {
+		return err
+	}
+
+	moved := *record
+	moved.URL = m.to.AddDomainToURL(m.opts.NewHost, m.from.RemoveDomainFromURL(m.opts.OldHost, record.URL))
+	if err = m.to.opts.repository.Save(&moved); err != nil {
+		return fmt.Errorf("保存文件记录失败: %w", err)
+	}
+	return nil
+}
+
+// loadCheckpoint 读取断点，没有断点文件时从头开始。断点的前缀与本次迁移不同时返回错误
+func (m *migration) loadCheckpoint() (migrateCheckpoint, error) {
+	checkpoint := migrateCheckpoint{Prefix: m.opts.Prefix}
+	if m.opts.Checkpoint == "" {
+		return checkpoint, nil
+	}
+	data, err := os.ReadFile(m.opts.Checkpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return checkpoint, fmt.Errorf("读取断点文件失败: %w", err)
+	}
+	if err = json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("解析断点文件失败: %w", err)
+	}
+	if checkpoint.Prefix != m.opts.Prefix {
+		return checkpoint, kindErrorf(ErrInvalidArgument, "断点文件的前缀 %q 与迁移的前缀 %q 不一致", checkpoint.Prefix, m.opts.Prefix)
+	}
+	return checkpoint, nil
+}
+
+// saveCheckpoint 保存断点，先写入临时文件再重命名，避免中断时断点文件不完整
+func (m *migration) saveCheckpoint(cursor string) error {
+	if m.opts.Checkpoint == "" || m.opts.DryRun {
+		return nil
+	}
+	checkpoint := migrateCheckpoint{Prefix: m.opts.Prefix, Cursor: cursor, Failed: []string{}, MigrateReport: m.report}
+	for _, failed := range m.report.Errors {
+		checkpoint.Failed = append(checkpoint.Failed, failed.Key)
+	}
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("序列化断点失败: %w", err)
+	}
+	tmp := m.opts.Checkpoint + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("保存断点文件失败: %w", err)
+	}
+	if err = os.Rename(tmp, m.opts.Checkpoint); err != nil {
+		return fmt.Errorf("保存断点文件失败: %w", err)
+	}
+	return nil
+}
+
+// objectHash 读取对象并计算 SHA-256
+func objectHash(storage Storage, key string) (string, error) {
+	reader, err := storage.Get(key)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, reader); err != nil {
+		return "", fmt.Errorf("读取文件失败: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
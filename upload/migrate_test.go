@@ -0,0 +1,166 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	repository := NewMemoryFileRepository()
+	src, err := New("http://127.0.0.1:3000", "goods", WithStorage(newMemStorage()), WithFileRepository(repository),
+		WithImageVariants(ImageVariant{Name: "thumb", Width: 10, Height: 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = src.UploadFile(strings.NewReader("hello"), "a.txt", WithACL(ACLPrivate)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = src.UploadFile(strings.NewReader(string(pngBytes(t, 40, 20))), "b.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	mem := newMemStorage()
+	dstRepository := NewMemoryFileRepository()
+	dst, err := New("https://cdn.example.com", "goods", WithStorage(mem), WithFileRepository(dstRepository))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 只统计，不写入
+	report, err := Migrate(context.Background(), src, dst, MigrateOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 3 || len(mem.objects) != 0 {
+		t.Errorf("dry run: unexpected report %+v, %d objects written", report, len(mem.objects))
+	}
+
+	report, err = Migrate(context.Background(), src, dst, MigrateOptions{Verify: true, Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 3 || report.Skipped != 0 || len(report.Errors) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	for _, key := range []string{"goods/a.txt", "goods/b.png", "goods/b_thumb.png"} {
+		if _, ok := mem.objects[key]; !ok {
+			t.Errorf("%s not migrated", key)
+		}
+	}
+	record, err := dstRepository.Get("goods/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.URL != "https://cdn.example.com/goods/a.txt" || record.ACL != ACLPrivate {
+		t.Errorf("unexpected record %+v", record)
+	}
+
+	// 再次迁移时跳过已复制的文件
+	if report, err = Migrate(context.Background(), src, dst, MigrateOptions{Verify: true}); err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 0 || report.Skipped != 3 {
+		t.Errorf("expected all files skipped, got %+v", report)
+	}
+}
+
+func TestMigrateCheckpoint(t *testing.T) {
+	src, err := New("", "goods", WithStorage(newMemStorage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if _, err = src.UploadFile(strings.NewReader(name), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mem := newMemStorage()
+	failing, err := New("", "goods", WithStorage(&failingStorage{memStorage: mem, fail: func(key string) bool {
+		return key == "goods/b.txt"
+	}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := filepath.Join(t.TempDir(), "migrate.json")
+	report, err := Migrate(context.Background(), src, failing, MigrateOptions{Checkpoint: checkpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 2 || len(report.Errors) != 1 || report.Errors[0].Key != "goods/b.txt" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// 有失败的文件时保留断点
+	data, err := os.ReadFile(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved migrateCheckpoint
+	if err = json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Cursor != "goods/c.txt" || len(saved.Failed) != 1 || saved.Failed[0] != "goods/b.txt" {
+		t.Errorf("unexpected checkpoint %s", data)
+	}
+
+	// 重新运行时只重试失败的文件，完成后删除断点
+	dst, err := New("", "goods", WithStorage(mem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report, err = Migrate(context.Background(), src, dst, MigrateOptions{Checkpoint: checkpoint}); err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 3 || report.Skipped != 0 || len(report.Errors) != 0 || string(mem.objects["goods/b.txt"]) != "b.txt" {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if _, err = os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Error("checkpoint should be removed after migration")
+	}
+
+	// 断点的前缀不同时拒绝继续
+	os.WriteFile(checkpoint, []byte(`{"prefix":"other/","cursor":"other/x"}`), 0644)
+	if _, err = Migrate(context.Background(), src, dst, MigrateOptions{Prefix: "goods/", Checkpoint: checkpoint}); err == nil {
+		t.Error("mismatched checkpoint prefix should fail")
+	}
+}
+
+func TestMigrateComparesETag(t *testing.T) {
+	newLocal := func(root string) Service {
+		svc, err := New("", "goods", WithDriverParam(ParamRoot, root))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return svc
+	}
+	src, dst := newLocal(t.TempDir()), newLocal(t.TempDir())
+	if _, err := src.UploadFile(strings.NewReader("hello"), "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.UploadFile(strings.NewReader("world"), "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 大小相同、内容不同的文件不校验时也通过 MD5 发现并重新复制
+	report, err := Migrate(context.Background(), src, dst, MigrateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 1 || report.Skipped != 0 {
+		t.Errorf("expected changed file copied, got %+v", report)
+	}
+	if data, err := dst.DownloadFile("uploads/goods/a.txt"); err != nil || string(data) != "hello" {
+		t.Errorf("dst content = %q, %v", data, err)
+	}
+	if report, err = Migrate(context.Background(), src, dst, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 0 || report.Skipped != 1 {
+		t.Errorf("expected file skipped, got %+v", report)
+	}
+}
@@ -0,0 +1,544 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+// MirrorService 同时写入主存储和副存储的上传服务，读取时主存储失败回退到副存储。
+// 用于在本地存储和 OSS 之间不停机迁移：新文件写入两端，Reconcile 补齐历史文件
+type MirrorService interface {
+	Service
+	// Reconcile 对比 prefix 下两端的文件，repair 为 true 时修复不一致：
+	// 只存在于主存储的文件复制到副存储；只存在于副存储的文件复制到主存储，
+	// 已通过本服务删除的文件则从副存储删除；大小或内容的 MD5（ETag）不一致时以主存储为准
+	Reconcile(ctx context.Context, prefix string, repair bool) (*MirrorReport, error)
+	// StartReconciler 按 interval 定期执行 Reconcile 并修复，ctx 取消时停止
+	StartReconciler(ctx context.Context, interval time.Duration, prefix string)
+	// Flush 等待异步写入副存储的任务完成
+	Flush()
+	// Close 执行完队列中的异步任务后停止后台协程，之后的写入同步执行
+	Close() error
+}
+
+// MirrorReport 主存储和副存储的对比结果
+type MirrorReport struct {
+	// 对比的文件数
+	Checked int `json:"checked"`
+	// 只存在于副存储的文件，包括已删除但副存储尚未删除的文件
+	MissingPrimary []string `json:"missing_primary"`
+	// 只存在于主存储的文件
+	MissingSecondary []string `json:"missing_secondary"`
+	// 两端大小或内容的 MD5 不一致的文件
+	Mismatched []string `json:"mismatched"`
+	// 已修复的文件数
+	Repaired int `json:"repaired"`
+	// 修复失败的文件
+	Errors []MirrorError `json:"-"`
+}
+
+// MirrorError 修复单个文件失败的原因
+type MirrorError struct {
+	Key string
+	Err error
+}
+
+func (e MirrorError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+// 异步写入副存储的队列长度，队列满时上传等待
+const mirrorQueueSize = 256
+
+// 对比时每次列出的文件数
+const mirrorListBatch = 1000
+
+type mirrorService struct {
+	*service
+	mirror *mirrorStorage
+}
+
+// NewMirrorService 创建镜像上传服务，两端使用相同的 key，默认同步写入副存储。
+// primary 和 secondary 可以通过 OpenStorage 创建
+func NewMirrorService(host, dir string, primary, secondary Storage, optFns ...Option) (MirrorService, error) {
+	opts := newOptions(optFns...)
+	mirror := newMirrorStorage(primary, secondary, opts.mirrorAsync)
+
+	svc, err := newService(host, dir, append(optFns, WithStorage(mirror))...)
+	if err != nil {
+		return nil, err
+	}
+	return &mirrorService{service: svc, mirror: mirror}, nil
+}
+
+// Flush 等待异步写入副存储的任务完成
+func (s *mirrorService) Flush() {
+	s.mirror.pending.Wait()
+}
+
+// Close 停止异步写入副存储的协程
+func (s *mirrorService) Close() error {
+	return s.mirror.Close()
+}
+
+// Reconcile 按 key 的顺序同时遍历两端的文件并对比
+func (s *mirrorService) Reconcile(ctx context.Context, prefix string, repair bool) (*MirrorReport, error) {
+	report := &MirrorReport{MissingPrimary: []string{}, MissingSecondary: []string{}, Mismatched: []string{}}
+	primary := &listIterator{storage: s.mirror.primary, prefix: prefix}
+	secondary := &listIterator{storage: s.mirror.secondary, prefix: prefix}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		p, err := primary.peek()
+		if err != nil {
+			return report, fmt.Errorf("列出主存储文件失败: %w", err)
+		}
+		q, err := secondary.peek()
+		if err != nil {
+			return report, fmt.Errorf("列出副存储文件失败: %w", err)
+		}
+		if p == nil && q == nil {
+			return report, nil
+		}
+		report.Checked++
+
+		var key string
+		var src, dst Storage
+		var stray bool
+		switch {
+		case q == nil || (p != nil && p.Key < q.Key):
+			key, src, dst = p.Key, s.mirror.primary, s.mirror.secondary
+			report.MissingSecondary = append(report.MissingSecondary, key)
+			primary.next()
+		case p == nil || q.Key < p.Key:
+			key, src, dst = q.Key, s.mirror.secondary, s.mirror.primary
+			report.MissingPrimary = append(report.MissingPrimary, key)
+			secondary.next()
+			stray = s.mirror.deleted(key)
+		default:
+			primary.next()
+			secondary.next()
+			if p.Size == q.Size {
+				same, ok, err := sameContent(s.mirror.primary, s.mirror.secondary, *p, *q)
+				if err != nil {
+					report.Errors = append(report.Errors, MirrorError{Key: p.Key, Err: err})
+					continue
+				}
+				if same || !ok {
+					continue
+				}
+			}
+			key, src, dst = p.Key, s.mirror.primary, s.mirror.secondary
+			report.Mismatched = append(report.Mismatched, key)
+		}
+
+		if !repair {
+			continue
+		}
+		if stray {
+			err = s.mirror.deleteStray(key)
+		} else {
+			err = s.copyBetween(src, dst, key)
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, MirrorError{Key: key, Err: err})
+			continue
+		}
+		report.Repaired++
+	}
+}
+
+// StartReconciler 定期对比并修复，有不一致或修复失败时记录日志
+func (s *mirrorService) StartReconciler(ctx context.Context, interval time.Duration, prefix string) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				report, err := s.Reconcile(ctx, prefix, true)
+				if err != nil {
+					log.Warnf("upload: reconcile mirror: %v", err)
+				}
+				if diverged := len(report.MissingPrimary) + len(report.MissingSecondary) + len(report.Mismatched); diverged > 0 {
+					log.Warnf("upload: reconcile mirror: checked %d, diverged %d, repaired %d, errors: %v",
+						report.Checked, diverged, report.Repaired, errors.Join(mirrorErrors(report.Errors)...))
+				}
+			}
+		}
+	}()
+}
+
+func mirrorErrors(errs []MirrorError) []error {
+	result := make([]error, 0, len(errs))
+	for _, err := range errs {
+		result = append(result, err)
+	}
+	return result
+}
+
+// copyBetween 将文件从 src 复制到 dst，保留文件属性，访问权限以文件记录为准
+func (s *mirrorService) copyBetween(src, dst Storage, key string) error {
+	info, err := src.Stat(key)
+	if err != nil {
+		return err
+	}
+	acl, err := s.FileACL(key)
+	if err != nil {
+		return err
+	}
+	reader, err := src.Get(key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return dst.Put(key, reader, PutOptions{
+		ContentType:        info.ContentType,
+		ACL:                acl,
+		CacheControl:       info.CacheControl,
+		ContentDisposition: info.ContentDisposition,
+		Metadata:           info.Metadata,
+	})
+}
+
+// listIterator 按 key 的顺序逐个遍历存储中的文件
+type listIterator struct {
+	storage Storage
+	prefix  string
+	cursor  string
+	files   []FileInfo
+	done    bool
+}
+
+// peek 返回当前文件，遍历完时返回 nil
+func (it *listIterator) peek() (*FileInfo, error) {
+	for len(it.files) == 0 && !it.done {
+		files, next, err := it.storage.List(it.prefix, it.cursor, mirrorListBatch)
+		if err != nil {
+			return nil, err
+		}
+		it.files, it.cursor, it.done = files, next, next == ""
+	}
+	if len(it.files) == 0 {
+		return nil, nil
+	}
+	return &it.files[0], nil
+}
+
+func (it *listIterator) next() {
+	it.files = it.files[1:]
+}
+
+// mirrorStorage 写入主存储和副存储，读取时依次回退
+type mirrorStorage struct {
+	primary   Storage
+	secondary Storage
+	async     bool
+	jobs      chan mirrorJob
+	pending   sync.WaitGroup
+	done      chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+
+	// tombstones 已从主存储删除、副存储尚未确认删除的 key，
+	// Reconcile 据此删除副存储中的残留文件而不是复制回主存储。只保存在内存中
+	tombstonesMu sync.Mutex
+	tombstones   map[string]bool
+}
+
+// mirrorJob 异步写入副存储的任务，file 为空时删除
+type mirrorJob struct {
+	key  string
+	file *os.File
+	opts PutOptions
+}
+
+func newMirrorStorage(primary, secondary Storage, async bool) *mirrorStorage {
+	m := &mirrorStorage{primary: primary, secondary: secondary, async: async, tombstones: make(map[string]bool)}
+	if async {
+		// 单个协程按顺序执行，保证同一 key 的写入和删除顺序不变
+		m.jobs = make(chan mirrorJob, mirrorQueueSize)
+		m.done = make(chan struct{})
+		go m.run()
+	}
+	return m
+}
+
+func (m *mirrorStorage) run() {
+	defer close(m.done)
+	for job := range m.jobs {
+		var err error
+		if job.file != nil {
+			err = m.secondary.Put(job.key, job.file, job.opts)
+			job.file.Close()
+			os.Remove(job.file.Name())
+		} else {
+			err = m.deleteSecondary(job.key)
+		}
+		if err != nil {
+			log.Warnf("upload: mirror %s to secondary storage: %v", job.key, err)
+		}
+		m.pending.Done()
+	}
+}
+
+// enqueue 提交异步任务，已关闭时返回 false，由调用方同步执行
+func (m *mirrorStorage) enqueue(job mirrorJob) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return false
+	}
+	m.pending.Add(1)
+	m.jobs <- job
+	return true
+}
+
+// Close 关闭任务队列并等待后台协程执行完剩余任务，可以重复调用
+func (m *mirrorStorage) Close() error {
+	if !m.async {
+		return nil
+	}
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.jobs)
+	}
+	m.mu.Unlock()
+	<-m.done
+	return nil
+}
+
+func (m *mirrorStorage) setDeleted(key string, deleted bool) {
+	m.tombstonesMu.Lock()
+	defer m.tombstonesMu.Unlock()
+	if deleted {
+		m.tombstones[key] = true
+	} else {
+		delete(m.tombstones, key)
+	}
+}
+
+// deleted 返回 key 是否已从主存储删除而副存储尚未删除
+func (m *mirrorStorage) deleted(key string) bool {
+	m.tombstonesMu.Lock()
+	defer m.tombstonesMu.Unlock()
+	return m.tombstones[key]
+}
+
+// deleteSecondary 删除副存储中的文件，成功后清除删除标记
+func (m *mirrorStorage) deleteSecondary(key string) error {
+	if err := m.secondary.Delete(key); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	m.setDeleted(key, false)
+	return nil
+}
+
+// deleteStray 删除副存储中已删除文件的残留，主存储中已重新写入时保留
+func (m *mirrorStorage) deleteStray(key string) error {
+	if _, err := m.primary.Stat(key); err == nil {
+		m.setDeleted(key, false)
+		return nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return m.deleteSecondary(key)
+}
+
+// Put 写入主存储的同时保存到临时文件，再写入副存储。
+// 同步写入副存储失败时，新文件从主存储删除；覆盖已有文件时保留主存储中的新内容并返回错误，由 Reconcile 修复
+func (m *mirrorStorage) Put(key string, reader io.Reader, opts PutOptions) error {
+	_, statErr := m.primary.Stat(key)
+	if statErr != nil && !errors.Is(statErr, ErrNotFound) {
+		return statErr
+	}
+	exists := statErr == nil
+
+	spool, err := os.CreateTemp("", TempPrefix+"mirror_*")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	cleanup := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+
+	if err = m.primary.Put(key, io.TeeReader(reader, spool), opts); err != nil {
+		cleanup()
+		return err
+	}
+	m.setDeleted(key, false)
+	if _, err = spool.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		if !exists {
+			m.primary.Delete(key)
+		}
+		return fmt.Errorf("读取临时文件失败: %w", err)
+	}
+
+	if m.async && m.enqueue(mirrorJob{key: key, file: spool, opts: opts}) {
+		return nil
+	}
+	defer cleanup()
+	if err = m.secondary.Put(key, spool, opts); err != nil {
+		if !exists {
+			m.primary.Delete(key)
+		}
+		return fmt.Errorf("写入副存储失败: %w", err)
+	}
+	return nil
+}
+
+// Get 读取文件，主存储失败时读取副存储
+func (m *mirrorStorage) Get(key string) (io.ReadCloser, error) {
+	return fallback(m, func(storage Storage) (io.ReadCloser, error) {
+		return storage.Get(key)
+	})
+}
+
+// GetRange 按范围读取文件，主存储失败时读取副存储
+func (m *mirrorStorage) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	return fallback(m, func(storage Storage) (io.ReadCloser, error) {
+		return getRange(storage, key, offset, length)
+	})
+}
+
+// Stat 获取文件信息，主存储失败时查询副存储
+func (m *mirrorStorage) Stat(key string) (*FileInfo, error) {
+	return fallback(m, func(storage Storage) (*FileInfo, error) {
+		return storage.Stat(key)
+	})
+}
+
+// objectACL 读取驱动保存的访问权限，主存储失败时查询副存储
+func (m *mirrorStorage) objectACL(key string) (ACL, error) {
+	return fallback(m, func(storage Storage) (ACL, error) {
+		if aclStorage, ok := storage.(aclStorage); ok {
+			return aclStorage.objectACL(key)
+		}
+		return ACLDefault, nil
+	})
+}
+
+// Delete 删除两端的文件，只存在于一端时同样删除成功
+func (m *mirrorStorage) Delete(key string) error {
+	err := m.primary.Delete(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err == nil {
+		m.setDeleted(key, true)
+		if m.async && m.enqueue(mirrorJob{key: key}) {
+			return nil
+		}
+	}
+
+	secondaryErr := m.secondary.Delete(key)
+	if secondaryErr == nil || errors.Is(secondaryErr, ErrNotFound) {
+		m.setDeleted(key, false)
+	}
+	if err == nil && errors.Is(secondaryErr, ErrNotFound) {
+		return nil
+	}
+	return secondaryErr
+}
+
+// List 合并两端的文件列表，同一 key 以主存储为准
+func (m *mirrorStorage) List(prefix, cursor string, limit int) ([]FileInfo, string, error) {
+	primary, primaryNext, err := m.primary.List(prefix, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	secondary, secondaryNext, err := m.secondary.List(prefix, cursor, limit)
+	if err != nil {
+		// 副存储不可用时只返回主存储的文件
+		return primary, primaryNext, nil
+	}
+
+	seen := make(map[string]bool, len(primary))
+	files := append([]FileInfo{}, primary...)
+	for _, file := range primary {
+		seen[file.Key] = true
+	}
+	for _, file := range secondary {
+		if !seen[file.Key] {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Key < files[j].Key
+	})
+
+	// 没有列完的一端在最后一个 key 之后可能还有文件，只返回两端都已列出的范围
+	var bound string
+	for _, page := range []struct {
+		files []FileInfo
+		next  string
+	}{{primary, primaryNext}, {secondary, secondaryNext}} {
+		if page.next != "" && len(page.files) > 0 {
+			if last := page.files[len(page.files)-1].Key; bound == "" || last < bound {
+				bound = last
+			}
+		}
+	}
+	truncated := bound != ""
+	if truncated {
+		n := sort.Search(len(files), func(i int) bool { return files[i].Key > bound })
+		files = files[:n]
+	}
+	if limit > 0 && len(files) > limit {
+		files, truncated = files[:limit], true
+	}
+
+	var next string
+	if truncated && len(files) > 0 {
+		next = files[len(files)-1].Key
+	}
+	return files, next, nil
+}
+
+// fallback 依次在主存储和副存储上执行 fn，都失败时返回主存储的错误
+func fallback[T any](m *mirrorStorage, fn func(Storage) (T, error)) (T, error) {
+	result, err := fn(m.primary)
+	if err == nil {
+		return result, nil
+	}
+	if result, secondaryErr := fn(m.secondary); secondaryErr == nil {
+		return result, nil
+	}
+	return result, err
+}
+
+// getRange 按范围读取，驱动不支持时读取整个文件后跳过 offset
+func getRange(storage Storage, key string, offset, length int64) (io.ReadCloser, error) {
+	if rangeStorage, ok := storage.(RangeStorage); ok {
+		return rangeStorage.GetRange(key, offset, length)
+	}
+	reader, err := storage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.CopyN(io.Discard, reader, offset); err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(reader, length), reader}, nil
+}
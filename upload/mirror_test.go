@@ -0,0 +1,255 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMirrorWrite(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		primary, secondary := newMemStorage(), newMemStorage()
+		optFns := []Option{WithFileRepository(NewMemoryFileRepository())}
+		if async {
+			optFns = append(optFns, WithMirrorAsync())
+		}
+		svc, err := NewMirrorService("", "docs", primary, secondary, optFns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fileURL, err := svc.UploadFile(strings.NewReader("hello"), "a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		svc.Flush()
+		for name, storage := range map[string]*memStorage{"primary": primary, "secondary": secondary} {
+			if string(storage.objects["docs/a.txt"]) != "hello" {
+				t.Errorf("async=%v: %s storage missing file", async, name)
+			}
+		}
+
+		if err = svc.DeleteFile(fileURL); err != nil {
+			t.Fatal(err)
+		}
+		svc.Flush()
+		if len(primary.objects) != 0 || len(secondary.objects) != 0 {
+			t.Errorf("async=%v: file not deleted from both storages", async)
+		}
+	}
+
+	// 同步写入副存储失败时上传失败，主存储不保留文件
+	primary := newMemStorage()
+	svc, err := NewMirrorService("", "docs", primary, &failingStorage{memStorage: newMemStorage(), fail: func(string) bool { return true }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("hello"), "a.txt"); err == nil {
+		t.Error("secondary write failure should fail the upload")
+	}
+	if len(primary.objects) != 0 {
+		t.Error("primary should be rolled back")
+	}
+
+	// 覆盖已有文件时副存储写入失败，主存储保留新内容
+	primary, secondary := newMemStorage(), &failingStorage{memStorage: newMemStorage(), fail: func(string) bool { return false }}
+	mirror := newMirrorStorage(primary, secondary, false)
+	if err = mirror.Put("docs/a.txt", strings.NewReader("v1"), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	secondary.fail = func(string) bool { return true }
+	if err = mirror.Put("docs/a.txt", strings.NewReader("v2"), PutOptions{}); err == nil {
+		t.Error("secondary write failure should be returned")
+	}
+	if string(primary.objects["docs/a.txt"]) != "v2" {
+		t.Errorf("primary should keep the overwritten file, got %q", primary.objects["docs/a.txt"])
+	}
+}
+
+func TestMirrorClose(t *testing.T) {
+	primary, secondary := newMemStorage(), newMemStorage()
+	svc, err := NewMirrorService("", "docs", primary, secondary, WithMirrorAsync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("hello"), "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err = svc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(secondary.objects["docs/a.txt"]) != "hello" {
+		t.Error("queued write should finish before Close returns")
+	}
+
+	// 关闭后同步写入副存储
+	if _, err = svc.UploadFile(strings.NewReader("world"), "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if string(secondary.objects["docs/b.txt"]) != "world" {
+		t.Error("write after Close should be synchronous")
+	}
+	if err = svc.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMirrorReadFallback(t *testing.T) {
+	primary, secondary := newMemStorage(), newMemStorage()
+	svc, err := NewMirrorService("", "docs", primary, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondary.objects["docs/old.txt"] = []byte("0123456789")
+
+	data, err := svc.DownloadFile("docs/old.txt")
+	if err != nil || string(data) != "0123456789" {
+		t.Fatalf("fallback read failed: %q %v", data, err)
+	}
+	file, err := svc.OpenFile("docs/old.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err = file.Seek(4, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if rest, _ := io.ReadAll(file); string(rest) != "456789" {
+		t.Errorf("unexpected range read %q", rest)
+	}
+	if _, err = svc.DownloadFile("docs/missing.txt"); err == nil {
+		t.Error("missing file should fail")
+	}
+}
+
+func TestMirrorList(t *testing.T) {
+	primary, secondary := newMemStorage(), newMemStorage()
+	svc, err := NewMirrorService("", "docs", primary, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"docs/a", "docs/c", "docs/e"} {
+		primary.objects[key] = []byte("p")
+	}
+	for _, key := range []string{"docs/b", "docs/c", "docs/d", "docs/f"} {
+		secondary.objects[key] = []byte("s")
+	}
+
+	var keys []string
+	cursor := ""
+	for {
+		files, next, err := svc.List("docs/", cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			keys = append(keys, file.Key)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	want := []string{"docs/a", "docs/b", "docs/c", "docs/d", "docs/e", "docs/f"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("expected %v, got %v", want, keys)
+	}
+}
+
+func TestMirrorReconcile(t *testing.T) {
+	primary, secondary := newMemStorage(), newMemStorage()
+	svc, err := NewMirrorService("", "docs", primary, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary.objects["docs/both"] = []byte("same")
+	secondary.objects["docs/both"] = []byte("same")
+	primary.objects["docs/new"] = []byte("new")
+	secondary.objects["docs/old"] = []byte("old")
+	primary.objects["docs/changed"] = []byte("v2 content")
+	secondary.objects["docs/changed"] = []byte("v1")
+
+	report, err := svc.Reconcile(context.Background(), "docs/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 4 || report.Repaired != 0 ||
+		!reflect.DeepEqual(report.MissingSecondary, []string{"docs/new"}) ||
+		!reflect.DeepEqual(report.MissingPrimary, []string{"docs/old"}) ||
+		!reflect.DeepEqual(report.Mismatched, []string{"docs/changed"}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if _, ok := secondary.objects["docs/new"]; ok {
+		t.Error("dry run should not repair")
+	}
+
+	if report, err = svc.Reconcile(context.Background(), "docs/", true); err != nil {
+		t.Fatal(err)
+	}
+	if report.Repaired != 3 || len(report.Errors) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if string(secondary.objects["docs/new"]) != "new" || string(primary.objects["docs/old"]) != "old" ||
+		string(secondary.objects["docs/changed"]) != "v2 content" {
+		t.Error("divergence not repaired")
+	}
+
+	if report, err = svc.Reconcile(context.Background(), "docs/", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.MissingPrimary)+len(report.MissingSecondary)+len(report.Mismatched) != 0 {
+		t.Errorf("storages should be in sync, got %+v", report)
+	}
+}
+
+// failingDeleteStorage 删除文件失败
+type failingDeleteStorage struct {
+	*memStorage
+	fail bool
+}
+
+func (f *failingDeleteStorage) Delete(key string) error {
+	if f.fail {
+		return errors.New("rejected by storage")
+	}
+	return f.memStorage.Delete(key)
+}
+
+func TestMirrorReconcileDeleted(t *testing.T) {
+	primary, secondary := newMemStorage(), &failingDeleteStorage{memStorage: newMemStorage()}
+	svc, err := NewMirrorService("", "docs", primary, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileURL, err := svc.UploadFile(strings.NewReader("hello"), "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondary.fail = true
+	if err = svc.DeleteFile(fileURL); err == nil {
+		t.Error("secondary delete failure should be returned")
+	}
+	secondary.fail = false
+	secondary.objects["docs/old"] = []byte("old")
+
+	// 已删除文件的残留从副存储删除，历史文件仍然复制到主存储
+	report, err := svc.Reconcile(context.Background(), "docs/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Repaired != 2 || len(report.Errors) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if _, ok := primary.objects["docs/a.txt"]; ok {
+		t.Error("deleted file should not be copied back to primary")
+	}
+	if _, ok := secondary.objects["docs/a.txt"]; ok {
+		t.Error("stray secondary file should be deleted")
+	}
+	if string(primary.objects["docs/old"]) != "old" {
+		t.Error("secondary-only file should be copied to primary")
+	}
+}
@@ -1,5 +1,12 @@
 package upload
 
+import (
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
 // oss 配置
 type ossConfig struct {
 	// OSS endpoint
@@ -16,18 +23,73 @@ type ossConfig struct {
 
 type options struct {
 	ossConfig
+
+	// 存储驱动名称，默认为本地存储
+	driver string
+	// 存储驱动自定义参数
+	driverParams map[string]string
+	// 直接指定的存储驱动，优先于 driver
+	storage Storage
+	// 对象 key 的根目录，为 nil 时本地驱动使用 DefaultUploadDir，其他驱动为空
+	baseDir *string
+	// 按内容寻址去重
+	contentAddressed bool
+	// 图片衍生图规格
+	imageVariants []ImageVariant
+	// 文件记录仓库，为 nil 时不记录
+	repository FileRepository
+	// 配额用量存储，为 nil 时不限制
+	quotaStore  QuotaStore
+	quotaLimits QuotaLimitsFunc
+	// 解压模型压缩包的限制
+	extractLimits ExtractLimits
+	// 解压模型压缩包时并发上传的文件数
+	extractConcurrency int
+	// 文件默认的访问权限
+	defaultACL ACL
+	// 签名地址的 HMAC 密钥
+	signingKey []byte
+	// 镜像存储异步写入副存储
+	mirrorAsync bool
+	// 生成文件名的策略，为 nil 时使用 FlatKeys
+	keyStrategy KeyStrategy
+	// Sweep 时清理残留文件的目录
+	tempDirs []tempDir
+}
+
+// tempDir Sweep 时清理的目录，文件超过 maxAge 未修改时删除
+type tempDir struct {
+	dir    string
+	maxAge time.Duration
 }
 
 type Option func(*options)
 
 func newOptions(optFns ...Option) options {
-	opts := options{}
+	opts := options{
+		driver:             DriverLocal,
+		driverParams:       make(map[string]string),
+		extractLimits:      DefaultExtractLimits,
+		extractConcurrency: defaultExtractConcurrency,
+	}
 	for _, opt := range optFns {
 		opt(&opts)
 	}
 	return opts
 }
 
+// driverConfig 生成存储驱动配置
+func (o options) driverConfig() DriverConfig {
+	return DriverConfig{
+		Endpoint:        o.endpoint,
+		AccessKeyId:     o.accessKeyId,
+		AccessKeySecret: o.accessKeySecret,
+		BucketName:      o.bucketName,
+		BaseUrl:         o.baseUrl,
+		Params:          o.driverParams,
+	}
+}
+
 // 设置 OSS 配置
 func WithOssConfig(endpoint, accessKeyId, accessKeySecret, bucketName, baseUrl string) Option {
 	return func(o *options) {
@@ -38,3 +100,284 @@ func WithOssConfig(endpoint, accessKeyId, accessKeySecret, bucketName, baseUrl s
 		o.baseUrl = baseUrl
 	}
 }
+
+// 设置 S3 兼容存储配置，endpoint 例如 http://127.0.0.1:9000，region 为空时使用 us-east-1
+func WithS3Config(endpoint, region, accessKeyId, accessKeySecret, bucketName string) Option {
+	return func(o *options) {
+		o.endpoint = endpoint
+		o.accessKeyId = accessKeyId
+		o.accessKeySecret = accessKeySecret
+		o.bucketName = bucketName
+		o.driverParams[ParamRegion] = region
+	}
+}
+
+// 设置 S3 对象 ACL，例如 public-read，为空时使用存储桶默认策略
+func WithS3ACL(acl string) Option {
+	return func(o *options) {
+		o.driverParams[ParamACL] = acl
+	}
+}
+
+// 设置 OSS 分片上传，超过 threshold 字节的文件按 partSize 分片，concurrency 个分片并发上传。
+// 上传失败时断点保存在 checkpointDir，再次上传相同内容到同一目录时跳过已上传的分片，
+// 文件名不同（例如客户端重试时 GenerateUniqueFilename 生成了新文件名）也可以续传。参数为零值时使用默认值
+func WithOssMultipart(threshold, partSize int64, concurrency int, checkpointDir string) Option {
+	return func(o *options) {
+		if threshold > 0 {
+			o.driverParams[ParamMultipartThreshold] = strconv.FormatInt(threshold, 10)
+		}
+		if partSize > 0 {
+			o.driverParams[ParamPartSize] = strconv.FormatInt(partSize, 10)
+		}
+		if concurrency > 0 {
+			o.driverParams[ParamPartConcurrency] = strconv.Itoa(concurrency)
+		}
+		if checkpointDir != "" {
+			o.driverParams[ParamCheckpointDir] = checkpointDir
+		}
+	}
+}
+
+// 设置 GenerateUniqueFilename 生成文件名的策略，例如 DateKeys(nil)、HashPrefixKeys(2, nil)、
+// UserKeys(DateKeys(OriginalNameKeys))，默认为 FlatKeys
+func WithKeyStrategy(strategy KeyStrategy) Option {
+	return func(o *options) {
+		o.keyStrategy = strategy
+	}
+}
+
+// 设置存储驱动，驱动需通过 RegisterDriver 注册
+func WithDriver(name string) Option {
+	return func(o *options) {
+		o.driver = name
+	}
+}
+
+// 设置存储驱动自定义参数，例如本地驱动的 root
+func WithDriverParam(key, value string) Option {
+	return func(o *options) {
+		o.driverParams[key] = value
+	}
+}
+
+// 直接指定存储驱动实例
+func WithStorage(storage Storage) Option {
+	return func(o *options) {
+		o.storage = storage
+	}
+}
+
+// 设置对象 key 的根目录
+func WithBaseDir(dir string) Option {
+	return func(o *options) {
+		o.baseDir = &dir
+	}
+}
+
+// 开启按内容寻址去重，文件以内容的 SHA-256 命名，相同内容只保存一份。
+// 同一对象可能被多个上传者引用，配置 WithFileRepository 时按上传者计数引用，否则不能删除文件；
+// 每个引用有各自的过期时间，重复上传时不能修改已有对象的访问权限
+func WithContentAddressed() Option {
+	return func(o *options) {
+		o.contentAddressed = true
+	}
+}
+
+// 上传图片时生成衍生图，例如 WithImageVariants(DefaultImageVariants...)。
+// 衍生图与原图保存在同一目录，文件名为原文件名加 _<名称> 后缀，可通过 DerivativeURLs 获取地址
+func WithImageVariants(variants ...ImageVariant) Option {
+	return func(o *options) {
+		o.imageVariants = append(o.imageVariants, variants...)
+	}
+}
+
+// 设置文件记录仓库，上传和删除文件时同步更新记录
+func WithFileRepository(repository FileRepository) Option {
+	return func(o *options) {
+		o.repository = repository
+	}
+}
+
+// 设置存储配额，只对设置了 WithOwner 的上传生效，需要同时配置 WithFileRepository，
+// 例如每个用户最多 1GB、1000 个文件：
+//
+//	WithQuota(NewMemoryQuotaStore(), StaticQuota(Quota{MaxBytes: 1 << 30, MaxFiles: 1000}))
+func WithQuota(store QuotaStore, quota QuotaFunc) Option {
+	return WithQuotaLimits(store, func(owner string) []QuotaLimit {
+		principal, q := quota(owner)
+		return []QuotaLimit{{Principal: principal, Quota: q}}
+	})
+}
+
+// 设置多个计费主体的存储配额，上传需要同时满足所有主体的配额，例如用户和所属租户：
+//
+//	WithQuotaLimits(NewMemoryQuotaStore(), func(owner string) []QuotaLimit {
+//		return []QuotaLimit{{Principal: "user:" + owner, Quota: userQuota}, {Principal: "tenant:" + tenantOf(owner), Quota: tenantQuota}}
+//	})
+func WithQuotaLimits(store QuotaStore, limits QuotaLimitsFunc) Option {
+	return func(o *options) {
+		o.quotaStore = store
+		o.quotaLimits = limits
+	}
+}
+
+// 设置解压模型压缩包的限制，默认为 DefaultExtractLimits
+func WithExtractLimits(limits ExtractLimits) Option {
+	return func(o *options) {
+		o.extractLimits = limits
+	}
+}
+
+// 设置解压模型压缩包时并发上传的文件数，默认为 4，小于 1 时按 1 处理
+func WithExtractConcurrency(n int) Option {
+	return func(o *options) {
+		o.extractConcurrency = max(n, 1)
+	}
+}
+
+// 设置文件默认的访问权限，设置为 ACLPrivate 时所有文件只能通过签名地址访问
+func WithDefaultACL(acl ACL) Option {
+	return func(o *options) {
+		o.defaultACL = acl
+	}
+}
+
+// 设置 Sweep 时需要清理残留文件的目录，超过 maxAge 未修改的文件会被删除，为 0 时不清理。
+// maxAge 应不小于该目录中文件的有效期。krs/server 的 tus 上传目录按 WithTusExpiration 自行清理，不需要设置
+func WithTempDir(dir string, maxAge time.Duration) Option {
+	return func(o *options) {
+		if maxAge > 0 {
+			o.tempDirs = append(o.tempDirs, tempDir{dir: dir, maxAge: maxAge})
+		}
+	}
+}
+
+// 设置签名地址的 HMAC 密钥，用于不支持原生签名地址的存储（例如本地存储），由 StaticFileRead 校验
+func WithSigningKey(key []byte) Option {
+	return func(o *options) {
+		o.signingKey = key
+	}
+}
+
+// 镜像存储（NewMirrorService）异步写入副存储，上传只等待主存储写入完成。
+// 副存储写入失败时只记录日志，由 Reconcile 修复
+func WithMirrorAsync() Option {
+	return func(o *options) {
+		o.mirrorAsync = true
+	}
+}
+
+// 单次上传的选项
+type uploadOptions struct {
+	// 上传者，例如用户 ID 或租户 ID
+	owner string
+	// 原文件名，默认为上传时的文件名
+	originalName string
+	// 临时文件的有效期，为 0 时为永久文件
+	ttl time.Duration
+	// 解压模型压缩包时保留目录结构
+	preserveLayout bool
+	// 访问权限，为 ACLDefault 时使用 WithDefaultACL 设置的权限
+	acl ACL
+	// 为空时根据文件内容和扩展名识别
+	contentType  string
+	cacheControl string
+	// inline 或 attachment，文件名为原文件名
+	disposition string
+	metadata    map[string]string
+	tags        map[string]string
+	// 内容长度，为 0 时尽量从 reader 获取
+	size int64
+}
+
+type UploadOption func(*uploadOptions)
+
+func newUploadOptions(name string, optFns ...UploadOption) uploadOptions {
+	opts := uploadOptions{
+		originalName: path.Base(filepath.ToSlash(name)),
+	}
+	for _, opt := range optFns {
+		opt(&opts)
+	}
+	return opts
+}
+
+// 设置上传者，记录在文件记录中
+func WithOwner(owner string) UploadOption {
+	return func(o *uploadOptions) {
+		o.owner = owner
+	}
+}
+
+// 设置原文件名，上传时通常使用生成的唯一文件名，原文件名记录在文件记录中
+func WithOriginalName(name string) UploadOption {
+	return func(o *uploadOptions) {
+		o.originalName = name
+	}
+}
+
+// 上传为临时文件，超过有效期未调用 CommitFile 时由 Sweep 删除，需要配置 WithFileRepository
+func WithTTL(ttl time.Duration) UploadOption {
+	return func(o *uploadOptions) {
+		o.ttl = ttl
+	}
+}
+
+// 解压模型压缩包时保留目录结构，所有文件保存在本次上传单独的目录下，
+// 模型中的相对路径引用无需映射即可访问，贴图映射的 Source 为压缩包中的完整相对路径。
+// 只对 ExtractAndSaveModel3D 有效，不支持内容寻址
+func WithPreserveLayout() UploadOption {
+	return func(o *uploadOptions) {
+		o.preserveLayout = true
+	}
+}
+
+// 设置文件的访问权限，私有文件只能通过 SignURL 生成的签名地址访问
+func WithACL(acl ACL) UploadOption {
+	return func(o *uploadOptions) {
+		o.acl = acl
+	}
+}
+
+// 设置 Cache-Control，默认为驱动的缓存策略（OSS 和本地存储为缓存一年）
+func WithCacheControl(cacheControl string) UploadOption {
+	return func(o *uploadOptions) {
+		o.cacheControl = cacheControl
+	}
+}
+
+// 设置 Content-Disposition，disposition 为 DispositionInline 或 DispositionAttachment，文件名为原文件名
+func WithContentDisposition(disposition string) UploadOption {
+	return func(o *uploadOptions) {
+		o.disposition = disposition
+	}
+}
+
+// 设置 Content-Type，默认根据文件内容和扩展名识别
+func WithContentType(contentType string) UploadOption {
+	return func(o *uploadOptions) {
+		o.contentType = contentType
+	}
+}
+
+// 设置自定义元数据，OSS 和 S3 保存为 x-oss-meta-* 和 x-amz-meta-*，key 建议只使用小写字母、数字和 -
+func WithMetadata(metadata map[string]string) UploadOption {
+	return func(o *uploadOptions) {
+		o.metadata = metadata
+	}
+}
+
+// 设置对象标签
+func WithTags(tags map[string]string) UploadOption {
+	return func(o *uploadOptions) {
+		o.tags = tags
+	}
+}
+
+// 设置内容长度，例如 multipart 表单中的文件大小。S3 等需要预先知道长度的驱动可以直接上传，无需先缓存到临时文件
+func WithSize(size int64) UploadOption {
+	return func(o *uploadOptions) {
+		o.size = size
+	}
+}
@@ -4,22 +4,32 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"path"
+	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 )
 
+func init() {
+	RegisterDriver(DriverOss, newOssStorage)
+}
+
 // OssService 是OSS服务的接口
 type OssService interface {
 	Service
 	SetBucketCORS(rules ...oss.CORSRule) error
+	PresignPut(opts PresignOptions, uploadOpts ...UploadOption) (*PresignedUpload, error)
+	PresignPost(opts PresignOptions, uploadOpts ...UploadOption) (*PresignedUpload, error)
+	VerifyUpload(token, owner string) (string, *FileInfo, error)
+	AbortStaleUploads(olderThan time.Duration) (int, error)
 }
 
-// 默认CORS规则
+// 默认CORS规则，允许浏览器通过 PresignPut、PresignPost 直传
 var defaultCorsRule = oss.CORSRule{
 	AllowedOrigin: []string{"*"},
-	AllowedMethod: []string{"GET", "HEAD"},
+	AllowedMethod: []string{"GET", "HEAD", "PUT", "POST"},
 	AllowedHeader: []string{"*"},
 	ExposeHeader:  []string{"ETag", "Content-Length", "Content-Type"},
 	MaxAgeSeconds: 86400,
@@ -27,162 +37,314 @@ var defaultCorsRule = oss.CORSRule{
 
 type ossService struct {
 	*service
-	client *oss.Client
-	bucket *oss.Bucket
+	storage *ossStorage
 }
 
 func NewOssService(host, dir string, optFns ...Option) (OssService, error) {
-	ossSvc := &ossService{
-		service: newService(host, dir, optFns...),
+	svc, err := newService(host, dir, append(optFns, WithDriver(DriverOss))...)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, ok := svc.storage.(*ossStorage)
+	if !ok {
+		return nil, fmt.Errorf("oss service requires the %q storage driver", DriverOss)
+	}
+
+	return &ossService{
+		service: svc,
+		storage: storage,
+	}, nil
+}
+
+/*
+SetBucketCORS 设置CORS配置
+
+例子：
+
+	var rule1 = oos.CORSRule{
+		AllowedOrigin: []string{"*"},
+		AllowedMethod: []string{"GET", "HEAD"},
+		AllowedHeader: []string{"*"},
+		ExposeHeader:  []string{"ETag", "Content-Length", "Content-Type"},
+		MaxAgeSeconds: 86400,
+	}
+
+	var rule2 = oos.CORSRule{
+		AllowedOrigin: []string{"http://www.a.com", "http://www.b.com"},
+		AllowedMethod: []string{"GET"},
+		AllowedHeader: []string{"Authorization"},
+		ExposeHeader:  []string{"x-oss-test", "x-oss-test1"},
+		MaxAgeSeconds: 200,
 	}
+*/
+func (s *ossService) SetBucketCORS(rules ...oss.CORSRule) error {
+	// 为空使用默认的
+	if len(rules) == 0 {
+		rules = append(rules, defaultCorsRule)
+	}
+
+	return s.storage.client.SetBucketCORS(s.storage.bucket.BucketName, rules)
+}
 
+// ossStorage 阿里云 OSS 存储
+type ossStorage struct {
+	client *oss.Client
+	bucket *oss.Bucket
+	// 直传签名需要
+	accessKeyId     string
+	accessKeySecret string
+	baseUrl         string
+	// 大文件分片上传
+	multipart ossMultipart
+}
+
+func newOssStorage(cfg DriverConfig) (Storage, error) {
 	// 验证OSS配置的完整性
-	err := ossSvc.validateOSSConfig(ossSvc.opts)
+	if err := validateOSSConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	multipart, err := newOssMultipart(cfg.Params)
 	if err != nil {
 		return nil, err
 	}
 
 	// 创建OSS客户端
-	client, err := oss.New(ossSvc.opts.endpoint, ossSvc.opts.accessKeyId, ossSvc.opts.accessKeySecret)
+	client, err := oss.New(cfg.Endpoint, cfg.AccessKeyId, cfg.AccessKeySecret)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create OSS client: %v", err)
 	}
-	ossSvc.client = client
 
 	// 获取存储桶
-	bucket, err := client.Bucket(ossSvc.opts.bucketName)
+	bucket, err := client.Bucket(cfg.BucketName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get OSS bucket '%s': %v", ossSvc.opts.bucketName, err)
+		return nil, fmt.Errorf("failed to get OSS bucket '%s': %v", cfg.BucketName, err)
 	}
-	ossSvc.bucket = bucket
 
-	return ossSvc, nil
+	return &ossStorage{
+		client:          client,
+		bucket:          bucket,
+		accessKeyId:     cfg.AccessKeyId,
+		accessKeySecret: cfg.AccessKeySecret,
+		baseUrl:         strings.TrimRight(cfg.BaseUrl, "/"),
+		multipart:       multipart,
+	}, nil
 }
 
 // 验证OSS配置的完整性
-func (s *ossService) validateOSSConfig(opts options) error {
-	if opts.endpoint == "" {
+func validateOSSConfig(cfg DriverConfig) error {
+	if cfg.Endpoint == "" {
 		return fmt.Errorf("endpoint is required")
 	}
-	if opts.accessKeyId == "" {
+	if cfg.AccessKeyId == "" {
 		return fmt.Errorf("access_key_id is required")
 	}
-	if opts.accessKeySecret == "" {
+	if cfg.AccessKeySecret == "" {
 		return fmt.Errorf("access_key_secret is required")
 	}
-	if opts.bucketName == "" {
+	if cfg.BucketName == "" {
 		return fmt.Errorf("bucket_name is required")
 	}
-	if opts.baseUrl == "" {
+	if cfg.BaseUrl == "" {
 		return fmt.Errorf("base_url is required")
 	}
 	// 检查 endpoint 和 base_url 是否匹配
-	if !strings.Contains(opts.baseUrl, opts.endpoint) {
+	if !strings.Contains(cfg.BaseUrl, cfg.Endpoint) {
 		return fmt.Errorf("endpoint '%s' and base_url '%s' region mismatch - they should use the same region",
-			opts.endpoint, opts.baseUrl)
+			cfg.Endpoint, cfg.BaseUrl)
 	}
 
 	return nil
 }
 
-// UploadFile 上传文件到OSS或本地存储
-func (s *ossService) UploadFile(reader io.Reader, name string) (string, error) {
-	// 拼接文件路径
-	filename := s.joinPath(s.dir, name)
+// Put 上传文件到OSS
+func (o *ossStorage) Put(key string, reader io.Reader, opts PutOptions) error {
+	// 上传文件到OSS，大文件分片上传
+	return o.put(key, reader, ossPutOptions(opts))
+}
+
+// ossPutOptions 将写入选项转换为 OSS 的请求选项
+func ossPutOptions(opts PutOptions) []oss.Option {
+	// 默认为公共读，私有文件只允许通过签名地址访问，CDN 等共享缓存不能缓存
+	acl, cacheControl := oss.ACLPublicRead, "public, max-age=31536000" // 1年缓存
+	if opts.ACL == ACLPrivate {
+		acl, cacheControl = oss.ACLPrivate, "private, max-age=31536000"
+	}
+	if opts.CacheControl != "" {
+		cacheControl = opts.CacheControl
+	}
+	// 默认浏览器内联显示而不是下载
+	disposition := DispositionInline
+	if opts.ContentDisposition != "" {
+		disposition = opts.ContentDisposition
+	}
 
 	// 设置上传选项
-	opts := []oss.Option{
-		oss.ContentType(s.GetContentType(filename)),
-		oss.ObjectACL(oss.ACLPublicRead), // 设置为公共读
+	ossOpts := []oss.Option{
+		oss.ContentType(opts.ContentType),
+		oss.ObjectACL(acl),
 		// 添加缓存控制，允许浏览器缓存
-		oss.CacheControl("public, max-age=31536000"), // 1年缓存
+		oss.CacheControl(cacheControl),
 		// 添加 CORS 相关头部（虽然主要的 CORS 配置需要在控制台设置）
-		oss.ContentDisposition("inline"), // 浏览器内联显示而不是下载
+		oss.ContentDisposition(disposition),
 	}
-
-	// 上传文件到OSS
-	err := s.bucket.PutObject(filename, reader, opts...)
-	if err != nil {
-		// 解析OSS错误，提供更详细的错误信息
-		var ossErr oss.ServiceError
-		if errors.As(err, &ossErr) {
-			switch ossErr.Code {
-			case "AccessDenied":
-				if strings.Contains(ossErr.Message, "endpoint") {
-					return "", fmt.Errorf("region mismatch: bucket is in different region than configured endpoint. Please check your OSS configuration. Error: %s", ossErr.Message)
-				}
-				return "", fmt.Errorf("access denied: insufficient permissions to upload file. Error: %s", ossErr.Message)
-			case "NoSuchBucket":
-				return "", fmt.Errorf("bucket '%s' does not exist. Error: %s", s.opts.bucketName, ossErr.Message)
-			case "InvalidAccessKeyId":
-				return "", fmt.Errorf("invalid AccessKeyId in configuration. Error: %s", ossErr.Message)
-			case "SignatureDoesNotMatch":
-				return "", fmt.Errorf("invalid AccessKeySecret in configuration. Error: %s", ossErr.Message)
-			case "RequestTimeTooSkewed":
-				return "", fmt.Errorf("system time is incorrect. Please sync your system time. Error: %s", ossErr.Message)
-			default:
-				return "", fmt.Errorf("OSS upload error [%s]: %s", ossErr.Code, ossErr.Message)
-			}
+	for k, v := range opts.Metadata {
+		ossOpts = append(ossOpts, oss.Meta(k, v))
+	}
+	if len(opts.Tags) > 0 {
+		tagging := oss.Tagging{}
+		for k, v := range opts.Tags {
+			tagging.Tags = append(tagging.Tags, oss.Tag{Key: k, Value: v})
 		}
-		return "", fmt.Errorf("failed to upload file to OSS: %v", err)
+		ossOpts = append(ossOpts, oss.SetTagging(tagging))
 	}
+	return ossOpts
+}
 
-	// 确保 filename 里用的是 /
-	filename = path.Clean(strings.ReplaceAll(filename, "\\", "/"))
+// SignURL 生成OSS原生的签名下载地址
+func (o *ossStorage) SignURL(key string, expires time.Duration) (string, error) {
+	signedURL, err := o.bucket.SignURL(key, oss.HTTPGet, int64(expires/time.Second))
+	if err != nil {
+		return "", o.wrapError("sign", key, err)
+	}
+	return signedURL, nil
+}
 
-	return fmt.Sprintf("%s/%s", s.host, filename), nil
+// Get 从OSS下载文件
+func (o *ossStorage) Get(key string) (io.ReadCloser, error) {
+	reader, err := o.bucket.GetObject(key)
+	if err != nil {
+		return nil, o.wrapError("download", key, err)
+	}
+	return reader, nil
 }
 
-// DownloadFile 从OSS下载文件
-func (s *ossService) DownloadFile(filename string) ([]byte, error) {
-	// 从OSS获取文件
-	reader, err := s.bucket.GetObject(filename)
+// GetRange 从OSS按范围下载文件
+func (o *ossStorage) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	reader, err := o.bucket.GetObject(key, oss.Range(offset, offset+length-1))
 	if err != nil {
-		return nil, fmt.Errorf("failed to download file from OSS: %v", err)
+		return nil, o.wrapError("download", key, err)
 	}
+	return reader, nil
+}
 
-	var data []byte
-	if data, err = io.ReadAll(reader); err != nil {
-		return nil, fmt.Errorf("failed to read file from OSS: %v", err)
+// Delete 从OSS删除文件
+func (o *ossStorage) Delete(key string) error {
+	if err := o.bucket.DeleteObject(key); err != nil {
+		return o.wrapError("delete", key, err)
 	}
-	return data, nil
+	return nil
 }
 
-// DeleteFile 从OSS删除文件
-func (s *ossService) DeleteFile(filename string) error {
-	err := s.bucket.DeleteObject(filename)
+// Copy 在OSS服务端复制文件，属性和标签随文件复制，访问权限与源文件一致
+func (o *ossStorage) Copy(srcKey, dstKey string) error {
+	acl, err := o.bucket.GetObjectACL(srcKey)
 	if err != nil {
-		return fmt.Errorf("failed to delete file from OSS: %v", err)
+		return o.wrapError("copy", srcKey, err)
+	}
+	if _, err = o.bucket.CopyObject(srcKey, dstKey, oss.ObjectACL(oss.ACLType(acl.ACL))); err != nil {
+		return o.wrapError("copy", srcKey, err)
 	}
 	return nil
 }
 
-/*
-SetBucketCORS 设置CORS配置
+// Stat 获取OSS文件信息
+func (o *ossStorage) Stat(key string) (*FileInfo, error) {
+	header, err := o.bucket.GetObjectDetailedMeta(key)
+	if err != nil {
+		return nil, o.wrapError("stat", key, err)
+	}
 
-例子：
+	size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	modTime, _ := http.ParseTime(header.Get("Last-Modified"))
+	return &FileInfo{
+		Key:         key,
+		Size:        size,
+		ModTime:     modTime,
+		ETag:        header.Get("ETag"),
+		ObjectAttrs: headerAttrs(header, oss.HTTPHeaderOssMetaPrefix),
+	}, nil
+}
 
-	var rule1 = oos.CORSRule{
-		AllowedOrigin: []string{"*"},
-		AllowedMethod: []string{"GET", "HEAD"},
-		AllowedHeader: []string{"*"},
-		ExposeHeader:  []string{"ETag", "Content-Length", "Content-Type"},
-		MaxAgeSeconds: 86400,
+// headerAttrs 从响应头中读取对象属性，metaPrefix 为自定义元数据的前缀，例如 x-oss-meta-
+func headerAttrs(header http.Header, metaPrefix string) ObjectAttrs {
+	attrs := ObjectAttrs{
+		ContentType:        header.Get("Content-Type"),
+		CacheControl:       header.Get("Cache-Control"),
+		ContentDisposition: header.Get("Content-Disposition"),
 	}
+	for k, v := range header {
+		if name, ok := strings.CutPrefix(strings.ToLower(k), strings.ToLower(metaPrefix)); ok && len(v) > 0 {
+			if attrs.Metadata == nil {
+				attrs.Metadata = make(map[string]string)
+			}
+			attrs.Metadata[name] = v[0]
+		}
+	}
+	return attrs
+}
 
-	var rule2 = oos.CORSRule{
-		AllowedOrigin: []string{"http://www.a.com", "http://www.b.com"},
-		AllowedMethod: []string{"GET"},
-		AllowedHeader: []string{"Authorization"},
-		ExposeHeader:  []string{"x-oss-test", "x-oss-test1"},
-		MaxAgeSeconds: 200,
+// List 按前缀分页列出OSS文件，游标为 OSS 的 marker
+func (o *ossStorage) List(prefix, cursor string, limit int) ([]FileInfo, string, error) {
+	ossOpts := []oss.Option{oss.Prefix(prefix), oss.Marker(cursor)}
+	if limit > 0 {
+		ossOpts = append(ossOpts, oss.MaxKeys(limit))
 	}
-*/
-func (s *ossService) SetBucketCORS(rules ...oss.CORSRule) error {
-	// 为空使用默认的
-	if len(rules) == 0 {
-		rules = append(rules, defaultCorsRule)
+
+	result, err := o.bucket.ListObjects(ossOpts...)
+	if err != nil {
+		return nil, "", o.wrapError("list", prefix, err)
+	}
+
+	files := make([]FileInfo, 0, len(result.Objects))
+	for _, object := range result.Objects {
+		files = append(files, FileInfo{
+			Key:     object.Key,
+			Size:    object.Size,
+			ModTime: object.LastModified,
+			ETag:    object.ETag,
+		})
+	}
+
+	var next string
+	if result.IsTruncated {
+		next = result.NextMarker
+	}
+	return files, next, nil
+}
+
+// wrapError 解析OSS错误，提供更详细的错误信息，并按错误码分类。
+// 配置错误（bucket 不存在、密钥错误等）调用方无法处理，视为存储不可用
+func (o *ossStorage) wrapError(op, key string, err error) error {
+	var ossErr oss.ServiceError
+	if !errors.As(err, &ossErr) {
+		// 网络错误，读取上传内容返回的错误保留原有的分类
+		var kind error = ErrBackendUnavailable
+		if kindOf(err) != nil {
+			kind = nil
+		}
+		return storageError(op, key, kind, fmt.Errorf("failed to %s file on OSS: %w", op, err))
 	}
 
-	return s.client.SetBucketCORS(s.bucket.BucketName, rules)
+	switch ossErr.Code {
+	case "AccessDenied":
+		if strings.Contains(ossErr.Message, "endpoint") {
+			return storageError(op, key, ErrBackendUnavailable, fmt.Errorf("region mismatch: bucket is in different region than configured endpoint. Please check your OSS configuration. Error: %s", ossErr.Message))
+		}
+		return storageError(op, key, ErrPermissionDenied, fmt.Errorf("access denied: insufficient permissions to %s file. Error: %s", op, ossErr.Message))
+	case "NoSuchBucket":
+		return storageError(op, key, ErrBackendUnavailable, fmt.Errorf("bucket '%s' does not exist. Error: %s", o.bucket.BucketName, ossErr.Message))
+	case "NoSuchKey":
+		return storageError(op, key, ErrNotFound, fmt.Errorf("file does not exist. Error: %s", ossErr.Message))
+	case "InvalidAccessKeyId":
+		return storageError(op, key, ErrBackendUnavailable, fmt.Errorf("invalid AccessKeyId in configuration. Error: %s", ossErr.Message))
+	case "SignatureDoesNotMatch":
+		return storageError(op, key, ErrBackendUnavailable, fmt.Errorf("invalid AccessKeySecret in configuration. Error: %s", ossErr.Message))
+	case "RequestTimeTooSkewed":
+		return storageError(op, key, ErrBackendUnavailable, fmt.Errorf("system time is incorrect. Please sync your system time. Error: %s", ossErr.Message))
+	default:
+		// HEAD 请求没有响应体，错误码为空，根据状态码分类
+		return storageError(op, key, statusKind(ossErr.StatusCode), fmt.Errorf("OSS %s error [%s]: %s", op, ossErr.Code, ossErr.Message))
+	}
 }
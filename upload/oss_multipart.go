@@ -0,0 +1,451 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+const (
+	// 默认分片上传的阈值，超过时使用分片上传
+	defaultMultipartThreshold = 64 << 20
+	// 默认分片大小
+	defaultPartSize = 8 << 20
+	// OSS 允许的最小分片大小
+	minPartSize = 100 << 10
+	// OSS 允许的最大分片数
+	maxParts = 10000
+	// 默认并发上传的分片数
+	defaultPartConcurrency = 4
+	// 单个分片上传失败时的重试次数
+	partRetries = 3
+	// CopyObject 能复制的最大对象，更大的对象按分片复制
+	maxCopyObjectSize = 1 << 30
+	// 断点的锁文件超过该时间没有更新时视为上传已中断
+	checkpointLockTimeout = 10 * time.Minute
+)
+
+// 分片重试的间隔，第 n 次重试等待 n 倍
+var partRetryDelay = time.Second
+
+// ossMultipart 分片上传配置
+type ossMultipart struct {
+	threshold     int64
+	partSize      int64
+	concurrency   int
+	checkpointDir string
+}
+
+// newOssMultipart 从驱动参数读取分片上传配置
+func newOssMultipart(params map[string]string) (ossMultipart, error) {
+	m := ossMultipart{
+		threshold:     defaultMultipartThreshold,
+		partSize:      defaultPartSize,
+		concurrency:   defaultPartConcurrency,
+		checkpointDir: params[ParamCheckpointDir],
+	}
+	if m.checkpointDir == "" {
+		m.checkpointDir = filepath.Join(os.TempDir(), "biz_oss_multipart")
+	}
+
+	for param, value := range map[string]*int64{ParamMultipartThreshold: &m.threshold, ParamPartSize: &m.partSize} {
+		if params[param] == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(params[param], 10, 64)
+		if err != nil || n <= 0 {
+			return m, fmt.Errorf("invalid %s %q", param, params[param])
+		}
+		*value = n
+	}
+	if value := params[ParamPartConcurrency]; value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return m, fmt.Errorf("invalid %s %q", ParamPartConcurrency, value)
+		}
+		m.concurrency = n
+	}
+	if m.partSize < minPartSize {
+		return m, fmt.Errorf("%s must be at least %d bytes", ParamPartSize, minPartSize)
+	}
+	return m, nil
+}
+
+// ossCheckpoint 分片上传的断点，按 bucket、目标目录和内容的 SHA-256 保存。
+// 上传失败后再次上传相同内容到同一目录时，即使 key 不同（例如 GenerateUniqueFilename 生成的新文件名）
+// 也跳过已上传的分片：在原 key 上完成分片上传后复制到新 key
+type ossCheckpoint struct {
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	UploadID string `json:"upload_id"`
+	// 文件内容的 SHA-256，内容不同时不能继续上传
+	Hash     string           `json:"hash"`
+	Size     int64            `json:"size"`
+	PartSize int64            `json:"part_size"`
+	Parts    []oss.UploadPart `json:"parts"`
+}
+
+// put 上传文件，超过阈值时分片上传。内容先缓存到临时文件，以便分片失败时重试
+func (o *ossStorage) put(key string, reader io.Reader, ossOpts []oss.Option) error {
+	// 小文件直接在内存中上传
+	head := make([]byte, min(spoolMemLimit, o.multipart.threshold))
+	n, err := io.ReadFull(reader, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return o.putObject(key, bytes.NewReader(head[:n]), ossOpts)
+	}
+	if err != nil {
+		return o.wrapError("upload", key, err)
+	}
+
+	spool, err := os.CreateTemp("", TempPrefix+"oss_*")
+	if err != nil {
+		return storageError("upload", key, ErrBackendUnavailable, fmt.Errorf("failed to create temp file: %w", err))
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hash), io.MultiReader(bytes.NewReader(head), reader))
+	if err != nil {
+		return o.wrapError("upload", key, err)
+	}
+
+	if size <= o.multipart.threshold {
+		if _, err = spool.Seek(0, io.SeekStart); err != nil {
+			return o.wrapError("upload", key, err)
+		}
+		return o.putObject(key, spool, ossOpts)
+	}
+	return o.putMultipart(key, spool, size, hex.EncodeToString(hash.Sum(nil)), ossOpts)
+}
+
+func (o *ossStorage) putObject(key string, reader io.Reader, ossOpts []oss.Option) error {
+	if err := o.bucket.PutObject(key, reader, ossOpts...); err != nil {
+		return o.wrapError("upload", key, err)
+	}
+	return nil
+}
+
+// putMultipart 分片上传，并发上传未完成的分片，每完成一个分片保存断点。
+// 失败时保留断点和 OSS 上已上传的分片，未再次上传的由 AbortStaleUploads 清理。
+// 相同内容的断点正被其他上传使用时不保存断点，失败时直接取消分片上传
+func (o *ossStorage) putMultipart(key string, file *os.File, size int64, hash string, ossOpts []oss.Option) error {
+	// 分片数不能超过上限，文件过大时增大分片
+	partSize := max(o.multipart.partSize, (size+maxParts-1)/maxParts)
+
+	cpPath := o.checkpointPath(key, hash)
+	unlock, locked := o.lockCheckpoint(cpPath)
+	if !locked {
+		cp, err := o.initiateMultipart(key, hash, size, partSize, ossOpts)
+		if err != nil {
+			return err
+		}
+		if err = o.uploadParts(cp, file, ""); err != nil {
+			o.abortMultipart(cp)
+			return o.wrapError("upload", key, err)
+		}
+		return nil
+	}
+	defer unlock()
+
+	cp := o.loadCheckpoint(cpPath, key, hash)
+	if cp != nil && (cp.Size != size || cp.PartSize != partSize || !o.resumable(cp, key)) {
+		o.abortMultipart(cp)
+		os.Remove(cpPath)
+		cp = nil
+	}
+	if cp == nil {
+		var err error
+		if cp, err = o.initiateMultipart(key, hash, size, partSize, ossOpts); err != nil {
+			return err
+		}
+		if err = o.saveCheckpoint(cpPath, cp); err != nil {
+			o.abortMultipart(cp)
+			return storageError("upload", key, ErrBackendUnavailable, err)
+		}
+	}
+
+	if err := o.uploadParts(cp, file, cpPath); err != nil {
+		// 分片上传已被取消或分片不一致时无法继续，下次重新上传
+		var ossErr oss.ServiceError
+		if errors.As(err, &ossErr) && (ossErr.Code == "NoSuchUpload" || ossErr.Code == "InvalidPart") {
+			os.Remove(cpPath)
+		}
+		return o.wrapError("upload", key, err)
+	}
+	os.Remove(cpPath)
+	if cp.Key != key {
+		if err := o.moveObject(cp.Key, key, size, ossOpts); err != nil {
+			// 原 key 上的对象没有被引用，复制失败时同样删除，下次重新上传
+			o.bucket.DeleteObject(cp.Key)
+			return err
+		}
+	}
+	return nil
+}
+
+// initiateMultipart 在 key 上发起分片上传
+func (o *ossStorage) initiateMultipart(key, hash string, size, partSize int64, ossOpts []oss.Option) (*ossCheckpoint, error) {
+	imur, err := o.bucket.InitiateMultipartUpload(key, ossOpts...)
+	if err != nil {
+		return nil, o.wrapError("upload", key, err)
+	}
+	return &ossCheckpoint{Bucket: o.bucket.BucketName, Key: key, UploadID: imur.UploadID,
+		Hash: hash, Size: size, PartSize: partSize, Parts: []oss.UploadPart{}}, nil
+}
+
+// resumable 判断断点能否用于上传到 key。断点的 key 不同时，原 key 上已有对象说明被其他上传占用，不能覆盖
+func (o *ossStorage) resumable(cp *ossCheckpoint, key string) bool {
+	if cp.Key == key {
+		return true
+	}
+	_, err := o.Stat(cp.Key)
+	return errors.Is(err, ErrNotFound)
+}
+
+// uploadParts 并发上传断点中未完成的分片并完成分片上传，cpPath 不为空时每完成一个分片保存断点
+func (o *ossStorage) uploadParts(cp *ossCheckpoint, file *os.File, cpPath string) error {
+	imur := oss.InitiateMultipartUploadResult{Bucket: cp.Bucket, Key: cp.Key, UploadID: cp.UploadID}
+
+	done := make(map[int]bool, len(cp.Parts))
+	for _, part := range cp.Parts {
+		done[part.PartNumber] = true
+	}
+	var pending []int
+	for number := 1; int64(number-1)*cp.PartSize < cp.Size; number++ {
+		if !done[number] {
+			pending = append(pending, number)
+		}
+	}
+
+	var mu sync.Mutex
+	var uploadErr error
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < o.multipart.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range jobs {
+				offset := int64(number-1) * cp.PartSize
+				part, err := o.uploadPart(imur, io.NewSectionReader(file, offset, min(cp.PartSize, cp.Size-offset)), number)
+
+				mu.Lock()
+				if err == nil {
+					cp.Parts = append(cp.Parts, part)
+					if cpPath != "" {
+						err = o.saveCheckpoint(cpPath, cp)
+					}
+				}
+				if err != nil && uploadErr == nil {
+					uploadErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, number := range pending {
+		mu.Lock()
+		failed := uploadErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		jobs <- number
+	}
+	close(jobs)
+	wg.Wait()
+
+	if uploadErr != nil {
+		return uploadErr
+	}
+	sort.Sort(oss.UploadParts(cp.Parts))
+	_, err := o.bucket.CompleteMultipartUpload(imur, cp.Parts)
+	return err
+}
+
+// abortMultipart 取消分片上传，释放已上传的分片
+func (o *ossStorage) abortMultipart(cp *ossCheckpoint) error {
+	return o.bucket.AbortMultipartUpload(oss.InitiateMultipartUploadResult{Bucket: cp.Bucket, Key: cp.Key, UploadID: cp.UploadID})
+}
+
+// moveObject 将对象复制到 key 后删除原对象，例如在断点原 key 上完成的分片上传、直传的临时对象，
+// 属性和标签以 ossOpts 为准。超过 CopyObject 上限的对象按分片复制，复制失败时保留原对象
+func (o *ossStorage) moveObject(srcKey, key string, size int64, ossOpts []oss.Option) error {
+	partSize := max(o.multipart.partSize, (size+maxParts-1)/maxParts)
+	var err error
+	if size <= maxCopyObjectSize {
+		_, err = o.bucket.CopyObject(srcKey, key, append(ossOpts[:len(ossOpts):len(ossOpts)],
+			oss.MetadataDirective(oss.MetaReplace), oss.TaggingDirective(oss.TaggingReplace))...)
+	} else {
+		err = o.bucket.CopyFile(o.bucket.BucketName, srcKey, key, partSize,
+			append(ossOpts[:len(ossOpts):len(ossOpts)], oss.Routines(o.multipart.concurrency))...)
+	}
+	if err != nil {
+		return o.wrapError("upload", key, err)
+	}
+	o.bucket.DeleteObject(srcKey)
+	return nil
+}
+
+// uploadPart 上传单个分片，失败时重试
+func (o *ossStorage) uploadPart(imur oss.InitiateMultipartUploadResult, section *io.SectionReader, number int) (oss.UploadPart, error) {
+	var part oss.UploadPart
+	var err error
+	for attempt := 0; attempt <= partRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * partRetryDelay)
+			section.Seek(0, io.SeekStart)
+		}
+		if part, err = o.bucket.UploadPart(imur, section, section.Size(), number); err == nil {
+			return part, nil
+		}
+		// 分片上传已被取消，重试没有意义
+		var ossErr oss.ServiceError
+		if errors.As(err, &ossErr) && ossErr.Code == "NoSuchUpload" {
+			break
+		}
+	}
+	return part, err
+}
+
+// checkpointPath 断点文件路径，按 bucket、key 所在目录和内容的 SHA-256 区分
+func (o *ossStorage) checkpointPath(key, hash string) string {
+	sum := sha256.Sum256([]byte(o.bucket.BucketName + "/" + path.Dir(key) + "/" + hash))
+	return filepath.Join(o.multipart.checkpointDir, hex.EncodeToString(sum[:])+".json")
+}
+
+// lockCheckpoint 创建锁文件独占断点，多个实例共用断点目录时同样有效。
+// 锁文件超过 checkpointLockTimeout 没有更新时视为持有者已退出
+func (o *ossStorage) lockCheckpoint(cpPath string) (func(), bool) {
+	if err := os.MkdirAll(o.multipart.checkpointDir, 0755); err != nil {
+		return nil, false
+	}
+	lockPath := cpPath + ".lock"
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lockPath) }, true
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, false
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) < checkpointLockTimeout {
+			return nil, false
+		}
+		os.Remove(lockPath)
+	}
+	return nil, false
+}
+
+// loadCheckpoint 读取断点，不存在、无法解析或不属于 key 所在目录时返回 nil
+func (o *ossStorage) loadCheckpoint(cpPath, key, hash string) *ossCheckpoint {
+	data, err := os.ReadFile(cpPath)
+	if err != nil {
+		return nil
+	}
+	var cp ossCheckpoint
+	if err = json.Unmarshal(data, &cp); err != nil || cp.Bucket != o.bucket.BucketName ||
+		cp.Hash != hash || path.Dir(cp.Key) != path.Dir(key) {
+		return nil
+	}
+	return &cp
+}
+
+// saveCheckpoint 保存断点并刷新锁文件，先写入临时文件再重命名，避免中断时断点文件不完整
+func (o *ossStorage) saveCheckpoint(cpPath string, cp *ossCheckpoint) error {
+	sort.Sort(oss.UploadParts(cp.Parts))
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	if err = os.WriteFile(cpPath+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	if err = os.Rename(cpPath+".tmp", cpPath); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	now := time.Now()
+	os.Chtimes(cpPath+".lock", now, now)
+	return nil
+}
+
+// AbortStaleUploads 取消 prefix 下早于 olderThan 发起且未完成的分片上传，释放已上传分片占用的空间，
+// 同时删除这些 key 过期的断点文件。返回取消的分片上传数
+func (o *ossStorage) AbortStaleUploads(prefix string, olderThan time.Duration) (int, error) {
+	before := time.Now().Add(-olderThan)
+	aborted := 0
+	keyMarker, uploadIDMarker := "", ""
+	for {
+		result, err := o.bucket.ListMultipartUploads(oss.Prefix(prefix), oss.KeyMarker(keyMarker), oss.UploadIDMarker(uploadIDMarker))
+		if err != nil {
+			return aborted, o.wrapError("list", "", err)
+		}
+		for _, upload := range result.Uploads {
+			if !upload.Initiated.Before(before) {
+				continue
+			}
+			imur := oss.InitiateMultipartUploadResult{Bucket: o.bucket.BucketName, Key: upload.Key, UploadID: upload.UploadID}
+			if err = o.bucket.AbortMultipartUpload(imur); err != nil {
+				return aborted, o.wrapError("abort", upload.Key, err)
+			}
+			aborted++
+		}
+		if !result.IsTruncated {
+			break
+		}
+		keyMarker, uploadIDMarker = result.NextKeyMarker, result.NextUploadIDMarker
+	}
+
+	o.removeStaleCheckpoints(prefix, before)
+	return aborted, nil
+}
+
+// removeStaleCheckpoints 删除 prefix 下早于 before 的断点，先取消对应的分片上传，避免已上传的分片继续占用空间。
+// 取消失败的断点保留到下次清理；其他 bucket 或目录的断点保留，无法解析的断点不能续传，直接删除
+func (o *ossStorage) removeStaleCheckpoints(prefix string, before time.Time) {
+	entries, _ := os.ReadDir(o.multipart.checkpointDir)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || !info.ModTime().Before(before) {
+			continue
+		}
+		filename := filepath.Join(o.multipart.checkpointDir, entry.Name())
+		if data, err := os.ReadFile(filename); err == nil && strings.HasSuffix(filename, ".json") {
+			var cp ossCheckpoint
+			if json.Unmarshal(data, &cp) == nil {
+				if cp.Bucket != o.bucket.BucketName || !strings.HasPrefix(cp.Key, prefix) {
+					continue
+				}
+				var ossErr oss.ServiceError
+				if err = o.abortMultipart(&cp); err != nil && !(errors.As(err, &ossErr) && ossErr.Code == "NoSuchUpload") {
+					continue
+				}
+			}
+		}
+		os.Remove(filename)
+	}
+}
+
+// AbortStaleUploads 取消上传目录下早于 olderThan 发起且未完成的分片上传，返回取消的数量
+func (s *ossService) AbortStaleUploads(olderThan time.Duration) (int, error) {
+	return s.storage.AbortStaleUploads(s.dirPrefix(), olderThan)
+}
@@ -0,0 +1,399 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOSS 模拟 OSS 的上传、分片上传、下载和删除接口，bucket 固定为 assets
+type fakeOSS struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]*fakeMultipart
+	// 每个分片号收到的上传次数
+	partRequests map[int]int
+	// 返回 true 时分片上传失败
+	failPart func(number int) bool
+	nextID   int
+}
+
+type fakeMultipart struct {
+	key       string
+	initiated time.Time
+	parts     map[int][]byte
+}
+
+func newFakeOSS() *fakeOSS {
+	return &fakeOSS{objects: make(map[string][]byte), uploads: make(map[string]*fakeMultipart), partRequests: make(map[int]int)}
+}
+
+func (f *fakeOSS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/assets/")
+	query := r.URL.Query()
+	_, isUploads := query["uploads"]
+	uploadID := query.Get("uploadId")
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.Method == http.MethodPost && isUploads:
+		f.nextID++
+		id := fmt.Sprintf("upload-%d", f.nextID)
+		f.uploads[id] = &fakeMultipart{key: key, initiated: time.Now(), parts: make(map[int][]byte)}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>assets</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", key, id)
+	case r.Method == http.MethodGet && isUploads:
+		w.Write([]byte("<ListMultipartUploadsResult><Bucket>assets</Bucket><IsTruncated>false</IsTruncated>"))
+		for id, upload := range f.uploads {
+			if !strings.HasPrefix(upload.key, query.Get("prefix")) {
+				continue
+			}
+			fmt.Fprintf(w, "<Upload><Key>%s</Key><UploadId>%s</UploadId><Initiated>%s</Initiated></Upload>",
+				upload.key, id, upload.initiated.UTC().Format(time.RFC3339))
+		}
+		w.Write([]byte("</ListMultipartUploadsResult>"))
+	case r.Method == http.MethodPut && uploadID != "":
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		f.partRequests[number]++
+		upload, ok := f.uploads[uploadID]
+		if !ok {
+			f.writeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		if f.failPart != nil && f.failPart(number) {
+			f.writeError(w, http.StatusInternalServerError, "InternalError")
+			return
+		}
+		upload.parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, number))
+	case r.Method == http.MethodPost && uploadID != "":
+		upload, ok := f.uploads[uploadID]
+		if !ok {
+			f.writeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+			} `xml:"Part"`
+		}
+		xml.Unmarshal(body, &complete)
+		var numbers []int
+		for _, part := range complete.Parts {
+			numbers = append(numbers, part.PartNumber)
+		}
+		sort.Ints(numbers)
+		var data []byte
+		for i, number := range numbers {
+			if number != i+1 || upload.parts[number] == nil {
+				f.writeError(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			data = append(data, upload.parts[number]...)
+		}
+		f.objects[upload.key] = data
+		delete(f.uploads, uploadID)
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>assets</Bucket><Key>%s</Key></CompleteMultipartUploadResult>", upload.key)
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && r.Header.Get("X-Oss-Copy-Source") != "":
+		srcKey, _ := url.QueryUnescape(strings.TrimPrefix(r.Header.Get("X-Oss-Copy-Source"), "/assets/"))
+		data, ok := f.objects[srcKey]
+		if !ok {
+			f.writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		f.objects[key] = data
+		fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			f.writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(data)))
+		http.ServeContent(w, r, key, time.Now(), bytes.NewReader(data))
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeOSS) writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func newTestMultipartService(t *testing.T, fake *fakeOSS, checkpointDir string, optFns ...Option) OssService {
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	svc, err := NewOssService(srv.URL, "videos", append([]Option{WithOssConfig(srv.URL, "test-id", "test-secret", "assets", srv.URL),
+		WithOssMultipart(200<<10, 100<<10, 2, checkpointDir)}, optFns...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+func TestOssMultipartUpload(t *testing.T) {
+	fake := newFakeOSS()
+	svc := newTestMultipartService(t, fake, t.TempDir())
+
+	// 未超过阈值时直接上传
+	if _, err := svc.UploadFile(strings.NewReader("small"), "small.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if string(fake.objects["videos/small.txt"]) != "small" || len(fake.partRequests) != 0 {
+		t.Errorf("small file should use a single put")
+	}
+
+	data := bytes.Repeat([]byte("0123456789"), 25<<10) // 250KB，3 个分片
+	if _, err := svc.UploadFile(bytes.NewReader(data), "big.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fake.objects["videos/big.bin"], data) {
+		t.Error("multipart object content mismatch")
+	}
+	if len(fake.partRequests) != 3 || len(fake.uploads) != 0 {
+		t.Errorf("expected 3 parts and no pending uploads, got %v, %d", fake.partRequests, len(fake.uploads))
+	}
+}
+
+func TestOssMultipartResume(t *testing.T) {
+	defer func(delay time.Duration) { partRetryDelay = delay }(partRetryDelay)
+	partRetryDelay = 0
+
+	fake := newFakeOSS()
+	fake.failPart = func(number int) bool { return number == 2 }
+	checkpointDir := t.TempDir()
+	svc := newTestMultipartService(t, fake, checkpointDir)
+
+	data := bytes.Repeat([]byte("abcdefghij"), 25<<10)
+	if _, err := svc.UploadFile(bytes.NewReader(data), "big.bin"); err == nil {
+		t.Fatal("upload should fail")
+	}
+	if fake.partRequests[2] != partRetries+1 {
+		t.Errorf("expected part 2 to be retried %d times, got %d", partRetries, fake.partRequests[2]-1)
+	}
+	if entries, _ := os.ReadDir(checkpointDir); len(entries) != 1 {
+		t.Fatalf("expected a checkpoint, got %d files", len(entries))
+	}
+
+	// 再次上传时跳过已上传的分片
+	fake.failPart = nil
+	uploaded := fake.partRequests[1]
+	if _, err := svc.UploadFile(bytes.NewReader(data), "big.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fake.objects["videos/big.bin"], data) {
+		t.Error("resumed object content mismatch")
+	}
+	if fake.partRequests[1] != uploaded {
+		t.Error("uploaded part should not be sent again")
+	}
+	if entries, _ := os.ReadDir(checkpointDir); len(entries) != 0 {
+		t.Error("checkpoint should be removed after completion")
+	}
+}
+
+func TestOssMultipartResumeNewKey(t *testing.T) {
+	defer func(delay time.Duration) { partRetryDelay = delay }(partRetryDelay)
+	partRetryDelay = 0
+
+	fake := newFakeOSS()
+	fake.failPart = func(number int) bool { return number == 2 }
+	svc := newTestMultipartService(t, fake, t.TempDir())
+
+	// 客户端重试时 GenerateUniqueFilename 生成新的 key，相同内容仍然从断点继续
+	data := bytes.Repeat([]byte("uvwxyz0123"), 25<<10)
+	if _, err := svc.UploadFile(bytes.NewReader(data), svc.GenerateUniqueFilename("big.bin")); err == nil {
+		t.Fatal("upload should fail")
+	}
+	fake.failPart = nil
+	uploaded := fake.partRequests[1]
+	name := svc.GenerateUniqueFilename("big.bin")
+	if _, err := svc.UploadFile(bytes.NewReader(data), name); err != nil {
+		t.Fatal(err)
+	}
+	if fake.partRequests[1] != uploaded {
+		t.Error("uploaded part should not be sent again")
+	}
+	if len(fake.objects) != 1 || len(fake.uploads) != 0 || !bytes.Equal(fake.objects["videos/"+name], data) {
+		t.Errorf("expected only videos/%s and no pending uploads, got %d objects, %d uploads", name, len(fake.objects), len(fake.uploads))
+	}
+}
+
+func TestOssMultipartResumeOccupiedKey(t *testing.T) {
+	defer func(delay time.Duration) { partRetryDelay = delay }(partRetryDelay)
+	partRetryDelay = 0
+
+	fake := newFakeOSS()
+	fake.failPart = func(number int) bool { return number == 2 }
+	svc := newTestMultipartService(t, fake, t.TempDir())
+
+	data := bytes.Repeat([]byte("abcdefghij"), 25<<10)
+	if _, err := svc.UploadFile(bytes.NewReader(data), "a.bin"); err == nil {
+		t.Fatal("upload should fail")
+	}
+	// 断点原 key 上已经写入了其他文件，不能在原 key 上完成续传
+	fake.failPart = nil
+	fake.objects["videos/a.bin"] = []byte("other")
+	if _, err := svc.UploadFile(bytes.NewReader(data), "b.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if string(fake.objects["videos/a.bin"]) != "other" || !bytes.Equal(fake.objects["videos/b.bin"], data) {
+		t.Error("occupied key should be kept and the new key uploaded")
+	}
+	if len(fake.uploads) != 0 {
+		t.Errorf("stale upload should be aborted, %d pending", len(fake.uploads))
+	}
+}
+
+func TestOssMultipartLockedCheckpoint(t *testing.T) {
+	defer func(delay time.Duration) { partRetryDelay = delay }(partRetryDelay)
+	partRetryDelay = 0
+
+	fake := newFakeOSS()
+	fake.failPart = func(number int) bool { return number == 2 }
+	checkpointDir := t.TempDir()
+	svc := newTestMultipartService(t, fake, checkpointDir)
+
+	// 相同内容的断点正被其他上传使用时不共用断点，失败后取消分片上传
+	data := bytes.Repeat([]byte("0123456789"), 25<<10)
+	sum := sha256.Sum256(data)
+	cpPath := svc.(*ossService).storage.checkpointPath("videos/a.bin", hex.EncodeToString(sum[:]))
+	if err := os.WriteFile(cpPath+".lock", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.UploadFile(bytes.NewReader(data), "a.bin"); err == nil {
+		t.Fatal("upload should fail")
+	}
+	if _, err := os.Stat(cpPath); err == nil {
+		t.Error("checkpoint should not be saved without the lock")
+	}
+	if len(fake.uploads) != 0 {
+		t.Errorf("failed upload should be aborted, %d pending", len(fake.uploads))
+	}
+}
+
+func TestOssMultipartResumeContentAddressed(t *testing.T) {
+	defer func(delay time.Duration) { partRetryDelay = delay }(partRetryDelay)
+	partRetryDelay = 0
+
+	fake := newFakeOSS()
+	fake.failPart = func(number int) bool { return number == 2 }
+	svc := newTestMultipartService(t, fake, t.TempDir(), WithContentAddressed())
+
+	// 客户端重试时文件名不同，内容寻址的 key 相同，可以从断点继续
+	data := bytes.Repeat([]byte("klmnopqrst"), 25<<10)
+	if _, err := svc.UploadFile(bytes.NewReader(data), "first.bin"); err == nil {
+		t.Fatal("upload should fail")
+	}
+	fake.failPart = nil
+	uploaded := fake.partRequests[1]
+	if _, err := svc.UploadFile(bytes.NewReader(data), "retry.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.partRequests[1] != uploaded {
+		t.Error("uploaded part should not be sent again")
+	}
+	if len(fake.objects) != 1 || len(fake.uploads) != 0 {
+		t.Fatalf("expected a single object and no pending uploads, got %d, %d", len(fake.objects), len(fake.uploads))
+	}
+	for _, object := range fake.objects {
+		if !bytes.Equal(object, data) {
+			t.Error("resumed object content mismatch")
+		}
+	}
+}
+
+func TestAbortStaleUploads(t *testing.T) {
+	defer func(delay time.Duration) { partRetryDelay = delay }(partRetryDelay)
+	partRetryDelay = 0
+
+	fake := newFakeOSS()
+	fake.failPart = func(int) bool { return true }
+	checkpointDir := t.TempDir()
+	svc := newTestMultipartService(t, fake, checkpointDir)
+
+	// 内容不同，不共用断点
+	if _, err := svc.UploadFile(bytes.NewReader(bytes.Repeat([]byte("x"), 250<<10)), "stale.bin"); err == nil {
+		t.Fatal("upload should fail")
+	}
+	if _, err := svc.UploadFile(bytes.NewReader(bytes.Repeat([]byte("y"), 250<<10)), "recent.bin"); err == nil {
+		t.Fatal("upload should fail")
+	}
+	for _, upload := range fake.uploads {
+		if upload.key == "videos/stale.bin" {
+			upload.initiated = time.Now().Add(-48 * time.Hour)
+		}
+	}
+	// 其他目录的分片上传不属于该服务，不取消
+	fake.uploads["other"] = &fakeMultipart{key: "images/other.bin", initiated: time.Now().Add(-48 * time.Hour), parts: map[int][]byte{}}
+
+	n, err := svc.AbortStaleUploads(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(fake.uploads) != 2 {
+		t.Errorf("expected 1 stale upload aborted, got %d, %d pending", n, len(fake.uploads))
+	}
+	for _, upload := range fake.uploads {
+		if upload.key != "videos/recent.bin" && upload.key != "images/other.bin" {
+			t.Errorf("only the stale upload should be aborted, got %s", upload.key)
+		}
+	}
+}
+
+func TestSweepAbortsStaleCheckpoints(t *testing.T) {
+	defer func(delay time.Duration) { partRetryDelay = delay }(partRetryDelay)
+	partRetryDelay = 0
+
+	fake := newFakeOSS()
+	fake.failPart = func(number int) bool { return number == 2 }
+	checkpointDir := t.TempDir()
+	svc := newTestMultipartService(t, fake, checkpointDir)
+
+	if _, err := svc.UploadFile(bytes.NewReader(bytes.Repeat([]byte("z"), 250<<10)), "a.bin"); err == nil {
+		t.Fatal("upload should fail")
+	}
+	entries, _ := os.ReadDir(checkpointDir)
+	old := time.Now().Add(-2 * staleTempAge)
+	for _, entry := range entries {
+		os.Chtimes(filepath.Join(checkpointDir, entry.Name()), old, old)
+	}
+
+	// 删除过期断点前取消分片上传，已上传的分片不再占用空间
+	if _, err := svc.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ = os.ReadDir(checkpointDir); len(entries) != 0 {
+		t.Errorf("stale checkpoint should be removed, %d files left", len(entries))
+	}
+	if len(fake.uploads) != 0 {
+		t.Errorf("multipart upload of the stale checkpoint should be aborted, %d pending", len(fake.uploads))
+	}
+}
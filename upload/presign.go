@@ -0,0 +1,316 @@
+package upload
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+const (
+	// 默认签名有效期
+	defaultPresignExpires = 15 * time.Minute
+	// 直传的临时 key 前缀，VerifyUpload 校验通过后移动到最终 key。
+	// 签名在校验后仍然有效，再次写入只会写到临时 key，需要在 OSS 上为该前缀设置生命周期规则
+	presignStagingPrefix = ".presign/"
+)
+
+// PresignOptions 直传签名选项
+type PresignOptions struct {
+	// 原文件名，用于生成对象 key 的扩展名
+	Filename string
+	// 限制上传的 Content-Type，为空时不限制
+	ContentType string
+	// 限制上传的最大字节数，仅 POST 策略可以在 OSS 端强制限制，PUT 在回调时校验
+	MaxSize int64
+	// 签名有效期，默认 15 分钟
+	Expires time.Duration
+}
+
+// PresignedUpload 浏览器直传所需的信息
+type PresignedUpload struct {
+	// 校验通过后对象的 key
+	Key string `json:"key"`
+	// 浏览器写入的临时 key，已包含在 URL 和表单字段中
+	UploadKey string `json:"upload_key"`
+	// 回调凭证，上传完成后原样传给 VerifyUpload
+	Token string `json:"token"`
+	// PUT 为签名 URL，POST 为表单提交地址
+	URL    string `json:"url"`
+	Method string `json:"method"`
+	// PUT 请求必须携带的请求头
+	Headers map[string]string `json:"headers,omitempty"`
+	// POST 表单字段，file 字段需放在最后
+	Fields     map[string]string `json:"fields,omitempty"`
+	Expiration time.Time         `json:"expiration"`
+}
+
+// presignToken 回调凭证的内容，签发时的 key 和上传选项，回调时据此保存文件记录
+type presignToken struct {
+	Key          string        `json:"key"`
+	Owner        string        `json:"owner,omitempty"`
+	OriginalName string        `json:"original_name,omitempty"`
+	ACL          ACL           `json:"acl,omitempty"`
+	TTL          time.Duration `json:"ttl,omitempty"`
+	MaxSize      int64         `json:"max_size,omitempty"`
+	// 凭证的过期时间，Unix 秒
+	Expires int64 `json:"expires"`
+}
+
+// presign 生成唯一的对象 key 和回调凭证，返回对象的访问权限
+func (s *ossService) presign(opts *PresignOptions, uploadOpts []UploadOption) (string, string, oss.ACLType, error) {
+	if opts.Expires <= 0 {
+		opts.Expires = defaultPresignExpires
+	}
+	options := newUploadOptions(opts.Filename, uploadOpts...)
+	if options.ttl > 0 && s.opts.repository == nil {
+		return "", "", "", fmt.Errorf("临时上传需要配置文件记录仓库")
+	}
+	if options.acl == ACLDefault {
+		options.acl = s.opts.defaultACL
+	}
+	acl := oss.ACLPublicRead
+	if options.acl == ACLPrivate {
+		acl = oss.ACLPrivate
+	}
+
+	key := s.joinPath(s.dir, s.GenerateUniqueFilename(opts.Filename, uploadOpts...))
+	// 签名过期前开始的上传可能稍后才完成，回调凭证多保留一个签名有效期
+	token, err := s.signPresignToken(presignToken{
+		Key:          key,
+		Owner:        options.owner,
+		OriginalName: options.originalName,
+		ACL:          options.acl,
+		TTL:          options.ttl,
+		MaxSize:      opts.MaxSize,
+		Expires:      time.Now().Add(2 * opts.Expires).Unix(),
+	})
+	return key, token, acl, err
+}
+
+// signPresignToken 签名回调凭证：base64url(JSON).base64url(HMAC-SHA256)
+func (s *ossService) signPresignToken(token presignToken) (string, error) {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal presign token: %v", err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.presignSignature(payload)), nil
+}
+
+// parsePresignToken 校验回调凭证的签名和有效期
+func (s *ossService) parsePresignToken(token string) (*presignToken, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	signature, err := base64.RawURLEncoding.DecodeString(sig)
+	if !ok || err != nil || !hmac.Equal(signature, s.presignSignature(payload)) {
+		return nil, fmt.Errorf("%w: invalid presign token", ErrAccessDenied)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid presign token", ErrAccessDenied)
+	}
+	var parsed presignToken
+	if err = json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("%w: invalid presign token", ErrAccessDenied)
+	}
+	if time.Now().Unix() > parsed.Expires {
+		return nil, fmt.Errorf("%w: presign token expired", ErrAccessDenied)
+	}
+	return &parsed, nil
+}
+
+// presignSignature 使用 WithSigningKey 设置的密钥签名，未设置时使用 OSS AccessKeySecret
+func (s *ossService) presignSignature(payload string) []byte {
+	key := s.opts.signingKey
+	if len(key) == 0 {
+		key = []byte(s.storage.accessKeySecret)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("presign\n" + payload))
+	return mac.Sum(nil)
+}
+
+// PresignPut 生成浏览器直传的签名 PUT URL，uploadOpts 中的上传者、原文件名、访问权限和有效期在回调时生效
+func (s *ossService) PresignPut(opts PresignOptions, uploadOpts ...UploadOption) (*PresignedUpload, error) {
+	key, token, acl, err := s.presign(&opts, uploadOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{
+		oss.HTTPHeaderOssObjectACL: string(acl),
+	}
+	signOpts := []oss.Option{oss.ObjectACL(acl)}
+	if opts.ContentType != "" {
+		headers[oss.HTTPHeaderContentType] = opts.ContentType
+		signOpts = append(signOpts, oss.ContentType(opts.ContentType))
+	}
+
+	uploadKey := presignStagingPrefix + key
+	signedURL, err := s.storage.bucket.SignURL(uploadKey, oss.HTTPPut, int64(opts.Expires/time.Second), signOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign OSS put url: %v", err)
+	}
+
+	return &PresignedUpload{
+		Key:        key,
+		UploadKey:  uploadKey,
+		Token:      token,
+		URL:        signedURL,
+		Method:     http.MethodPut,
+		Headers:    headers,
+		Expiration: time.Now().Add(opts.Expires),
+	}, nil
+}
+
+// PresignPost 生成浏览器表单直传的 POST 策略，OSS 会校验 key、Content-Type 和文件大小，uploadOpts 同 PresignPut
+func (s *ossService) PresignPost(opts PresignOptions, uploadOpts ...UploadOption) (*PresignedUpload, error) {
+	key, token, acl, err := s.presign(&opts, uploadOpts)
+	if err != nil {
+		return nil, err
+	}
+	expiration := time.Now().Add(opts.Expires).UTC()
+	uploadKey := presignStagingPrefix + key
+
+	conditions := []interface{}{
+		map[string]string{"bucket": s.storage.bucket.BucketName},
+		[]interface{}{"eq", "$key", uploadKey},
+		[]interface{}{"eq", "$x-oss-object-acl", string(acl)},
+	}
+	if opts.MaxSize > 0 {
+		conditions = append(conditions, []interface{}{"content-length-range", 0, opts.MaxSize})
+	}
+	if opts.ContentType != "" {
+		conditions = append(conditions, []interface{}{"eq", "$Content-Type", opts.ContentType})
+	}
+
+	policyJSON, err := json.Marshal(map[string]interface{}{
+		"expiration": expiration.Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal OSS post policy: %v", err)
+	}
+	policy := base64.StdEncoding.EncodeToString(policyJSON)
+
+	mac := hmac.New(sha1.New, []byte(s.storage.accessKeySecret))
+	mac.Write([]byte(policy))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	fields := map[string]string{
+		"key":                   uploadKey,
+		"OSSAccessKeyId":        s.storage.accessKeyId,
+		"policy":                policy,
+		"Signature":             signature,
+		"success_action_status": "200",
+		"x-oss-object-acl":      string(acl),
+	}
+	if opts.ContentType != "" {
+		fields["Content-Type"] = opts.ContentType
+	}
+
+	return &PresignedUpload{
+		Key:        key,
+		UploadKey:  uploadKey,
+		Token:      token,
+		URL:        s.storage.baseUrl,
+		Method:     http.MethodPost,
+		Fields:     fields,
+		Expiration: expiration,
+	}, nil
+}
+
+// VerifyUpload 校验直传是否完成，将临时对象移动到最终 key，生成衍生图并保存文件记录、计入配额用量，与 UploadFile 一致。
+// token 为签发时返回的回调凭证，owner 为当前用户，必须与签发时的上传者一致。
+// 超过大小限制、内容与扩展名不一致或超出配额的对象会被删除。返回对象的访问地址和信息
+func (s *ossService) VerifyUpload(token, owner string) (string, *FileInfo, error) {
+	issued, err := s.parsePresignToken(token)
+	if err != nil {
+		return "", nil, err
+	}
+	if issued.Owner != owner {
+		return "", nil, fmt.Errorf("%w: presign token was issued to another user", ErrAccessDenied)
+	}
+	key := s.cleanKey(issued.Key)
+	if !strings.HasPrefix(key, s.dir+"/") {
+		return "", nil, kindErrorf(ErrInvalidArgument, "key '%s' is outside of upload directory '%s'", key, s.dir)
+	}
+
+	opts := uploadOptions{owner: issued.Owner, originalName: issued.OriginalName, acl: issued.ACL, ttl: issued.TTL}
+	uploadKey := presignStagingPrefix + key
+	info, err := s.storage.Stat(uploadKey)
+	if errors.Is(err, ErrNotFound) {
+		// 重复回调时临时对象已移动
+		info, err = s.storage.Stat(key)
+	} else if err == nil {
+		info, err = s.accept(uploadKey, key, info, issued.MaxSize, opts)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	header, err := s.readHeader(key)
+	if err != nil {
+		return "", nil, err
+	}
+	if err = s.storeDerivatives(key, opts); err != nil {
+		return "", nil, err
+	}
+	// 重复回调时记录已存在，同一上传者不会重复计入用量
+	if _, err = s.commit(key, info.Size, "", header, opts); err != nil {
+		return "", nil, err
+	}
+	return s.fileURL(key), info, nil
+}
+
+// accept 校验临时对象的大小和内容，通过后移动到 key，按上传选项设置访问权限等属性；未通过时删除临时对象
+func (s *ossService) accept(uploadKey, key string, info *FileInfo, maxSize int64, opts uploadOptions) (*FileInfo, error) {
+	if maxSize > 0 && info.Size > maxSize {
+		if err := s.storage.Delete(uploadKey); err != nil {
+			return nil, err
+		}
+		return nil, kindErrorf(ErrInvalidArgument, "file size %d exceeds maximum limit of %d", info.Size, maxSize)
+	}
+
+	// 校验文件内容与扩展名一致
+	header, err := s.readHeader(uploadKey)
+	if err != nil {
+		return nil, err
+	}
+	if ext := path.Ext(key); !MatchType(header, info.Size, ext) {
+		if err = s.storage.Delete(uploadKey); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", ErrContentMismatch, ext)
+	}
+
+	if err = s.storage.moveObject(uploadKey, key, info.Size, ossPutOptions(s.putOptions(key, header, opts))); err != nil {
+		return nil, err
+	}
+	return s.storage.Stat(key)
+}
+
+// readHeader 读取已上传对象的文件头
+func (s *ossService) readHeader(key string) ([]byte, error) {
+	file, err := s.OpenFile(key)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, err := io.ReadAll(io.LimitReader(file, SniffLen))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file header: %v", err)
+	}
+	return header, nil
+}
@@ -0,0 +1,201 @@
+package upload
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+)
+
+func newTestOssService(t *testing.T) OssService {
+	svc, err := NewOssService("https://assets.oss-cn-hangzhou.aliyuncs.com", "goods",
+		WithOssConfig("oss-cn-hangzhou.aliyuncs.com", "test-id", "test-secret", "assets",
+			"https://assets.oss-cn-hangzhou.aliyuncs.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+func TestPresignPut(t *testing.T) {
+	svc := newTestOssService(t)
+
+	presigned, err := svc.PresignPut(PresignOptions{Filename: "a.png", ContentType: "image/png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if presigned.Method != http.MethodPut || !strings.HasPrefix(presigned.Key, "goods/") || !strings.HasSuffix(presigned.Key, ".png") {
+		t.Fatalf("unexpected presigned upload: %+v", presigned)
+	}
+
+	u, err := url.Parse(presigned.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/"+presigned.UploadKey || u.Query().Get("Signature") == "" || u.Query().Get("OSSAccessKeyId") != "test-id" {
+		t.Errorf("unexpected signed url: %s", presigned.URL)
+	}
+	if presigned.UploadKey != presignStagingPrefix+presigned.Key {
+		t.Errorf("unexpected upload key %s", presigned.UploadKey)
+	}
+	if presigned.Headers["Content-Type"] != "image/png" {
+		t.Errorf("content type header missing: %+v", presigned.Headers)
+	}
+}
+
+func TestPresignPost(t *testing.T) {
+	svc := newTestOssService(t)
+
+	presigned, err := svc.PresignPost(PresignOptions{Filename: "a.png", ContentType: "image/png", MaxSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if presigned.URL != "https://assets.oss-cn-hangzhou.aliyuncs.com" || presigned.Fields["key"] != presigned.UploadKey {
+		t.Fatalf("unexpected presigned upload: %+v", presigned)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(presigned.Fields["policy"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	conditions := string(data)
+	for _, want := range []string{`["content-length-range",0,1024]`, `["eq","$Content-Type","image/png"]`, `["eq","$key","` + presigned.UploadKey + `"]`} {
+		if !strings.Contains(conditions, want) {
+			t.Errorf("policy %s missing condition %s", conditions, want)
+		}
+	}
+}
+
+func TestPresignPrivate(t *testing.T) {
+	svc := newTestOssService(t)
+
+	presigned, err := svc.PresignPost(PresignOptions{Filename: "a.png"}, WithACL(ACLPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if presigned.Fields["x-oss-object-acl"] != "private" || presigned.Token == "" {
+		t.Errorf("unexpected presigned upload: %+v", presigned)
+	}
+}
+
+func TestVerifyUpload(t *testing.T) {
+	fake := newFakeOSS()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	repo := NewMemoryFileRepository()
+	quotas := NewMemoryQuotaStore()
+	svc, err := NewOssService(srv.URL, "goods", WithOssConfig(srv.URL, "test-id", "test-secret", "assets", srv.URL),
+		WithFileRepository(repo), WithQuota(quotas, StaticQuota(Quota{MaxFiles: 10})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	presigned, err := svc.PresignPut(PresignOptions{Filename: "photo.png", MaxSize: 1 << 20},
+		WithOwner("alice"), WithACL(ACLPrivate), WithOriginalName("photo.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.objects[presigned.UploadKey] = pngBytes(t, 4, 4)
+
+	// 凭证只能由签发时的上传者使用，篡改后无效，校验失败时不删除对象
+	if _, _, err = svc.VerifyUpload(presigned.Token, "bob"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected permission denied for other user, got %v", err)
+	}
+	if _, _, err = svc.VerifyUpload(presigned.Token+"x", "alice"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected permission denied for tampered token, got %v", err)
+	}
+	if _, _, err = svc.VerifyUpload("goods/"+path.Base(presigned.Key), "alice"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected permission denied for bare key, got %v", err)
+	}
+	if fake.objects[presigned.UploadKey] == nil {
+		t.Fatal("object should not be deleted by rejected callbacks")
+	}
+
+	fileURL, info, err := svc.VerifyUpload(presigned.Token, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileURL != srv.URL+"/"+presigned.Key || info.Size != int64(len(fake.objects[presigned.Key])) {
+		t.Errorf("unexpected result %s, %+v", fileURL, info)
+	}
+	// 校验通过后移动到最终 key，签名 URL 再次写入不会覆盖已校验的对象
+	if _, ok := fake.objects[presigned.UploadKey]; ok {
+		t.Error("staging object should be moved")
+	}
+	record, err := svc.GetRecord(fileURL)
+	if err != nil || record.Owner != "alice" || record.ACL != ACLPrivate || record.OriginalName != "photo.png" {
+		t.Fatalf("record = %+v, %v", record, err)
+	}
+
+	// 重复回调不重复计入用量
+	if _, _, err = svc.VerifyUpload(presigned.Token, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if usage, _, _ := svc.GetUsage("alice"); usage.Files != 1 || usage.Bytes != info.Size {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	// 内容与扩展名不一致时删除对象
+	mismatch, err := svc.PresignPut(PresignOptions{Filename: "fake.png"}, WithOwner("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.objects[mismatch.UploadKey] = []byte("not a png")
+	if _, _, err = svc.VerifyUpload(mismatch.Token, "alice"); !errors.Is(err, ErrContentMismatch) {
+		t.Errorf("expected content mismatch, got %v", err)
+	}
+	_, staged := fake.objects[mismatch.UploadKey]
+	_, moved := fake.objects[mismatch.Key]
+	if staged || moved {
+		t.Error("mismatched object should be deleted")
+	}
+
+	// 未上传时不保存记录
+	missing, err := svc.PresignPut(PresignOptions{Filename: "missing.png"}, WithOwner("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = svc.VerifyUpload(missing.Token, "alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestVerifyUploadDerivatives(t *testing.T) {
+	fake := newFakeOSS()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	thumb := ImageVariant{Name: "thumb", Width: 2, Height: 2}
+	svc, err := NewOssService(srv.URL, "goods", WithOssConfig(srv.URL, "test-id", "test-secret", "assets", srv.URL),
+		WithImageVariants(thumb))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	presigned, err := svc.PresignPost(PresignOptions{Filename: "photo.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.objects[presigned.UploadKey] = pngBytes(t, 4, 4)
+	fileURL, _, err := svc.VerifyUpload(presigned.Token, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.objects[derivativeKey(presigned.Key, thumb)] == nil {
+		t.Errorf("derivative of %s not stored: %v", presigned.Key, svc.DerivativeURLs(fileURL))
+	}
+}
+
+func TestDefaultCorsRuleAllowsUpload(t *testing.T) {
+	methods := strings.Join(defaultCorsRule.AllowedMethod, ",")
+	for _, want := range []string{http.MethodPut, http.MethodPost} {
+		if !strings.Contains(methods, want) {
+			t.Errorf("default cors rule %s does not allow %s", methods, want)
+		}
+	}
+}
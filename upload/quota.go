@@ -0,0 +1,226 @@
+package upload
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Quota 存储配额，0 表示不限制
+type Quota struct {
+	MaxBytes int64 `json:"max_bytes"`
+	MaxFiles int64 `json:"max_files"`
+}
+
+// Usage 已使用的存储
+type Usage struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+}
+
+// QuotaFunc 根据上传者返回计费主体和配额，例如按用户所属租户合并计算。
+// 返回的主体为空时不限制
+type QuotaFunc func(owner string) (principal string, quota Quota)
+
+// QuotaLimit 一个计费主体的配额
+type QuotaLimit struct {
+	Principal string
+	Quota     Quota
+}
+
+// QuotaLimitsFunc 根据上传者返回所有需要计费的主体，例如同时限制用户和所属租户，
+// 上传需要满足所有主体的配额，主体为空的项被忽略
+type QuotaLimitsFunc func(owner string) []QuotaLimit
+
+// StaticQuota 每个上传者使用相同的配额
+func StaticQuota(quota Quota) QuotaFunc {
+	return func(owner string) (string, Quota) {
+		return owner, quota
+	}
+}
+
+// QuotaStore 配额用量存储，可替换为 Redis、数据库等实现以便多实例共享
+type QuotaStore interface {
+	// Reserve 增加用量，增加后超过配额时不修改并返回 ErrQuotaExceeded，bytes 可以为负数
+	Reserve(principal string, bytes, files int64, quota Quota) error
+	// Release 减少用量
+	Release(principal string, bytes, files int64) error
+	Usage(principal string) (Usage, error)
+}
+
+type memoryQuotaStore struct {
+	mu     sync.Mutex
+	usages map[string]Usage
+}
+
+// NewMemoryQuotaStore 创建内存配额存储，仅适用于单实例部署
+func NewMemoryQuotaStore() QuotaStore {
+	return &memoryQuotaStore{
+		usages: make(map[string]Usage),
+	}
+}
+
+func (m *memoryQuotaStore) Reserve(principal string, bytes, files int64, quota Quota) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usage := m.usages[principal]
+	usage.Bytes += bytes
+	usage.Files += files
+	if err := quota.check(usage, bytes, files); err != nil {
+		return err
+	}
+	m.usages[principal] = usage
+	return nil
+}
+
+func (m *memoryQuotaStore) Release(principal string, bytes, files int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usage := m.usages[principal]
+	usage.Bytes = max(usage.Bytes-bytes, 0)
+	usage.Files = max(usage.Files-files, 0)
+	m.usages[principal] = usage
+	return nil
+}
+
+func (m *memoryQuotaStore) Usage(principal string) (Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usages[principal], nil
+}
+
+// check 检查增加后的用量，只在用量增加时检查，避免已超额的主体无法覆盖或缩小文件
+func (q Quota) check(usage Usage, bytes, files int64) error {
+	if q.MaxBytes > 0 && bytes > 0 && usage.Bytes > q.MaxBytes {
+		return kindErrorf(ErrQuotaExceeded, "storage quota exceeded: %d bytes exceeds limit of %d bytes", usage.Bytes, q.MaxBytes)
+	}
+	if q.MaxFiles > 0 && files > 0 && usage.Files > q.MaxFiles {
+		return kindErrorf(ErrQuotaExceeded, "storage quota exceeded: %d files exceeds limit of %d files", usage.Files, q.MaxFiles)
+	}
+	return nil
+}
+
+// CheckQuota 检查上传者再上传 size 字节的文件是否会超出配额，用于在接收文件前提前拒绝
+func (s *service) CheckQuota(owner string, size int64) error {
+	for _, limit := range s.quotaLimits(owner) {
+		usage, err := s.opts.quotaStore.Usage(limit.Principal)
+		if err != nil {
+			return fmt.Errorf("获取配额用量失败: %w", err)
+		}
+		usage.Bytes += size
+		usage.Files++
+		if err = limit.Quota.check(usage, size, 1); err != nil {
+			return fmt.Errorf("%s: %w", limit.Principal, err)
+		}
+	}
+	return nil
+}
+
+// GetUsage 返回上传者所属主体的用量和配额，有多个主体时返回第一个
+func (s *service) GetUsage(owner string) (Usage, Quota, error) {
+	limits := s.quotaLimits(owner)
+	if len(limits) == 0 {
+		return Usage{}, Quota{}, nil
+	}
+	usage, err := s.opts.quotaStore.Usage(limits[0].Principal)
+	return usage, limits[0].Quota, err
+}
+
+// quotaLimits 返回上传者需要计费的主体，未配置配额时为空
+func (s *service) quotaLimits(owner string) []QuotaLimit {
+	if s.opts.quotaStore == nil || owner == "" {
+		return nil
+	}
+	var limits []QuotaLimit
+	for _, limit := range s.opts.quotaLimits(owner) {
+		if limit.Principal != "" {
+			limits = append(limits, limit)
+		}
+	}
+	return limits
+}
+
+// quotaDelta 一个主体的用量变化
+type quotaDelta struct {
+	principal    string
+	bytes, files int64
+	quota        Quota
+}
+
+// quotaDeltas 计算上传 size 字节的文件后各主体的用量变化。prev 为同一 key 之前的记录（覆盖或移动），
+// 同一主体只计大小差值，不同主体时用量转移给新的上传者
+func (s *service) quotaDeltas(owner string, size int64, prev *FileRecord) []quotaDelta {
+	var deltas []quotaDelta
+	index := make(map[string]int)
+	add := func(limit QuotaLimit, bytes, files int64, limited bool) {
+		i, ok := index[limit.Principal]
+		if !ok {
+			i = len(deltas)
+			index[limit.Principal] = i
+			deltas = append(deltas, quotaDelta{principal: limit.Principal})
+		}
+		deltas[i].bytes += bytes
+		deltas[i].files += files
+		if limited {
+			deltas[i].quota = limit.Quota
+		}
+	}
+
+	for _, limit := range s.quotaLimits(owner) {
+		add(limit, size, 1, true)
+	}
+	if prev != nil {
+		for _, limit := range s.quotaLimits(prev.Owner) {
+			add(limit, -prev.Size, -1, false)
+		}
+	}
+	return deltas
+}
+
+// applyQuota 按变化更新用量，任一主体超出配额时撤销已更新的主体
+func (s *service) applyQuota(deltas []quotaDelta) error {
+	for i, d := range deltas {
+		var err error
+		if d.bytes > 0 || d.files > 0 {
+			err = s.opts.quotaStore.Reserve(d.principal, d.bytes, d.files, d.quota)
+		} else {
+			err = s.opts.quotaStore.Release(d.principal, -d.bytes, -d.files)
+		}
+		if err != nil {
+			s.revertQuota(deltas[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// revertQuota 撤销 applyQuota 的用量变化，忽略错误
+func (s *service) revertQuota(deltas []quotaDelta) {
+	for _, d := range deltas {
+		if d.bytes > 0 || d.files > 0 {
+			s.opts.quotaStore.Release(d.principal, d.bytes, d.files)
+		} else {
+			s.opts.quotaStore.Reserve(d.principal, -d.bytes, -d.files, Quota{})
+		}
+	}
+}
+
+// reserveQuota 上传完成后计入用量，返回撤销函数，保存记录失败时调用以释放预留的用量
+func (s *service) reserveQuota(owner string, size int64, prev *FileRecord) (func(), error) {
+	deltas := s.quotaDeltas(owner, size, prev)
+	if err := s.applyQuota(deltas); err != nil {
+		return nil, err
+	}
+	return func() { s.revertQuota(deltas) }, nil
+}
+
+// releaseQuota 删除文件后释放用量
+func (s *service) releaseQuota(record *FileRecord) error {
+	for _, limit := range s.quotaLimits(record.Owner) {
+		if err := s.opts.quotaStore.Release(limit.Principal, record.Size, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
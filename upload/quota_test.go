@@ -0,0 +1,149 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	mem := newMemStorage()
+	svc, err := New("", "goods", WithStorage(mem), WithFileRepository(NewMemoryFileRepository()),
+		WithQuota(NewMemoryQuotaStore(), StaticQuota(Quota{MaxBytes: 10, MaxFiles: 2})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = svc.UploadFile(strings.NewReader("123456"), "a.txt", WithOwner("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err = svc.CheckQuota("1", 6); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("CheckQuota should fail, got %v", err)
+	}
+	if err = svc.CheckQuota("2", 6); err != nil {
+		t.Errorf("other owners have their own quota: %v", err)
+	}
+
+	// 实际大小超出配额时删除已上传的文件
+	if _, err = svc.UploadFile(strings.NewReader("123456"), "b.txt", WithOwner("1")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if _, ok := mem.objects["goods/b.txt"]; ok {
+		t.Error("rejected upload should be removed")
+	}
+
+	// 未设置上传者时不限制
+	if _, err = svc.UploadFile(strings.NewReader("12345678901"), "c.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, quota, err := svc.GetUsage("1")
+	if err != nil || usage != (Usage{Bytes: 6, Files: 1}) || quota.MaxBytes != 10 {
+		t.Errorf("unexpected usage %+v quota %+v err %v", usage, quota, err)
+	}
+
+	if err = svc.DeleteFile("goods/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if usage, _, _ = svc.GetUsage("1"); usage != (Usage{}) {
+		t.Errorf("usage should be released after delete, got %+v", usage)
+	}
+}
+
+func TestTenantQuota(t *testing.T) {
+	// 用户 1、2 属于同一租户
+	tenants := map[string]string{"1": "tenant-a", "2": "tenant-a"}
+	svc, err := New("", "goods", WithStorage(newMemStorage()), WithFileRepository(NewMemoryFileRepository()),
+		WithQuota(NewMemoryQuotaStore(), func(owner string) (string, Quota) {
+			return tenants[owner], Quota{MaxFiles: 1}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = svc.UploadFile(strings.NewReader("a"), "a.txt", WithOwner("1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("b"), "b.txt", WithOwner("2")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected tenant quota to be shared, got %v", err)
+	}
+
+	// 覆盖同一文件不增加文件数
+	if _, err = svc.UploadFile(strings.NewReader("aa"), "a.txt", WithOwner("1")); err != nil {
+		t.Errorf("overwrite should not count as a new file: %v", err)
+	}
+	if usage, _, _ := svc.GetUsage("2"); usage != (Usage{Bytes: 2, Files: 1}) {
+		t.Errorf("unexpected tenant usage %+v", usage)
+	}
+}
+
+func TestQuotaRequiresRepository(t *testing.T) {
+	_, err := New("", "goods", WithStorage(newMemStorage()), WithQuota(NewMemoryQuotaStore(), StaticQuota(Quota{MaxFiles: 1})))
+	if err == nil {
+		t.Error("expected error without file repository")
+	}
+}
+
+func TestQuotaLimits(t *testing.T) {
+	// 每个用户最多 2 个文件，租户合计最多 3 个文件
+	quotas := NewMemoryQuotaStore()
+	svc, err := New("", "goods", WithStorage(newMemStorage()), WithFileRepository(NewMemoryFileRepository()),
+		WithQuotaLimits(quotas, func(owner string) []QuotaLimit {
+			return []QuotaLimit{
+				{Principal: "user:" + owner, Quota: Quota{MaxFiles: 2}},
+				{Principal: "tenant:a", Quota: Quota{MaxFiles: 3}},
+			}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, owner := range []string{"1", "1", "2"} {
+		if _, err = svc.UploadFile(strings.NewReader("x"), fmt.Sprintf("%d.txt", i), WithOwner(owner)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = svc.UploadFile(strings.NewReader("x"), "3.txt", WithOwner("1")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected user quota exceeded, got %v", err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("x"), "4.txt", WithOwner("2")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected tenant quota exceeded, got %v", err)
+	}
+
+	// 租户超额时撤销已计入的用户用量
+	if usage, _ := quotas.Usage("user:2"); usage.Files != 1 {
+		t.Errorf("user usage should be reverted, got %+v", usage)
+	}
+	if usage, _ := quotas.Usage("tenant:a"); usage.Files != 3 {
+		t.Errorf("unexpected tenant usage %+v", usage)
+	}
+}
+
+// failingRepository 保存记录失败的文件记录仓库
+type failingRepository struct {
+	FileRepository
+}
+
+func (failingRepository) Save(*FileRecord) error {
+	return errors.New("database unavailable")
+}
+
+func TestQuotaReleasedOnRecordFailure(t *testing.T) {
+	mem := newMemStorage()
+	svc, err := New("", "goods", WithStorage(mem), WithFileRepository(failingRepository{NewMemoryFileRepository()}),
+		WithQuota(NewMemoryQuotaStore(), StaticQuota(Quota{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = svc.UploadFile(strings.NewReader("hello"), "a.txt", WithOwner("1")); err == nil {
+		t.Fatal("expected record error")
+	}
+	if usage, _, _ := svc.GetUsage("1"); usage != (Usage{}) {
+		t.Errorf("usage should be released, got %+v", usage)
+	}
+	if len(mem.objects) != 0 {
+		t.Errorf("unrecorded file should be removed, left %d objects", len(mem.objects))
+	}
+}
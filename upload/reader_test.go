@@ -0,0 +1,121 @@
+package upload
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpenFileLocal(t *testing.T) {
+	svc, err := New("http://127.0.0.1:3000", "goods", WithDriverParam(ParamRoot, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("0123456789"), "a.mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := svc.OpenFile("uploads/goods/a.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if file.Size != 10 || file.ModTime.IsZero() {
+		t.Errorf("unexpected size %d, mod time %v", file.Size, file.ModTime)
+	}
+	if _, err = file.Seek(7, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(file)
+	if string(data) != "789" {
+		t.Errorf("read after seek = %q", data)
+	}
+}
+
+func TestOpenFileAttrs(t *testing.T) {
+	svc, err := New("http://127.0.0.1:3000", "goods", WithDriverParam(ParamRoot, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("a,b"), "报表.csv",
+		WithContentType("text/csv"),
+		WithCacheControl("no-store"),
+		WithContentDisposition(DispositionAttachment),
+		WithMetadata(map[string]string{"owner": "42"})); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := svc.OpenFile("uploads/goods/报表.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	expected := ObjectAttrs{
+		ContentType:        "text/csv",
+		CacheControl:       "no-store",
+		ContentDisposition: "attachment; filename*=utf-8''%E6%8A%A5%E8%A1%A8.csv",
+		Metadata:           map[string]string{"owner": "42"},
+	}
+	if !reflect.DeepEqual(file.ObjectAttrs, expected) {
+		t.Errorf("unexpected attrs: %+v", file.ObjectAttrs)
+	}
+
+	// 删除文件时同时删除属性，重新上传不继承旧的属性
+	if err = svc.DeleteFile("uploads/goods/报表.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("a,b"), "报表.csv"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := svc.(*service).storage.Stat("uploads/goods/报表.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CacheControl != "" || info.ContentDisposition != "" || info.Metadata != nil {
+		t.Errorf("stale attrs: %+v", info.ObjectAttrs)
+	}
+}
+
+func TestOpenFileRange(t *testing.T) {
+	_, server := newFakeS3("assets")
+	defer server.Close()
+
+	svc, err := NewS3Service("http://cdn.example.com", "videos",
+		WithS3Config(server.URL, "", testS3AccessKeyId, testS3AccessKeySecret, "assets"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.UploadFile(strings.NewReader("0123456789"), "a.mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := svc.OpenFile("videos/a.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, ok := file.ReadSeekCloser.(*rangeReader); !ok {
+		t.Fatalf("expected range reader, got %T", file.ReadSeekCloser)
+	}
+
+	buf := make([]byte, 3)
+	if _, err = file.Seek(2, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(file, buf); err != nil || string(buf) != "234" {
+		t.Fatalf("read = %q, %v", buf, err)
+	}
+
+	// 向后跳转需要重新发起 Range 请求
+	if _, err = file.Seek(-2, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil || string(data) != "89" {
+		t.Fatalf("read tail = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,379 @@
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuominmin/biz/pagination"
+)
+
+// 文件分类
+const (
+	FileTypeImage  = "image"
+	FileTypeModel  = "model"
+	FileTypeVideo  = "video"
+	FileTypeZip    = "zip"
+	FileTypeImport = "import"
+	FileTypeOther  = "other"
+)
+
+// ErrRecordNotFound 文件记录不存在
+var ErrRecordNotFound = newKindError(ErrNotFound, "file record not found")
+
+// FileRecord 上传文件记录
+type FileRecord struct {
+	Key          string    `json:"key"`
+	URL          string    `json:"url"`
+	Owner        string    `json:"owner,omitempty"`
+	Size         int64     `json:"size"`
+	Hash         string    `json:"hash"` // 内容的 SHA-256，浏览器直传的文件为空
+	ContentType  string    `json:"content_type"`
+	Type         string    `json:"type"` // 文件分类，见 FileTypeImage 等
+	OriginalName string    `json:"original_name"`
+	CreatedAt    time.Time `json:"created_at"`
+	// 临时文件的过期时间，为零值时为永久文件
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	// 访问权限，为空时使用驱动的默认权限
+	ACL ACL `json:"acl,omitempty"`
+	// 内容寻址时其他上传者对同一对象的引用，Owner 等字段为第一个上传者。
+	// 删除或过期时只移除对应上传者的引用，没有引用时才删除对象
+	Refs []FileRef `json:"refs,omitempty"`
+}
+
+// FileRef 内容寻址时对已有对象的一次上传
+type FileRef struct {
+	Owner        string    `json:"owner,omitempty"`
+	OriginalName string    `json:"original_name"`
+	CreatedAt    time.Time `json:"created_at"`
+	// 该上传者的临时文件过期时间，为零值时为永久引用
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// RefCount 引用对象的上传数
+func (r *FileRecord) RefCount() int {
+	return 1 + len(r.Refs)
+}
+
+// HasOwner owner 是否为上传者，内容寻址时包括引用了该对象的上传者
+func (r *FileRecord) HasOwner(owner string) bool {
+	return r.hasRef(owner)
+}
+
+// hasRef owner 是否引用了该对象
+func (r *FileRecord) hasRef(owner string) bool {
+	return r.refIndex(owner) >= -1
+}
+
+// refIndex 返回 owner 的引用在 Refs 中的位置，第一个上传者为 -1，没有引用时为 -2
+func (r *FileRecord) refIndex(owner string) int {
+	if r.Owner == owner {
+		return -1
+	}
+	for i, ref := range r.Refs {
+		if ref.Owner == owner {
+			return i
+		}
+	}
+	return -2
+}
+
+// expiresAt 返回 owner 引用的过期时间，零值表示永久
+func (r *FileRecord) expiresAt(owner string) time.Time {
+	if i := r.refIndex(owner); i >= 0 {
+		return r.Refs[i].ExpiresAt
+	}
+	return r.ExpiresAt
+}
+
+// setExpiresAt 设置 owner 引用的过期时间
+func (r *FileRecord) setExpiresAt(owner string, expiresAt time.Time) {
+	switch i := r.refIndex(owner); {
+	case i == -1:
+		r.ExpiresAt = expiresAt
+	case i >= 0:
+		r.Refs[i].ExpiresAt = expiresAt
+	}
+}
+
+// expiredOwners 返回在 before 之前过期的上传者
+func (r *FileRecord) expiredOwners(before time.Time) []string {
+	var owners []string
+	if !r.ExpiresAt.IsZero() && r.ExpiresAt.Before(before) {
+		owners = append(owners, r.Owner)
+	}
+	for _, ref := range r.Refs {
+		if !ref.ExpiresAt.IsZero() && ref.ExpiresAt.Before(before) {
+			owners = append(owners, ref.Owner)
+		}
+	}
+	return owners
+}
+
+// removeRef 移除 owner 的引用，第一个上传者被移除时由下一个引用接替
+func (r *FileRecord) removeRef(owner string) {
+	switch i := r.refIndex(owner); {
+	case i == -1 && len(r.Refs) > 0:
+		next := r.Refs[0]
+		r.Owner, r.OriginalName, r.CreatedAt, r.ExpiresAt = next.Owner, next.OriginalName, next.CreatedAt, next.ExpiresAt
+		r.Refs = r.Refs[1:]
+	case i >= 0:
+		r.Refs = append(r.Refs[:i:i], r.Refs[i+1:]...)
+	}
+}
+
+// RecordFilter 文件记录查询条件，零值表示不限制
+type RecordFilter struct {
+	// 上传者，内容寻址时也匹配引用了该对象的上传者
+	Owner string
+	Type  string
+	// 上传时间范围 [From, To)
+	From time.Time
+	To   time.Time
+	// 在该时间之前过期的临时文件，内容寻址时任一引用过期即匹配
+	ExpiredBefore time.Time
+}
+
+// Match 记录是否满足查询条件
+func (f RecordFilter) Match(record *FileRecord) bool {
+	switch {
+	case f.Owner != "" && !record.hasRef(f.Owner):
+		return false
+	case f.Type != "" && record.Type != f.Type:
+		return false
+	case !f.From.IsZero() && record.CreatedAt.Before(f.From):
+		return false
+	case !f.To.IsZero() && !record.CreatedAt.Before(f.To):
+		return false
+	case !f.ExpiredBefore.IsZero() && len(record.expiredOwners(f.ExpiredBefore)) == 0:
+		return false
+	}
+	return true
+}
+
+// FileRepository 文件记录存储，可替换为数据库实现
+type FileRepository interface {
+	// Save 保存记录，key 已存在时覆盖
+	Save(record *FileRecord) error
+	// Get 获取记录，不存在时返回 ErrRecordNotFound
+	Get(key string) (*FileRecord, error)
+	Delete(key string) error
+	// Find 按上传时间倒序分页查询，返回当前页记录和总数
+	Find(filter RecordFilter, page pagination.PageReq) ([]FileRecord, int64, error)
+}
+
+// RecordBatchGetter 可以一次获取多个文件记录的 FileRepository，例如数据库的 IN 查询。
+// 列出文件时用于判断访问权限，未实现时逐个调用 Get
+type RecordBatchGetter interface {
+	// GetMany 按 key 返回已存在的记录，不存在的 key 不出现在结果中
+	GetMany(keys []string) (map[string]*FileRecord, error)
+}
+
+type memoryFileRepository struct {
+	mu      sync.RWMutex
+	records map[string]FileRecord
+}
+
+// NewMemoryFileRepository 创建内存文件记录存储，仅适用于单实例部署
+func NewMemoryFileRepository() FileRepository {
+	return &memoryFileRepository{
+		records: make(map[string]FileRecord),
+	}
+}
+
+func (m *memoryFileRepository) Save(record *FileRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[record.Key] = *record
+	return nil
+}
+
+func (m *memoryFileRepository) Get(key string) (*FileRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	record, ok := m.records[key]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return &record, nil
+}
+
+func (m *memoryFileRepository) GetMany(keys []string) (map[string]*FileRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	records := make(map[string]*FileRecord, len(keys))
+	for _, key := range keys {
+		if record, ok := m.records[key]; ok {
+			records[key] = &record
+		}
+	}
+	return records, nil
+}
+
+func (m *memoryFileRepository) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, key)
+	return nil
+}
+
+func (m *memoryFileRepository) Find(filter RecordFilter, page pagination.PageReq) ([]FileRecord, int64, error) {
+	m.mu.RLock()
+	var matched []FileRecord
+	for _, record := range m.records {
+		if filter.Match(&record) {
+			matched = append(matched, record)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].CreatedAt.After(matched[j].CreatedAt)
+		}
+		return matched[i].Key < matched[j].Key
+	})
+
+	page.Sanitize()
+	total := int64(len(matched))
+	offset := int64(page.Offset())
+	if offset >= total {
+		return []FileRecord{}, total, nil
+	}
+	end := min(offset+int64(page.PageSize), total)
+	return matched[offset:end], total, nil
+}
+
+// FileType 根据扩展名返回文件分类
+func FileType(filename string) string {
+	ext := strings.ToLower(path.Ext(filename))
+	for fileType, types := range map[string][]string{
+		FileTypeImage:  ImgTypes,
+		FileTypeModel:  ModelTypes,
+		FileTypeVideo:  VideoTypes,
+		FileTypeZip:    ZipTypes,
+		FileTypeImport: ImportTypes,
+	} {
+		for _, t := range types {
+			if t == ext {
+				return fileType
+			}
+		}
+	}
+	return FileTypeOther
+}
+
+// GetRecord 根据访问地址或 key 获取文件记录
+func (s *service) GetRecord(fileURL string) (*FileRecord, error) {
+	if s.opts.repository == nil {
+		return nil, ErrRecordNotFound
+	}
+	return s.opts.repository.Get(s.keyFromURL(fileURL))
+}
+
+// FindRecords 分页查询文件记录
+func (s *service) FindRecords(filter RecordFilter, page pagination.PageReq) ([]FileRecord, int64, error) {
+	if s.opts.repository == nil {
+		return []FileRecord{}, 0, nil
+	}
+	return s.opts.repository.Find(filter, page)
+}
+
+// saveRecord 上传完成后保存文件记录，prev 为同一 key 之前的记录
+func (s *service) saveRecord(key string, size int64, hash string, header []byte, opts uploadOptions, prev *FileRecord) error {
+	if s.opts.repository == nil {
+		return nil
+	}
+
+	now := time.Now()
+	var expiresAt time.Time
+	// 已是永久文件时（例如内容寻址的重复上传）不能再变为临时文件
+	if opts.ttl > 0 && (prev == nil || !prev.ExpiresAt.IsZero()) {
+		expiresAt = now.Add(opts.ttl)
+	}
+
+	return s.opts.repository.Save(&FileRecord{
+		Key:          key,
+		URL:          s.fileURL(key),
+		Owner:        opts.owner,
+		Size:         size,
+		Hash:         hash,
+		ContentType:  s.contentType(key, header, opts),
+		Type:         FileType(key),
+		OriginalName: opts.originalName,
+		CreatedAt:    now,
+		ExpiresAt:    expiresAt,
+		ACL:          opts.acl,
+	})
+}
+
+// lookupRecord 获取 key 当前的文件记录，没有记录或未配置仓库时返回 nil
+func (s *service) lookupRecord(key string) (*FileRecord, error) {
+	if s.opts.repository == nil {
+		return nil, nil
+	}
+	record, err := s.opts.repository.Get(key)
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil, nil
+	}
+	return record, err
+}
+
+// lookupRecords 获取多个 key 的文件记录，仓库实现 RecordBatchGetter 时一次获取，没有记录的 key 不出现在结果中
+func (s *service) lookupRecords(keys []string) (map[string]*FileRecord, error) {
+	if s.opts.repository == nil || len(keys) == 0 {
+		return map[string]*FileRecord{}, nil
+	}
+	if getter, ok := s.opts.repository.(RecordBatchGetter); ok {
+		return getter.GetMany(keys)
+	}
+	records := make(map[string]*FileRecord, len(keys))
+	for _, key := range keys {
+		record, err := s.lookupRecord(key)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			records[key] = record
+		}
+	}
+	return records, nil
+}
+
+// deleteRecord 删除文件记录
+func (s *service) deleteRecord(key string) error {
+	if s.opts.repository == nil {
+		return nil
+	}
+	return s.opts.repository.Delete(key)
+}
+
+// hashCounter 读取时计算大小和 SHA-256
+type hashCounter struct {
+	reader io.Reader
+	hash   hash.Hash
+	size   int64
+}
+
+func newHashCounter(reader io.Reader) *hashCounter {
+	return &hashCounter{reader: reader, hash: sha256.New()}
+}
+
+func (h *hashCounter) Read(p []byte) (int, error) {
+	n, err := h.reader.Read(p)
+	h.hash.Write(p[:n])
+	h.size += int64(n)
+	return n, err
+}
+
+// Sum 返回已读取内容的 SHA-256
+func (h *hashCounter) Sum() string {
+	return hex.EncodeToString(h.hash.Sum(nil))
+}
@@ -0,0 +1,114 @@
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuominmin/biz/pagination"
+)
+
+func TestFileRecords(t *testing.T) {
+	repo := NewMemoryFileRepository()
+	svc, err := New("http://cdn.example.com", "goods", WithStorage(newMemStorage()), WithFileRepository(repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileURL, err := svc.UploadFile(strings.NewReader("hello"), "a1b2.obj", WithOwner("42"), WithOriginalName("chair.obj"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, lookup := range []string{fileURL, "goods/a1b2.obj", "/goods/a1b2.obj"} {
+		record, err := svc.GetRecord(lookup)
+		if err != nil {
+			t.Fatalf("GetRecord(%s): %v", lookup, err)
+		}
+		sum := sha256.Sum256([]byte("hello"))
+		if record.Key != "goods/a1b2.obj" || record.URL != fileURL || record.Owner != "42" ||
+			record.Size != 5 || record.Hash != hex.EncodeToString(sum[:]) ||
+			record.Type != FileTypeModel || record.OriginalName != "chair.obj" {
+			t.Errorf("unexpected record: %+v", record)
+		}
+	}
+
+	if err = svc.DeleteFile("goods/a1b2.obj"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.GetRecord(fileURL); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound after delete, got %v", err)
+	}
+}
+
+func TestFindRecords(t *testing.T) {
+	repo := NewMemoryFileRepository()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, r := range []struct{ key, owner string }{
+		{"goods/1.png", "1"},
+		{"goods/2.png", "1"},
+		{"goods/3.mp4", "1"},
+		{"goods/4.png", "2"},
+		{"goods/5.png", "1"},
+	} {
+		_ = repo.Save(&FileRecord{Key: r.key, Owner: r.owner, Type: FileType(r.key), CreatedAt: base.Add(time.Duration(i) * time.Hour)})
+	}
+
+	svc, err := New("", "goods", WithStorage(newMemStorage()), WithFileRepository(repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, total, err := svc.FindRecords(RecordFilter{Owner: "1", Type: FileTypeImage}, pagination.PageReq{Page: 1, PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(records) != 2 || records[0].Key != "goods/5.png" || records[1].Key != "goods/2.png" {
+		t.Errorf("unexpected page 1: total=%d records=%+v", total, records)
+	}
+
+	records, _, _ = svc.FindRecords(RecordFilter{Owner: "1", Type: FileTypeImage}, pagination.PageReq{Page: 2, PageSize: 2})
+	if len(records) != 1 || records[0].Key != "goods/1.png" {
+		t.Errorf("unexpected page 2: %+v", records)
+	}
+
+	records, total, _ = svc.FindRecords(RecordFilter{From: base.Add(time.Hour), To: base.Add(3 * time.Hour)}, pagination.PageReq{})
+	if total != 2 || records[0].Key != "goods/3.mp4" || records[1].Key != "goods/2.png" {
+		t.Errorf("unexpected date range result: total=%d records=%+v", total, records)
+	}
+}
+
+func TestModel3DRecords(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	zipPath := filepath.Join(t.TempDir(), "model.zip")
+	if err := os.WriteFile(zipPath, zipBytes(t, "chair/model.obj", "chair/wood.png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewMemoryFileRepository()
+	svc, err := New("", "models", WithStorage(newMemStorage()), WithFileRepository(repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = svc.ExtractAndSaveModel3D(zipPath, WithOwner("7")); err != nil {
+		t.Fatal(err)
+	}
+
+	records, total, _ := svc.FindRecords(RecordFilter{Owner: "7"}, pagination.PageReq{})
+	if total != 2 {
+		t.Fatalf("expected 2 records, got %d", total)
+	}
+	names := map[string]bool{}
+	for _, record := range records {
+		names[record.OriginalName] = true
+	}
+	if !names["model.obj"] || !names["wood.png"] {
+		t.Errorf("unexpected original names: %v", names)
+	}
+}
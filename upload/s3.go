@@ -0,0 +1,571 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterDriver(DriverS3, newS3Storage)
+}
+
+// 自定义元数据的请求头前缀
+const s3MetaPrefix = "x-amz-meta-"
+
+const (
+	// 默认区域
+	defaultS3Region = "us-east-1"
+	// 不对请求体签名
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	// 空请求体的 sha256
+	s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// 预签名地址的最长有效期
+	s3MaxPresignExpires = 7 * 24 * time.Hour
+)
+
+// NewS3Service 创建 S3 兼容存储（AWS S3、MinIO 等）的上传服务
+func NewS3Service(host, dir string, optFns ...Option) (Service, error) {
+	return newService(host, dir, append(optFns, WithDriver(DriverS3))...)
+}
+
+// s3Storage S3 兼容存储
+type s3Storage struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	acl       string
+	pathStyle bool
+	signer    *s3Signer
+	client    *http.Client
+}
+
+func newS3Storage(cfg DriverConfig) (Storage, error) {
+	// 验证S3配置的完整性
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("endpoint is required")
+	}
+	if cfg.AccessKeyId == "" {
+		return nil, fmt.Errorf("access_key_id is required")
+	}
+	if cfg.AccessKeySecret == "" {
+		return nil, fmt.Errorf("access_key_secret is required")
+	}
+	if cfg.BucketName == "" {
+		return nil, fmt.Errorf("bucket_name is required")
+	}
+
+	// endpoint 未带协议时默认使用 https
+	endpoint := cfg.Endpoint
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "https://" + endpoint
+	}
+	endpointURL, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint '%s': %v", cfg.Endpoint, err)
+	}
+
+	region := cfg.Params[ParamRegion]
+	if region == "" {
+		region = defaultS3Region
+	}
+
+	pathStyle := true
+	if v := cfg.Params[ParamPathStyle]; v != "" {
+		if pathStyle, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid %s '%s': %v", ParamPathStyle, v, err)
+		}
+	}
+
+	return &s3Storage{
+		endpoint:  endpointURL,
+		bucket:    cfg.BucketName,
+		region:    region,
+		acl:       cfg.Params[ParamACL],
+		pathStyle: pathStyle,
+		signer: &s3Signer{
+			accessKeyId:     cfg.AccessKeyId,
+			accessKeySecret: cfg.AccessKeySecret,
+			region:          region,
+			service:         "s3",
+		},
+		client: http.DefaultClient,
+	}, nil
+}
+
+// objectURL 生成对象（key 为空时为存储桶）的请求地址
+func (s *s3Storage) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	p := "/" + key
+	if s.pathStyle {
+		p = "/" + s.bucket + p
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = u.Path + p
+	u.RawPath = s3EscapePath(u.Path)
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+	return &u
+}
+
+// do 签名并发送请求，非 2xx 响应转换为错误
+func (s *s3Storage) do(op, method, key string, query url.Values, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(key, query).String(), body)
+	if err != nil {
+		return nil, storageError(op, key, ErrInvalidArgument, fmt.Errorf("failed to create S3 request: %v", err))
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	payloadHash := s3EmptyPayloadHash
+	if body != nil {
+		req.ContentLength = size
+		payloadHash = s3UnsignedPayload
+	}
+	s.signer.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// 网络错误，读取上传内容返回的错误保留原有的分类
+		var kind error = ErrBackendUnavailable
+		if kindOf(err) != nil {
+			kind = nil
+		}
+		return nil, storageError(op, key, kind, fmt.Errorf("failed to %s file on S3: %w", op, err))
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, s.wrapError(op, key, resp)
+	}
+	return resp, nil
+}
+
+// Put 上传文件到S3
+func (s *s3Storage) Put(key string, reader io.Reader, opts PutOptions) error {
+	// S3 不支持 chunked 上传，需要确定内容长度，调用方未提供时先缓存
+	body, size := reader, opts.Size
+	if size <= 0 {
+		var cleanup func()
+		var err error
+		if body, size, cleanup, err = sizedReader(reader); err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+
+	header := http.Header{}
+	if opts.ContentType != "" {
+		header.Set("Content-Type", opts.ContentType)
+	}
+	// 单个文件的权限优先于驱动的默认权限
+	if opts.ACL != ACLDefault {
+		header.Set("x-amz-acl", string(opts.ACL))
+	} else if s.acl != "" {
+		header.Set("x-amz-acl", s.acl)
+	}
+	if opts.CacheControl != "" {
+		header.Set("Cache-Control", opts.CacheControl)
+	}
+	if opts.ContentDisposition != "" {
+		header.Set("Content-Disposition", opts.ContentDisposition)
+	}
+	for k, v := range opts.Metadata {
+		header.Set(s3MetaPrefix+k, v)
+	}
+	if len(opts.Tags) > 0 {
+		tags := url.Values{}
+		for k, v := range opts.Tags {
+			tags.Set(k, v)
+		}
+		header.Set("x-amz-tagging", tags.Encode())
+	}
+
+	resp, err := s.do("upload", http.MethodPut, key, nil, body, size, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SignURL 生成S3原生的预签名下载地址，有效期最长 7 天
+func (s *s3Storage) SignURL(key string, expires time.Duration) (string, error) {
+	if expires < time.Second || expires > s3MaxPresignExpires {
+		return "", storageError("sign", key, ErrInvalidArgument, fmt.Errorf("presigned url expiry must be between 1s and %v", s3MaxPresignExpires))
+	}
+	return s.signer.presign(s.objectURL(key, nil), expires, time.Now()), nil
+}
+
+// Get 从S3下载文件
+func (s *s3Storage) Get(key string) (io.ReadCloser, error) {
+	resp, err := s.do("download", http.MethodGet, key, nil, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetRange 从S3按范围下载文件
+func (s *s3Storage) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	resp, err := s.do("download", http.MethodGet, key, nil, nil, 0, header)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete 从S3删除文件
+func (s *s3Storage) Delete(key string) error {
+	resp, err := s.do("delete", http.MethodDelete, key, nil, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Stat 获取S3文件信息
+func (s *s3Storage) Stat(key string) (*FileInfo, error) {
+	resp, err := s.do("stat", http.MethodHead, key, nil, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &FileInfo{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ModTime:     modTime,
+		ETag:        resp.Header.Get("ETag"),
+		ObjectAttrs: headerAttrs(resp.Header, s3MetaPrefix),
+	}, nil
+}
+
+// s3ListResult ListObjectsV2 响应
+type s3ListResult struct {
+	IsTruncated bool `xml:"IsTruncated"`
+	Contents    []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+	} `xml:"Contents"`
+}
+
+// List 按前缀分页列出S3文件，游标为上一页最后一个 key（start-after），与其他驱动一致
+func (s *s3Storage) List(prefix, cursor string, limit int) ([]FileInfo, string, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	if cursor != "" {
+		query.Set("start-after", cursor)
+	}
+	if limit > 0 {
+		query.Set("max-keys", strconv.Itoa(limit))
+	}
+
+	resp, err := s.do("list", http.MethodGet, "", query, nil, 0, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var result s3ListResult
+	if err = xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", fmt.Errorf("failed to decode S3 list response: %v", err)
+	}
+
+	files := make([]FileInfo, 0, len(result.Contents))
+	for _, object := range result.Contents {
+		files = append(files, FileInfo{
+			Key:     object.Key,
+			Size:    object.Size,
+			ModTime: object.LastModified,
+			ETag:    object.ETag,
+		})
+	}
+
+	var next string
+	if result.IsTruncated && len(files) > 0 {
+		next = files[len(files)-1].Key
+	}
+	return files, next, nil
+}
+
+// s3Error S3 错误响应
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// wrapError 解析S3错误，提供更详细的错误信息，并按错误码分类。
+// 配置错误（bucket 不存在、密钥错误等）调用方无法处理，视为存储不可用
+func (s *s3Storage) wrapError(op, key string, resp *http.Response) error {
+	var s3Err s3Error
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if len(data) == 0 || xml.Unmarshal(data, &s3Err) != nil || s3Err.Code == "" {
+		// HEAD 请求没有响应体，根据状态码推断
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			s3Err.Code = "NoSuchKey"
+		case http.StatusForbidden:
+			s3Err.Code = "AccessDenied"
+		default:
+			s3Err.Code = strconv.Itoa(resp.StatusCode)
+		}
+		s3Err.Message = resp.Status
+	}
+
+	switch s3Err.Code {
+	case "AccessDenied":
+		return storageError(op, key, ErrPermissionDenied, fmt.Errorf("access denied: insufficient permissions to %s file. Error: %s", op, s3Err.Message))
+	case "NoSuchBucket":
+		return storageError(op, key, ErrBackendUnavailable, fmt.Errorf("bucket '%s' does not exist. Error: %s", s.bucket, s3Err.Message))
+	case "NoSuchKey":
+		return storageError(op, key, ErrNotFound, fmt.Errorf("file does not exist. Error: %s", s3Err.Message))
+	case "InvalidAccessKeyId":
+		return storageError(op, key, ErrBackendUnavailable, fmt.Errorf("invalid AccessKeyId in configuration. Error: %s", s3Err.Message))
+	case "SignatureDoesNotMatch":
+		return storageError(op, key, ErrBackendUnavailable, fmt.Errorf("invalid AccessKeySecret in configuration. Error: %s", s3Err.Message))
+	case "RequestTimeTooSkewed":
+		return storageError(op, key, ErrBackendUnavailable, fmt.Errorf("system time is incorrect. Please sync your system time. Error: %s", s3Err.Message))
+	case "AuthorizationHeaderMalformed":
+		return storageError(op, key, ErrBackendUnavailable, fmt.Errorf("region mismatch: bucket is in different region than configured '%s'. Error: %s", s.region, s3Err.Message))
+	default:
+		return storageError(op, key, statusKind(resp.StatusCode), fmt.Errorf("S3 %s error [%s]: %s", op, s3Err.Code, s3Err.Message))
+	}
+}
+
+// readerSize 返回 reader 剩余内容的长度，无法确定时返回 -1
+func readerSize(reader io.Reader) int64 {
+	switch r := reader.(type) {
+	case *bytes.Reader:
+		return int64(r.Len())
+	case *bytes.Buffer:
+		return int64(r.Len())
+	case *strings.Reader:
+		return int64(r.Len())
+	case *os.File:
+		if info, err := r.Stat(); err == nil && info.Mode().IsRegular() {
+			if offset, err := r.Seek(0, io.SeekCurrent); err == nil {
+				return info.Size() - offset
+			}
+		}
+	case interface {
+		io.Seeker
+		Size() int64
+	}:
+		// 例如 *io.SectionReader 和 multipart.File
+		if offset, err := r.Seek(0, io.SeekCurrent); err == nil {
+			return r.Size() - offset
+		}
+	}
+	return -1
+}
+
+// sizedReader 返回可确定长度的 reader，无法确定长度时先缓存到临时文件
+func sizedReader(reader io.Reader) (io.Reader, int64, func(), error) {
+	noop := func() {}
+	if size := readerSize(reader); size >= 0 {
+		return reader, size, noop, nil
+	}
+
+	tempFile, err := os.CreateTemp("", TempPrefix+"s3_*")
+	if err != nil {
+		return nil, 0, noop, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	cleanup := func() {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+	}
+
+	size, err := io.Copy(tempFile, reader)
+	if err == nil {
+		_, err = tempFile.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, noop, fmt.Errorf("failed to buffer upload content: %w", err)
+	}
+	return tempFile, size, cleanup, nil
+}
+
+// s3Signer AWS Signature Version 4 签名
+type s3Signer struct {
+	accessKeyId     string
+	accessKeySecret string
+	region          string
+	service         string
+}
+
+// sign 为请求添加 SigV4 Authorization 头
+func (s *s3Signer) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	canonicalHeaders, signedHeaders := s.canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		s.canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := s.scope(date)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyId, scope, signedHeaders, s.signature(date, amzDate, scope, canonicalRequest)))
+}
+
+// presign 生成预签名的 GET 地址，签名放在查询参数中，只签名 host 头
+func (s *s3Signer) presign(u *url.URL, expires time.Duration, now time.Time) string {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := s.scope(date)
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.accessKeyId+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		s3EscapePath(u.Path),
+		s.canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		s3UnsignedPayload,
+	}, "\n")
+	query.Set("X-Amz-Signature", s.signature(date, amzDate, scope, canonicalRequest))
+
+	signed := *u
+	signed.RawQuery = s.canonicalQuery(query)
+	return signed.String()
+}
+
+// scope 签名的凭证范围
+func (s *s3Signer) scope(date string) string {
+	return strings.Join([]string{date, s.region, s.service, "aws4_request"}, "/")
+}
+
+// signature 计算规范请求的签名
+func (s *s3Signer) signature(date, amzDate, scope, canonicalRequest string) string {
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSha256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+s.accessKeySecret), date)
+	key = hmacSha256(key, s.region)
+	key = hmacSha256(key, s.service)
+	key = hmacSha256(key, "aws4_request")
+	return hex.EncodeToString(hmacSha256(key, stringToSign))
+}
+
+// canonicalHeaders 参与签名的头：host、content-type、content-md5、range 以及所有 x-amz-*
+func (s *s3Signer) canonicalHeaders(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		name := strings.ToLower(k)
+		if name == "content-type" || name == "content-md5" || name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(headers[name])
+		b.WriteString("\n")
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+// canonicalQuery 按 key 排序并使用 RFC 3986 编码查询参数
+func (s *s3Signer) canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3EscapePath 对路径做 RFC 3986 编码，保留 "/"
+func s3EscapePath(p string) string {
+	return s3Escape(p, false)
+}
+
+// s3Escape RFC 3986 编码，encodeSlash 为 false 时保留 "/"
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSha256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
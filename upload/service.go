@@ -1,9 +1,9 @@
 package upload
 
 import (
-	"archive/zip"
-	"bytes"
+	"context"
 	"fmt"
+	"github.com/nuominmin/biz/pagination"
 	"github.com/nuominmin/biz/parser"
 	"io"
 	"mime"
@@ -12,118 +12,385 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/go-kratos/kratos/v2/log"
 	"github.com/google/uuid"
 )
 
-type Service interface {
-	UploadFile(reader io.Reader, name string) (string, error)
-	SaveFile(filePath string, name string) (string, error)
-	ExtractAndSaveModel3D(zipPath string) (string, []parser.TextureMapping, error)
+// Uploader 上传、下载和删除文件
+type Uploader interface {
+	UploadFile(reader io.Reader, name string, opts ...UploadOption) (string, error)
+	SaveFile(filePath string, name string, opts ...UploadOption) (string, error)
 	DownloadFile(filename string) ([]byte, error)
-	DeleteFile(filename string) error
+	DeleteFile(filename string, opts ...UploadOption) error
+	DerivativeURLs(fileURL string) map[string]string
 
-	GetContentType(filename string) string
-	GenerateUniqueFilename(originalFilename string) string
+	GetContentType(filename string, header ...[]byte) string
+	CheckContent(reader io.Reader, filename string, size int64) (io.Reader, error)
+	GenerateUniqueFilename(originalFilename string, opts ...UploadOption) string
 	RemoveDomainFromURL(host, fullURL string) string
 	AddDomainToURL(host, relativePath string) string
 }
 
+// ModelExtractor 解压并保存模型压缩包
+type ModelExtractor interface {
+	ExtractAndSaveModel3D(archivePath string, opts ...UploadOption) (string, []parser.TextureMapping, error)
+	ExtractAndSaveModel3DContext(ctx context.Context, archivePath string, opts ...UploadOption) (string, []parser.TextureMapping, error)
+	ExtractModelBundle(ctx context.Context, archivePath string, opts ...UploadOption) (*ModelBundle, error)
+}
+
+// FileManager 读取、列出、复制和移动已上传的文件
+type FileManager interface {
+	OpenFile(filename string) (*FileReader, error)
+	Stat(filename string) (*FileInfo, error)
+	List(prefix, cursor string, limit int) ([]FileInfo, string, error)
+	Copy(src, dst string, opts ...UploadOption) (string, error)
+	Move(src, dst string, opts ...UploadOption) (string, error)
+}
+
+// RecordManager 查询文件记录，管理临时文件
+type RecordManager interface {
+	GetRecord(fileURL string) (*FileRecord, error)
+	FindRecords(filter RecordFilter, page pagination.PageReq) ([]FileRecord, int64, error)
+	CommitFile(fileURL string, opts ...UploadOption) error
+	Sweep() (int, error)
+	StartSweeper(ctx context.Context, interval time.Duration)
+}
+
+// QuotaManager 查询和检查存储配额
+type QuotaManager interface {
+	CheckQuota(owner string, size int64) error
+	GetUsage(owner string) (Usage, Quota, error)
+}
+
+// AccessController 文件访问权限和签名地址
+type AccessController interface {
+	SignURL(fileURL string, expires time.Duration) (string, error)
+	VerifySignedURL(filename string, query url.Values) error
+	FileACL(filename string) (ACL, error)
+}
+
+// Service 上传服务的全部功能。只用到部分功能的调用方应依赖对应的小接口，
+// 例如 Uploader、FileManager，便于替换实现和编写测试
+type Service interface {
+	Uploader
+	ModelExtractor
+	FileManager
+	RecordManager
+	QuotaManager
+	AccessController
+}
+
 type service struct {
-	host string
-	dir  string
-	opts options
+	host    string
+	dir     string
+	opts    options
+	storage Storage
 }
 
-func newService(host, dir string, optFns ...Option) *service {
-	return &service{
-		host: strings.TrimRight(host, "/"),
-		dir:  dir,
-		opts: newOptions(optFns...),
+func newService(host, dir string, optFns ...Option) (*service, error) {
+	opts := newOptions(optFns...)
+	if err := validateImageVariants(opts.imageVariants); err != nil {
+		return nil, err
+	}
+	// 删除文件时需要根据文件记录释放用量
+	if opts.quotaStore != nil && opts.repository == nil {
+		return nil, fmt.Errorf("存储配额需要同时配置文件记录仓库")
+	}
+
+	// 选择存储驱动
+	storage := opts.storage
+	if storage == nil {
+		var err error
+		if storage, err = OpenStorage(opts.driver, opts.driverConfig()); err != nil {
+			return nil, err
+		}
+	}
+
+	// 本地驱动默认存放在 uploads 目录下，与静态文件路由保持一致
+	var baseDir string
+	if opts.baseDir != nil {
+		baseDir = *opts.baseDir
+	} else if opts.storage == nil && opts.driver == DriverLocal {
+		baseDir = DefaultUploadDir
+	}
+
+	s := &service{
+		host:    strings.TrimRight(host, "/"),
+		opts:    opts,
+		storage: storage,
 	}
+	s.dir = s.joinPath(baseDir, dir)
+	return s, nil
 }
 
-func NewService(host, dir string, optFns ...Option) Service {
+// New 创建上传服务，默认使用本地存储，可通过 WithDriver 或 WithStorage 切换存储驱动
+func New(host, dir string, optFns ...Option) (Service, error) {
 	return newService(host, dir, optFns...)
 }
 
-// UploadFile 上传文件
-func (s *service) UploadFile(reader io.Reader, name string) (string, error) {
-	// 拼接文件路径
-	filename := filepath.Join(DefaultUploadDir, s.dir, name)
+// NewService 同 New，配置无效时 panic。
+//
+// Deprecated: 使用 New，以便处理配置和存储驱动的错误
+func NewService(host, dir string, optFns ...Option) Service {
+	svc, err := New(host, dir, optFns...)
+	if err != nil {
+		panic("upload: " + err.Error())
+	}
+	return svc
+}
 
-	// 创建目录
-	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
-		return "", fmt.Errorf("创建目录失败 (%s): %w", s.dir, err)
+// UploadFile 上传文件，配置了文件记录仓库时同时保存上传记录
+func (s *service) UploadFile(reader io.Reader, name string, optFns ...UploadOption) (string, error) {
+	key, _, err := s.upload(reader, name, newUploadOptions(name, optFns...))
+	if err != nil {
+		return "", err
 	}
+	return s.fileURL(key), nil
+}
 
-	// 创建目标文件
-	destFile, err := os.Create(filename)
+// upload 上传文件并返回 key，以及对象和记录是否为本次上传新建的。
+// 覆盖已有文件或内容寻址命中已有对象时不是新建的，回滚时不能删除
+func (s *service) upload(reader io.Reader, name string, opts uploadOptions) (string, bool, error) {
+	if opts.ttl > 0 && s.opts.repository == nil {
+		return "", false, fmt.Errorf("临时上传需要配置文件记录仓库")
+	}
+	if opts.acl == ACLDefault {
+		opts.acl = s.opts.defaultACL
+	}
+	// 本地等不支持原生签名的存储根据文件记录判断文件是否私有
+	if _, ok := s.storage.(URLSigner); opts.acl == ACLPrivate && !ok && s.opts.repository == nil {
+		return "", false, fmt.Errorf("私有文件需要配置文件记录仓库")
+	}
+
+	// 包装前记录内容长度，供需要预先知道长度的驱动使用
+	if opts.size <= 0 {
+		opts.size = readerSize(reader)
+	}
+
+	// 读取文件头用于识别 Content-Type
+	reader, header, err := sniff(reader)
 	if err != nil {
-		return "", fmt.Errorf("创建目标文件失败: %v", err)
+		return "", false, err
 	}
 
-	// 复制文件内容
-	_, err = io.Copy(destFile, reader)
+	// 边上传边计算大小和哈希
+	counter := newHashCounter(reader)
+
+	var key string
+	created := true
+	switch {
+	case s.isDerivable(name):
+		// 图片同时生成衍生图
+		key, created, err = s.uploadImage(counter, name, header, opts)
+	case s.opts.contentAddressed:
+		key, created, err = s.uploadContentAddressed(counter, name, header, opts)
+	default:
+		// 拼接文件路径
+		key = s.joinPath(s.dir, name)
+		err = s.storage.Put(key, counter, s.putOptions(key, header, opts))
+	}
+	if err != nil {
+		return "", false, err
+	}
 
-	// 关闭目标文件
-	destFile.Close()
+	added, err := s.commit(key, counter.size, counter.Sum(), header, opts)
 	if err != nil {
-		os.Remove(filename)
-		return "", fmt.Errorf("复制文件内容失败: %w", err)
+		return "", false, err
+	}
+	// 内容寻址且有文件记录时，新建的是上传者对对象的引用，回滚时只移除该引用
+	if s.opts.contentAddressed && s.opts.repository != nil {
+		created = true
 	}
+	return key, created && added, nil
+}
 
-	// 确保 filename 里用的是 /
-	filename = path.Clean(strings.ReplaceAll(filename, "\\", "/"))
+// commit 对象写入后计入配额用量并保存文件记录，返回是否新建了记录或引用。
+// 内容寻址命中已有对象时为上传者添加引用，覆盖其他文件时记录属于新的上传者。
+// 超出配额时删除新上传的文件，内容寻址的文件可能被其他地方引用，不删除
+func (s *service) commit(key string, size int64, hash string, header []byte, opts uploadOptions) (bool, error) {
+	prev, err := s.lookupRecord(key)
+	if err != nil {
+		return false, fmt.Errorf("获取文件记录失败: %w", err)
+	}
+	if s.opts.contentAddressed && prev != nil {
+		// 对象被多个上传者共享，修改访问权限会影响其他上传者
+		if opts.acl != prev.ACL {
+			return false, kindErrorf(ErrInvalidArgument, "内容寻址的文件已以 '%s' 权限保存，不能修改为 '%s': %s", prev.ACL, opts.acl, key)
+		}
+		return s.addRef(prev, size, opts)
+	}
 
-	return fmt.Sprintf("%s/%s", s.host, filename), nil
+	// 新写入的文件在计入用量或保存记录失败时删除，内容寻址的对象由调用方处理
+	discard := func() {
+		if prev == nil && !s.opts.contentAddressed {
+			s.storage.Delete(key)
+			s.deleteDerivatives(key)
+		}
+	}
+	undo, err := s.reserveQuota(opts.owner, size, prev)
+	if err != nil {
+		discard()
+		return false, err
+	}
+
+	if err = s.saveRecord(key, size, hash, header, opts, prev); err != nil {
+		undo()
+		discard()
+		return false, fmt.Errorf("保存文件记录失败: %w", err)
+	}
+	return prev == nil, nil
+}
+
+// addRef 内容寻址命中已有对象时添加上传者的引用并计入其用量，已引用时不重复添加，
+// 只把临时引用转为永久引用
+func (s *service) addRef(record *FileRecord, size int64, opts uploadOptions) (bool, error) {
+	if record.hasRef(opts.owner) {
+		if opts.ttl > 0 || record.expiresAt(opts.owner).IsZero() {
+			return false, nil
+		}
+		record.setExpiresAt(opts.owner, time.Time{})
+		if err := s.opts.repository.Save(record); err != nil {
+			return false, fmt.Errorf("保存文件记录失败: %w", err)
+		}
+		return false, nil
+	}
+	undo, err := s.reserveQuota(opts.owner, size, nil)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	ref := FileRef{Owner: opts.owner, OriginalName: opts.originalName, CreatedAt: now}
+	if opts.ttl > 0 {
+		ref.ExpiresAt = now.Add(opts.ttl)
+	}
+	record.Refs = append(record.Refs, ref)
+	if err = s.opts.repository.Save(record); err != nil {
+		undo()
+		return false, fmt.Errorf("保存文件记录失败: %w", err)
+	}
+	return true, nil
+}
+
+// putOptions 写入对象的选项，header 用于识别 Content-Type
+func (s *service) putOptions(key string, header []byte, opts uploadOptions) PutOptions {
+	putOpts := PutOptions{
+		ContentType:  s.contentType(key, header, opts),
+		ACL:          opts.acl,
+		CacheControl: opts.cacheControl,
+		Metadata:     opts.metadata,
+		Tags:         opts.tags,
+		Size:         opts.size,
+	}
+	if opts.disposition != "" {
+		putOpts.ContentDisposition = mime.FormatMediaType(opts.disposition, map[string]string{"filename": opts.originalName})
+	}
+	return putOpts
+}
+
+// contentType 文件的 Content-Type，优先使用 WithContentType 设置的值
+func (s *service) contentType(key string, header []byte, opts uploadOptions) string {
+	if opts.contentType != "" {
+		return opts.contentType
+	}
+	return s.GetContentType(key, header)
 }
 
 // SaveFile 保存文件
-func (s *service) SaveFile(filename string, name string) (string, error) {
+func (s *service) SaveFile(filename string, name string, opts ...UploadOption) (string, error) {
 	// 检查文件是否存在
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		return "", fmt.Errorf("文件不存在: %s", filename)
+		return "", kindErrorf(ErrNotFound, "文件不存在: %s", filename)
 	}
 
-	// 读取文件内容
-	data, err := os.ReadFile(filename)
+	// 打开文件
+	file, err := os.Open(filename)
 	if err != nil {
 		return "", fmt.Errorf("打开文件失败: %w", err)
 	}
+	defer file.Close()
 
-	return s.UploadFile(bytes.NewReader(data), name)
+	return s.UploadFile(file, name, opts...)
 }
 
 // DownloadFile 下载文件
 func (s *service) DownloadFile(filename string) ([]byte, error) {
-	// 检查文件是否存在
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		return nil, fmt.Errorf("文件不存在: %s", filename)
+	reader, err := s.storage.Get(s.cleanKey(filename))
+	if err != nil {
+		return nil, err
 	}
+	defer reader.Close()
 
 	// 读取文件内容
-	data, err := os.ReadFile(filename)
+	data, err := io.ReadAll(reader)
 	if err != nil {
-		return nil, fmt.Errorf("打开文件失败: %w", err)
+		return nil, fmt.Errorf("读取文件失败: %w", err)
 	}
 
 	return data, nil
 }
 
-// DeleteFile 删除文件
-func (s *service) DeleteFile(filename string) error {
-	// 检查文件是否存在
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		return fmt.Errorf("文件不存在: %s", filename)
+// DeleteFile 删除文件及其衍生图和上传记录，并释放上传者的配额用量。
+// 通过 WithOwner 指定上传者时只能删除该上传者的文件；内容寻址的对象被多个上传者引用时，
+// 必须指定上传者，只移除其引用，最后一个引用移除时才删除对象
+func (s *service) DeleteFile(filename string, opts ...UploadOption) error {
+	owner := newUploadOptions("", opts...).owner
+	return s.deleteFile(s.cleanKey(filename), owner, owner != "")
+}
+
+// deleteFile 删除文件，byOwner 为 true 时只删除 owner 的文件或引用
+func (s *service) deleteFile(key, owner string, byOwner bool) error {
+	record, err := s.lookupRecord(key)
+	if err != nil {
+		return fmt.Errorf("获取文件记录失败: %w", err)
+	}
+	if s.opts.contentAddressed && s.opts.repository == nil {
+		return kindErrorf(ErrInvalidArgument, "内容寻址的文件可能被多处引用，删除需要配置文件记录仓库")
+	}
+	if byOwner && record != nil && !record.hasRef(owner) {
+		return kindErrorf(ErrPermissionDenied, "文件不属于上传者 %s: %s", owner, key)
+	}
+	if record != nil && record.RefCount() > 1 {
+		if !byOwner {
+			return kindErrorf(ErrInvalidArgument, "文件被 %d 个上传者引用，删除时需要指定上传者: %s", record.RefCount(), key)
+		}
+		record.removeRef(owner)
+		if err = s.opts.repository.Save(record); err != nil {
+			return fmt.Errorf("保存文件记录失败: %w", err)
+		}
+		return s.releaseQuota(&FileRecord{Owner: owner, Size: record.Size})
 	}
 
-	// 删除文件
-	if err := os.Remove(filename); err != nil {
-		return fmt.Errorf("删除文件失败: %w", err)
+	if err = s.storage.Delete(key); err != nil {
+		return err
 	}
+	s.deleteDerivatives(key)
 
-	return nil
+	if record == nil {
+		return nil
+	}
+	if err = s.deleteRecord(key); err != nil {
+		return err
+	}
+	return s.releaseQuota(record)
+}
+
+// fileURL 生成对象的访问地址
+func (s *service) fileURL(key string) string {
+	return fmt.Sprintf("%s/%s", s.host, key)
+}
+
+// keyFromURL 将访问地址转换为存储 key，传入 key 时原样返回
+func (s *service) keyFromURL(fileURL string) string {
+	return s.cleanKey(strings.TrimPrefix(fileURL, s.host+"/"))
+}
+
+// cleanKey 将文件路径转换为存储 key，统一使用 "/" 并去掉开头的 "/"
+func (s *service) cleanKey(filename string) string {
+	key := path.Clean(strings.ReplaceAll(filename, "\\", "/"))
+	return strings.TrimPrefix(key, "/")
 }
 
 // joinPath 拼接目录和文件名，返回统一格式的相对路径。
@@ -139,12 +406,22 @@ func (s *service) joinPath(dir, name string) string {
 	return strings.TrimPrefix(key, "/")
 }
 
-// GenerateUniqueFilename 生成唯一文件名
-// originalFilename: 原文件名，用于提取扩展名
-func (s *service) GenerateUniqueFilename(originalFilename string) string {
-	ext := filepath.Ext(originalFilename)
-	name := strings.ReplaceAll(uuid.New().String(), "-", "")
-	return fmt.Sprintf("%s%s", name, ext)
+// dirPrefix 上传目录下对象 key 的前缀，没有上传目录时为空
+func (s *service) dirPrefix() string {
+	if s.dir == "" {
+		return ""
+	}
+	return s.dir + "/"
+}
+
+// GenerateUniqueFilename 按 WithKeyStrategy 设置的策略生成唯一文件名，可能包含子目录。
+// originalFilename: 原文件名，opts 中的 WithOwner 用于按上传者分目录
+func (s *service) GenerateUniqueFilename(originalFilename string, opts ...UploadOption) string {
+	return orFlat(s.opts.keyStrategy)(KeyInfo{
+		Filename: originalFilename,
+		Owner:    newUploadOptions(originalFilename, opts...).owner,
+		Time:     time.Now(),
+	})
 }
 
 // RemoveDomainFromURL 从URL中移除域名，只保留路径部分
@@ -205,9 +482,14 @@ func (s *service) AddDomainToURL(host, relativePath string) string {
 	return baseURL + relativePath
 }
 
-// GetContentType 根据文件扩展名获取MIME类型
-func (s *service) GetContentType(filename string) string {
+// GetContentType 获取MIME类型，传入文件头时优先使用识别出的类型，否则根据文件扩展名
+func (s *service) GetContentType(filename string, header ...[]byte) string {
 	ext := strings.ToLower(filepath.Ext(filename))
+	if len(header) > 0 {
+		if detected := DetectType(header[0]); detected != "" {
+			ext = detected
+		}
+	}
 
 	// 首先尝试标准MIME类型
 	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
@@ -241,13 +523,30 @@ func (s *service) GetContentType(filename string) string {
 		return "video/x-msvideo"
 	case ".mov":
 		return "video/quicktime"
+	// 导入文件
+	case ".xlsx":
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
 	default:
 		return "application/octet-stream"
 	}
 }
 
-// ExtractAndSaveModel3D 解压并保存模型文件
-func (s *service) ExtractAndSaveModel3D(zipPath string) (string, []parser.TextureMapping, error) {
+// ExtractAndSaveModel3D 解压并保存模型文件，支持 zip、tar、tar.gz 和 tar.zst
+func (s *service) ExtractAndSaveModel3D(archivePath string, opts ...UploadOption) (string, []parser.TextureMapping, error) {
+	return s.ExtractAndSaveModel3DContext(context.Background(), archivePath, opts...)
+}
+
+// ExtractAndSaveModel3DContext 同 ExtractAndSaveModel3D，ctx 取消时停止上传并删除已上传的文件
+func (s *service) ExtractAndSaveModel3DContext(ctx context.Context, archivePath string, opts ...UploadOption) (string, []parser.TextureMapping, error) {
+	bundle, err := s.ExtractModelBundle(ctx, archivePath, opts...)
+	if err != nil {
+		return "", nil, err
+	}
+	return bundle.ModelURL, bundle.Textures, nil
+}
+
+// ExtractModelBundle 解压并保存模型文件，同时根据 FBX 中的贴图引用检查缺少和多余的文件
+func (s *service) ExtractModelBundle(ctx context.Context, archivePath string, opts ...UploadOption) (*ModelBundle, error) {
 	// 创建FBX解析器实例
 	fbxParser := parser.NewFBXParser()
 
@@ -255,101 +554,147 @@ func (s *service) ExtractAndSaveModel3D(zipPath string) (string, []parser.Textur
 	modelExtensions := fbxParser.GetSupportedModelExtensions()
 	textureExtensions := fbxParser.GetSupportedTextureExtensions()
 
-	// 打开ZIP文件
-	reader, err := zip.OpenReader(zipPath)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to open zip file: %v", err)
+	// 保留目录结构时所有文件保存在同一个唯一目录下
+	var layoutDir string
+	if newUploadOptions("", opts...).preserveLayout {
+		if s.opts.contentAddressed {
+			return nil, kindErrorf(ErrInvalidArgument, "内容寻址时不支持保留目录结构")
+		}
+		layoutDir = strings.ReplaceAll(uuid.New().String(), "-", "")
 	}
-	defer reader.Close()
 
-	// 临时文件目录
-	tempDir := "./temp"
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		return "", nil, fmt.Errorf("failed to create upload directory: %v", err)
+	// 临时文件目录，每次解压单独创建，避免并发上传互相覆盖
+	tempDir, err := os.MkdirTemp("", TempPrefix+"model_*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %v", err)
 	}
+	defer os.RemoveAll(tempDir)
 
 	var modelURL string
 	var modelTextures []parser.TextureMapping
 	var requiredTextures []string
-	var fbxFilePath string
 
-	// 第一遍：建立文件映射并找到FBX文件
-	for _, file := range reader.File {
-		if file.FileInfo().IsDir() {
-			continue
-		}
-
-		// 检查是否为FBX文件
-		ext := strings.ToLower(filepath.Ext(file.Name))
-		if ext == ".fbx" && fbxFilePath == "" {
-			// 临时保存FBX文件用于解析（总是保存到本地临时文件，因为FBX解析器需要本地文件）
-			fbxTempPath := filepath.Join(tempDir, "temp_model.fbx")
-			err := fbxParser.ExtractSingleFileFromZip(file, fbxTempPath)
-			if err == nil {
-				fbxFilePath = fbxTempPath
-			}
-		}
+	// 第一遍：检查文件数量、大小、压缩比和路径，防止压缩包炸弹和路径穿越，同时找到FBX文件
+	fbxFilePath := filepath.Join(tempDir, "temp_model.fbx")
+	fbxEntry, err := s.checkModelArchive(archivePath, fbxFilePath)
+	if err != nil {
+		return nil, err
 	}
 
 	// 如果找到FBX文件，解析其贴图依赖
-	if fbxFilePath != "" {
+	referencesParsed := false
+	if fbxEntry != "" {
 		requiredTextures, err = fbxParser.ParseTextureReferences(fbxFilePath)
 		if err != nil {
 			// 如果解析失败，回退到提取所有贴图文件
-			fmt.Printf("Failed to parse FBX textures, falling back to extract all: %v\n", err)
+			log.Warnf("upload: parse texture references of %s, extracting all textures: %v", fbxEntry, err)
 		} else {
-			fmt.Printf("Found %d texture references in FBX file\n", len(requiredTextures))
+			referencesParsed = true
 		}
 		// 清理临时FBX文件
 		os.Remove(fbxFilePath)
 	}
 
-	// 第二遍：解压文件
-	for _, file := range reader.File {
-		// 跳过目录
-		if file.FileInfo().IsDir() {
-			continue
-		}
-
-		// 获取文件扩展名
-		ext := strings.ToLower(filepath.Ext(file.Name))
-		fileName := filepath.Base(file.Name)
-
-		// 打开压缩包中的文件
-		srcFile, err := file.Open()
-		if err != nil {
-			fmt.Printf("Failed to open file in zip: %s, error: %v\n", file.Name, err)
-			continue
-		}
-
-		// 生成唯一文件名
-		uniqueFilename := s.GenerateUniqueFilename(fileName)
-
-		// 上传
-		var fileURL string
-		if fileURL, err = s.UploadFile(srcFile, uniqueFilename); err != nil {
-			fmt.Printf("Failed to upload file: %s, error: %v\n", fileName, err)
-			continue
+	// 第二遍：解压并并发上传文件。tar 只能顺序读取，因此重新打开压缩包
+	archive, err := openArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	// 任意文件上传失败时删除本次上传的所有文件
+	tx := &bundleTx{svc: s}
+	results, err := s.uploadBundle(ctx, tx, archive, tempDir, func(relPath string) (string, uploadOptions) {
+		// 保留目录结构时使用原路径，否则生成唯一文件名
+		fileName := path.Base(relPath)
+		name := s.GenerateUniqueFilename(fileName, opts...)
+		if layoutDir != "" {
+			name = path.Join(layoutDir, relPath)
 		}
-		srcFile.Close()
+		// 上传记录的原文件名为压缩包中的文件名
+		return name, newUploadOptions(name, append(append([]UploadOption{}, opts...), WithOriginalName(fileName))...)
+	})
+	if err != nil {
+		return nil, tx.abort(err)
+	}
+	if len(tx.failed) > 0 {
+		return nil, tx.abort(nil)
+	}
 
-		// 分类文件
+	// 按压缩包中的顺序分类文件
+	for _, result := range results {
+		ext := strings.ToLower(path.Ext(result.path))
 		if modelExtensions[ext] && modelURL == "" {
 			// 只保留第一个找到的模型文件
-			modelURL = fileURL
+			modelURL = result.url
 		} else if textureExtensions[ext] {
-			// 创建贴图映射对象
+			// 贴图映射的 Source，保留目录结构时为完整相对路径
+			source := path.Base(result.path)
+			if layoutDir != "" {
+				source = result.path
+			}
 			modelTextures = append(modelTextures, parser.TextureMapping{
-				Source: fileName,
-				Target: fileURL,
+				Source: source,
+				Target: result.url,
 			})
 		}
 	}
 
 	// 验证是否找到了模型文件
 	if modelURL == "" {
-		return "", nil, fmt.Errorf("no supported 3D model file found in zip")
+		return nil, tx.abort(ErrNoModelFound)
 	}
 
-	return modelURL, modelTextures, nil
+	bundle := &ModelBundle{ModelURL: modelURL, Textures: modelTextures, ReferencesParsed: referencesParsed}
+	if referencesParsed {
+		bundle.MissingTextures, bundle.UnusedFiles = textureReport(requiredTextures, results, fbxEntry)
+	}
+	return bundle, nil
+}
+
+// checkModelArchive 检查压缩包中的所有文件，并将第一个 FBX 文件保存到 fbxPath 用于解析，
+// 返回该文件在压缩包中的相对路径，没有 FBX 文件时返回空路径
+func (s *service) checkModelArchive(archivePath, fbxPath string) (string, error) {
+	archive, err := openArchive(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+
+	checker := archiveChecker{limits: s.opts.extractLimits}
+	var fbxEntry string
+	for {
+		entry, reader, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if err = checker.check(*entry); err != nil {
+			return "", err
+		}
+		if entry.mode.IsDir() {
+			continue
+		}
+
+		// 临时保存FBX文件用于解析（总是保存到本地临时文件，因为FBX解析器需要本地文件）
+		if fbxEntry == "" && strings.ToLower(filepath.Ext(entry.name)) == ".fbx" {
+			if err = extractEntry(reader, fbxPath); err == nil {
+				fbxEntry = entryPath(entry.name)
+			}
+		}
+	}
+
+	// zip 已按单个文件检查压缩比
+	if archive.Format() != archiveZip && archive.Format() != archiveTar {
+		info, err := os.Stat(archivePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to stat archive: %v", err)
+		}
+		if err = checker.checkRatio(info.Size()); err != nil {
+			return "", err
+		}
+	}
+	return fbxEntry, nil
 }
@@ -0,0 +1,215 @@
+package upload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// SniffLen 识别文件类型时读取的文件头长度
+const SniffLen = 8192
+
+// ErrContentMismatch 文件内容与扩展名不一致
+var ErrContentMismatch = newKindError(ErrInvalidArgument, "file content does not match extension")
+
+var (
+	utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+	// ASF（wmv）头部 GUID
+	asfHeader = []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11}
+	// EBML（webm、mkv）
+	ebmlHeader = []byte{0x1A, 0x45, 0xDF, 0xA3}
+	// zstd 帧头
+	zstdMagic = []byte{0x28, 0xB5, 0x2F, 0xFD}
+)
+
+// DetectType 根据文件头识别文件类型，返回规范的扩展名（如 ".jpg"），无法可靠识别时返回空字符串。
+// 只识别有明确签名的格式，obj、3ds、二进制 stl 等没有签名的格式需要结合扩展名使用 MatchType
+func DetectType(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return ".jpg"
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return ".png"
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return ".gif"
+	case len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")) && string(header[8:12]) == "WEBP":
+		return ".webp"
+	case len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")) && string(header[8:12]) == "AVI ":
+		return ".avi"
+	// BMP 文件头的保留字段为 0
+	case len(header) >= 14 && bytes.HasPrefix(header, []byte("BM")) && binary.LittleEndian.Uint32(header[6:10]) == 0:
+		return ".bmp"
+	case bytes.HasPrefix(header, []byte("glTF")):
+		return ".glb"
+	case bytes.HasPrefix(header, []byte("Kaydara FBX Binary")):
+		return ".fbx"
+	case bytes.HasPrefix(header, []byte("ply\n")), bytes.HasPrefix(header, []byte("ply\r\n")):
+		return ".ply"
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		return detectFtypBrand(string(header[8:12]))
+	case len(header) >= 8 && isQuickTimeAtom(string(header[4:8])):
+		return ".mov"
+	case bytes.HasPrefix(header, asfHeader):
+		return ".wmv"
+	case bytes.HasPrefix(header, []byte("FLV\x01")):
+		return ".flv"
+	case bytes.HasPrefix(header, ebmlHeader):
+		if bytes.Contains(header, []byte("webm")) {
+			return ".webm"
+		}
+		return ".mkv"
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		// xlsx 是包含 [Content_Types].xml 和 xl/ 目录的 zip
+		if bytes.Contains(header, []byte("[Content_Types].xml")) && bytes.Contains(header, []byte("xl/")) {
+			return ".xlsx"
+		}
+		return ".zip"
+	case bytes.HasPrefix(header, []byte{0x1F, 0x8B}):
+		return ".gz"
+	case bytes.HasPrefix(header, zstdMagic):
+		return ".zst"
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return ".tar"
+	}
+
+	if !isText(header) {
+		return ""
+	}
+	text := bytes.TrimSpace(bytes.TrimPrefix(header, utf8BOM))
+	switch {
+	case bytes.HasPrefix(text, []byte("<")) && bytes.Contains(text, []byte("<svg")):
+		return ".svg"
+	case bytes.HasPrefix(text, []byte("<")) && bytes.Contains(text, []byte("<COLLADA")):
+		return ".dae"
+	case bytes.HasPrefix(text, []byte("; FBX")):
+		return ".fbx"
+	case bytes.HasPrefix(text, []byte("solid")):
+		return ".stl"
+	case bytes.HasPrefix(text, []byte("{")) && bytes.Contains(text, []byte(`"asset"`)):
+		return ".gltf"
+	}
+	return ""
+}
+
+// MatchType 检查文件头是否与扩展名一致，size 为文件总大小，未知时传 -1。
+// 不在 ImgTypes、ModelTypes、VideoTypes、ZipTypes、ImportTypes 中的扩展名不做检查
+func MatchType(header []byte, size int64, ext string) bool {
+	ext = strings.ToLower(ext)
+	detected := DetectType(header)
+
+	switch ext {
+	case ".jpg", ".jpeg":
+		return detected == ".jpg"
+	case ".mp4", ".m4v", ".mov":
+		return detected == ".mp4" || detected == ".m4v" || detected == ".mov"
+	case ".webm", ".mkv":
+		return detected == ".webm" || detected == ".mkv"
+	case ".zip":
+		return detected == ".zip" || detected == ".xlsx"
+	case ".gz", ".tgz":
+		return detected == ".gz"
+	case ".zst", ".tzst":
+		return detected == ".zst"
+	case ".xlsx":
+		// [Content_Types].xml 可能不在文件头中，只要 zip 中出现 xl/ 目录即可
+		return (detected == ".zip" || detected == ".xlsx") && bytes.Contains(header, []byte("xl/"))
+	case ".obj":
+		return len(header) > 0 && isText(header)
+	case ".gltf":
+		return isText(header) && bytes.HasPrefix(bytes.TrimSpace(bytes.TrimPrefix(header, utf8BOM)), []byte("{"))
+	case ".3ds":
+		// 主块 ID 为 0x4D4D，块长度等于文件大小
+		if len(header) < 6 || binary.LittleEndian.Uint16(header) != 0x4D4D {
+			return false
+		}
+		return size < 0 || int64(binary.LittleEndian.Uint32(header[2:6])) == size
+	case ".stl":
+		if detected == ".stl" {
+			return true
+		}
+		// 二进制 stl：80 字节头 + 4 字节三角形数量 + 每个三角形 50 字节
+		if len(header) < 84 {
+			return false
+		}
+		return size < 0 || 84+50*int64(binary.LittleEndian.Uint32(header[80:84])) == size
+	}
+
+	if !isKnownType(ext) {
+		return true
+	}
+	return detected == ext
+}
+
+// CheckContent 读取文件头检查内容是否与扩展名一致，返回的 reader 包含完整内容
+func (s *service) CheckContent(reader io.Reader, filename string, size int64) (io.Reader, error) {
+	br, header, err := sniff(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if !MatchType(header, size, ext) {
+		if detected := DetectType(header); detected != "" {
+			return nil, fmt.Errorf("%w: %s is actually %s", ErrContentMismatch, ext, detected)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrContentMismatch, ext)
+	}
+	return br, nil
+}
+
+// sniff 读取文件头，返回的 reader 包含完整内容
+func sniff(reader io.Reader) (io.Reader, []byte, error) {
+	br := bufio.NewReaderSize(reader, SniffLen)
+	header, err := br.Peek(SniffLen)
+	if err != nil && err != io.EOF {
+		return nil, nil, fmt.Errorf("读取文件头失败: %w", err)
+	}
+	return br, header, nil
+}
+
+// detectFtypBrand 根据 ISO BMFF 的 major brand 区分 mov、m4v 和 mp4
+func detectFtypBrand(brand string) string {
+	switch brand {
+	case "qt  ":
+		return ".mov"
+	case "M4V ", "M4VH", "M4VP":
+		return ".m4v"
+	default:
+		return ".mp4"
+	}
+}
+
+// isQuickTimeAtom 旧版 QuickTime 文件没有 ftyp，以其他 atom 开头
+func isQuickTimeAtom(atom string) bool {
+	switch atom {
+	case "moov", "mdat", "wide", "free", "skip", "pnot":
+		return true
+	}
+	return false
+}
+
+// isText 文件头中没有 NUL 和除常见空白外的控制字符
+func isText(header []byte) bool {
+	for _, b := range header {
+		if b == 0 || (b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f') {
+			return false
+		}
+	}
+	return true
+}
+
+// isKnownType 是否为已知的上传格式
+func isKnownType(ext string) bool {
+	for _, types := range [][]string{ImgTypes, ModelTypes, VideoTypes, ZipTypes, ImportTypes} {
+		for _, t := range types {
+			if t == ext {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package upload
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func zipBytes(t *testing.T, names ...string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range names {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = f.Write([]byte("content"))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMatchType(t *testing.T) {
+	binarySTL := make([]byte, 84+50)
+	binary.LittleEndian.PutUint32(binarySTL[80:], 1)
+
+	threeDS := make([]byte, 16)
+	binary.LittleEndian.PutUint16(threeDS, 0x4D4D)
+	binary.LittleEndian.PutUint32(threeDS[2:], 16)
+
+	bmp := append([]byte("BM"), make([]byte, 20)...)
+
+	cases := []struct {
+		ext    string
+		header []byte
+	}{
+		{".jpg", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 0x10, 'J', 'F', 'I', 'F'}},
+		{".jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE1}},
+		{".png", []byte("\x89PNG\r\n\x1a\n\x00\x00")},
+		{".gif", []byte("GIF89a\x01\x00")},
+		{".webp", []byte("RIFF\x24\x00\x00\x00WEBPVP8 ")},
+		{".bmp", bmp},
+		{".svg", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`)},
+		{".glb", []byte("glTF\x02\x00\x00\x00")},
+		{".gltf", []byte(`{"asset": {"version": "2.0"}}`)},
+		{".obj", []byte("# Blender\nv 0 0 0\nf 1 2 3\n")},
+		{".fbx", []byte("Kaydara FBX Binary  \x00\x1a\x00")},
+		{".fbx", []byte("; FBX 7.4.0 project file\n")},
+		{".dae", []byte(`<?xml version="1.0"?><COLLADA version="1.4.1">`)},
+		{".3ds", threeDS},
+		{".ply", []byte("ply\nformat ascii 1.0\n")},
+		{".stl", []byte("solid cube\nfacet normal 0 0 1\n")},
+		{".stl", binarySTL},
+		{".mp4", []byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00")},
+		{".m4v", []byte("\x00\x00\x00\x20ftypM4V \x00\x00\x02\x00")},
+		{".mov", []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00")},
+		{".avi", []byte("RIFF\x00\x00\x00\x00AVI LIST")},
+		{".wmv", []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11, 0xA6, 0xD9}},
+		{".flv", []byte("FLV\x01\x05\x00\x00\x00\x09")},
+		{".webm", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm")},
+		{".mkv", []byte("\x1a\x45\xdf\xa3\xa3\x42\x86\x81\x01\x42\x82\x88matroska")},
+		{".zip", zipBytes(t, "model.fbx")},
+		{".xlsx", zipBytes(t, "[Content_Types].xml", "xl/workbook.xml")},
+		{".txt", []byte("anything")},
+	}
+	for _, c := range cases {
+		if !MatchType(c.header, int64(len(c.header)), c.ext) {
+			t.Errorf("%s: expected header to match", c.ext)
+		}
+	}
+}
+
+func TestMatchTypeRejectsRenamedFiles(t *testing.T) {
+	exe := []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00")
+	for _, ext := range []string{".jpg", ".png", ".glb", ".fbx", ".mp4", ".zip", ".xlsx", ".3ds", ".stl", ".obj"} {
+		if MatchType(exe, int64(len(exe)), ext) {
+			t.Errorf("%s: renamed executable should not match", ext)
+		}
+	}
+	if MatchType(zipBytes(t, "a.txt"), -1, ".xlsx") {
+		t.Error("plain zip should not match .xlsx")
+	}
+}
+
+func TestCheckContent(t *testing.T) {
+	mem := newMemStorage()
+	svc, err := New("http://cdn.example.com", "goods", WithStorage(mem))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("x", SniffLen)
+	reader, err := svc.CheckContent(strings.NewReader(png), "a.png", int64(len(png)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader)
+	if string(data) != png {
+		t.Error("reader should return full content after sniffing")
+	}
+
+	_, err = svc.CheckContent(strings.NewReader(png), "a.jpg", int64(len(png)))
+	if !errors.Is(err, ErrContentMismatch) {
+		t.Errorf("expected ErrContentMismatch, got %v", err)
+	}
+
+	if ct := svc.GetContentType("a.jpg", []byte(png)); ct != "image/png" {
+		t.Errorf("content type = %s, want image/png", ct)
+	}
+}
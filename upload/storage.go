@@ -0,0 +1,184 @@
+package upload
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 本地存储驱动
+	DriverLocal = "local"
+	// 阿里云 OSS 存储驱动
+	DriverOss = "oss"
+	// S3 兼容存储驱动（AWS S3、MinIO 等）
+	DriverS3 = "s3"
+)
+
+// 驱动自定义参数
+const (
+	// 本地驱动根目录
+	ParamRoot = "root"
+	// S3 区域
+	ParamRegion = "region"
+	// S3 对象 ACL，例如 public-read、private
+	ParamACL = "acl"
+	// S3 是否使用路径风格（endpoint/bucket/key），默认为 true
+	ParamPathStyle = "path_style"
+	// OSS 分片上传的阈值（字节），超过时使用分片上传，默认 64MB
+	ParamMultipartThreshold = "multipart_threshold"
+	// OSS 分片大小（字节），默认 8MB，最小 100KB
+	ParamPartSize = "part_size"
+	// OSS 并发上传的分片数，默认 4
+	ParamPartConcurrency = "part_concurrency"
+	// OSS 分片上传断点文件的目录，默认为系统临时目录下的 biz_oss_multipart
+	ParamCheckpointDir = "checkpoint_dir"
+)
+
+// Storage 存储驱动接口，key 统一使用 "/" 分隔的相对路径
+type Storage interface {
+	// Put 写入对象，已存在时覆盖
+	Put(key string, reader io.Reader, opts PutOptions) error
+	// Get 读取对象
+	Get(key string) (io.ReadCloser, error)
+	// Delete 删除对象
+	Delete(key string) error
+	// Stat 获取对象信息
+	Stat(key string) (*FileInfo, error)
+	// List 按 key 的顺序分页列出前缀下的对象，cursor 为上一页最后一个对象的 key，
+	// 返回下一页游标，为空表示没有更多
+	List(prefix, cursor string, limit int) ([]FileInfo, string, error)
+}
+
+// PutOptions 写入选项
+type PutOptions struct {
+	ContentType string
+	// 对象的访问权限，为 ACLDefault 时使用驱动的默认权限
+	ACL ACL
+	// 为空时使用驱动的默认值
+	CacheControl       string
+	ContentDisposition string
+	// 自定义元数据，读取时通过 Stat 返回
+	Metadata map[string]string
+	// 对象标签，用于生命周期规则、计费分类等，只写入不返回
+	Tags map[string]string
+	// 内容长度，大于 0 时驱动可以直接按该长度上传，无需先缓存内容来确定长度
+	Size int64
+}
+
+// ObjectAttrs 对象的 HTTP 属性和自定义元数据，为空表示写入时未设置
+type ObjectAttrs struct {
+	ContentType        string            `json:"content_type,omitempty"`
+	CacheControl       string            `json:"cache_control,omitempty"`
+	ContentDisposition string            `json:"content_disposition,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+}
+
+// FileInfo 对象信息，ObjectAttrs 只由 Stat 返回
+type FileInfo struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// 驱动返回的 ETag，OSS 和 S3 简单上传的对象为内容的 MD5。
+	// 本地存储为写入时计算的 MD5，只由 Stat 返回，旧版本写入的文件为空
+	ETag string `json:"etag,omitempty"`
+	ObjectAttrs
+}
+
+// contentMD5 返回 ETag 中内容的 MD5，分片上传等 ETag 不是 MD5 时返回空
+func (f *FileInfo) contentMD5() string {
+	etag := strings.ToLower(strings.Trim(f.ETag, `"`))
+	if len(etag) != md5.Size*2 {
+		return ""
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return ""
+	}
+	return etag
+}
+
+// sameContent 通过 ETag 中的 MD5 判断两端的对象内容是否一致，列表中没有 ETag 时查询 Stat。
+// 任意一端没有可比较的 MD5 时 ok 为 false
+func sameContent(src, dst Storage, srcInfo, dstInfo FileInfo) (same, ok bool, err error) {
+	for _, side := range []struct {
+		storage Storage
+		info    *FileInfo
+	}{{src, &srcInfo}, {dst, &dstInfo}} {
+		if side.info.ETag != "" {
+			continue
+		}
+		info, err := side.storage.Stat(side.info.Key)
+		if err != nil {
+			return false, false, err
+		}
+		*side.info = *info
+	}
+	srcMD5, dstMD5 := srcInfo.contentMD5(), dstInfo.contentMD5()
+	if srcMD5 == "" || dstMD5 == "" {
+		return false, false, nil
+	}
+	return srcMD5 == dstMD5, true, nil
+}
+
+// DriverConfig 存储驱动配置
+type DriverConfig struct {
+	Endpoint        string
+	AccessKeyId     string
+	AccessKeySecret string
+	BucketName      string
+	BaseUrl         string
+	// 驱动自定义参数
+	Params map[string]string
+}
+
+// DriverFactory 根据配置创建存储驱动
+type DriverFactory func(cfg DriverConfig) (Storage, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]DriverFactory)
+)
+
+// RegisterDriver 注册存储驱动，重复注册或 factory 为 nil 时 panic
+func RegisterDriver(name string, factory DriverFactory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if factory == nil {
+		panic("upload: register driver factory is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("upload: register called twice for driver " + name)
+	}
+	drivers[name] = factory
+}
+
+// Drivers 返回已注册的驱动名称（已排序）
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OpenStorage 根据驱动名称创建存储驱动
+func OpenStorage(name string, cfg DriverConfig) (Storage, error) {
+	driversMu.RLock()
+	factory, ok := drivers[name]
+	driversMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver %q (forgotten import?)", name)
+	}
+	return factory(cfg)
+}
@@ -0,0 +1,225 @@
+package upload
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStorage 内存存储，用于测试自定义驱动
+type memStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{objects: make(map[string][]byte)}
+}
+
+func (m *memStorage) Put(key string, reader io.Reader, _ PutOptions) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = data
+	return nil
+}
+
+func (m *memStorage) Get(key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memStorage) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.objects[key]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *memStorage) Stat(key string) (*FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return &FileInfo{Key: key, Size: int64(len(data)), ModTime: time.Now()}, nil
+}
+
+func (m *memStorage) List(prefix, cursor string, limit int) ([]FileInfo, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) && key > cursor {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var next string
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1]
+	}
+	files := make([]FileInfo, 0, len(keys))
+	for _, key := range keys {
+		files = append(files, FileInfo{Key: key, Size: int64(len(m.objects[key]))})
+	}
+	return files, next, nil
+}
+
+func TestCustomDriver(t *testing.T) {
+	mem := newMemStorage()
+	RegisterDriver("test-mem", func(cfg DriverConfig) (Storage, error) {
+		return mem, nil
+	})
+
+	svc, err := New("http://cdn.example.com", "goods", WithDriver("test-mem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileURL, err := svc.UploadFile(strings.NewReader("hello"), "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileURL != "http://cdn.example.com/goods/a.txt" {
+		t.Errorf("unexpected url: %s", fileURL)
+	}
+
+	data, err := svc.DownloadFile("goods/a.txt")
+	if err != nil || string(data) != "hello" {
+		t.Errorf("download = %q, %v", data, err)
+	}
+
+	if err = svc.DeleteFile("goods/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if len(mem.objects) != 0 {
+		t.Errorf("object not deleted")
+	}
+}
+
+func TestUnknownDriver(t *testing.T) {
+	if _, err := New("http://127.0.0.1:3000", "goods", WithDriver("nope")); err == nil {
+		t.Error("expected error for unknown driver")
+	}
+}
+
+func TestNewServiceCompat(t *testing.T) {
+	var uploader Uploader = NewService("http://cdn.example.com", "goods", WithStorage(newMemStorage()))
+	if _, err := uploader.UploadFile(strings.NewReader("hello"), "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for unknown driver")
+		}
+	}()
+	NewService("http://127.0.0.1:3000", "goods", WithDriver("nope"))
+}
+
+func TestLocalStorageList(t *testing.T) {
+	svc, err := New("http://127.0.0.1:3000", "goods", WithDriverParam(ParamRoot, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"c.txt", "a.txt", "b/b.txt"} {
+		if _, err = svc.UploadFile(strings.NewReader(name), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storage := svc.(*service).storage
+	files, next, err := storage.List("uploads/goods/", "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Key != "uploads/goods/a.txt" || files[1].Key != "uploads/goods/b/b.txt" {
+		t.Fatalf("unexpected first page: %+v", files)
+	}
+
+	files, next, err = storage.List("uploads/goods/", next, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Key != "uploads/goods/c.txt" || next != "" {
+		t.Fatalf("unexpected second page: %+v, next: %s", files, next)
+	}
+}
+
+func TestLocalStorageListOrder(t *testing.T) {
+	storage, err := newLocalStorage(DriverConfig{Params: map[string]string{ParamRoot: t.TempDir()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "a.txt" < "a/b.txt" < "a0.txt"，与目录遍历的顺序不同
+	keys := []string{"x/a/b.txt", "x/a.txt", "x/a0.txt", "x/a/c/d.txt", "y.txt"}
+	for _, key := range keys {
+		if err = storage.Put(key, strings.NewReader(key), PutOptions{ContentType: "text/plain"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var listed []string
+	cursor := ""
+	for {
+		files, next, err := storage.List("x/", cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			listed = append(listed, file.Key)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if want := []string{"x/a.txt", "x/a/b.txt", "x/a/c/d.txt", "x/a0.txt"}; strings.Join(listed, ",") != strings.Join(want, ",") {
+		t.Errorf("listed = %v, want %v", listed, want)
+	}
+}
+
+func TestLocalStorageCorruptMeta(t *testing.T) {
+	root := t.TempDir()
+	storage, err := newLocalStorage(DriverConfig{Params: map[string]string{ParamRoot: root}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.Put("a.txt", strings.NewReader("a"), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// 属性文件无法读取时返回错误，而不是当作没有属性
+	metaPath := filepath.Join(root, localMetaDir, "a.txt.json")
+	if err = os.Remove(metaPath); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(metaPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = storage.Stat("a.txt"); err == nil {
+		t.Error("expected error for unreadable meta file")
+	}
+}